@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"polychat/internal/service"
+)
+
+// runExport 执行 export 命令：导出一个会话或用户的全部会话。
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	user := fs.String("user", "", "要导出的用户（ID 或用户名），必填")
+	target := fs.Uint("target", 0, "只导出与该用户ID之间的会话，默认导出全部会话")
+	formatStr := fs.String("format", "json", "导出格式: json, html, txt")
+	out := fs.String("out", "", "输出文件路径，默认 polychat_export_<用户ID>_<日期>.zip")
+	fs.Parse(args)
+
+	if *user == "" {
+		fs.Usage()
+		return errors.New("-user 不能为空")
+	}
+	format, err := service.ParseExportFormat(*formatStr)
+	if err != nil {
+		return err
+	}
+
+	defer initDatabases()()
+
+	ctx := context.Background()
+	userID, err := resolveUser(ctx, *user)
+	if err != nil {
+		return err
+	}
+
+	exportService := service.ExportService{}
+	peers, err := exportService.Peers(ctx, userID, *target)
+	if err != nil {
+		return err
+	}
+
+	path := *out
+	if path == "" {
+		path = service.ExportFileName(userID, *target)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := exportService.Export(ctx, f, userID, peers, format); err != nil {
+		// 导出失败时删除不完整的文件
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	fmt.Printf("已导出 %d 个会话到 %s\n", len(peers), path)
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"polychat/internal/service"
+)
+
+// runImport 执行 import 命令：把 export 生成的 JSON 压缩包恢复到 MongoDB。
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	in := fs.String("in", "", "导出压缩包路径，必填")
+	mapping := fs.String("map", "", "用户ID映射，格式 旧ID=新ID[,旧ID=新ID...]；未指定的用户按用户名匹配")
+	dryRun := fs.Bool("dry-run", false, "只解析和校验，不写入数据库")
+	fs.Parse(args)
+
+	if *in == "" {
+		fs.Usage()
+		return errors.New("-in 不能为空")
+	}
+	userMap, err := parseUserMap(*mapping)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	defer initDatabases()()
+
+	importService := service.ImportService{}
+	result, err := importService.Import(context.Background(), f, info.Size(), service.ImportOptions{
+		UserMap: userMap,
+		DryRun:  *dryRun,
+		Progress: func(p service.ImportProgress) {
+			fmt.Printf("\r[%d/%d] %s 写入 %d, 重复 %d, 跳过 %d",
+				p.DoneConversations, p.TotalConversations, p.File, p.Inserted, p.Duplicates, p.Skipped)
+		},
+	})
+	fmt.Println()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("导入完成: 会话 %d 个, 写入 %d 条, 重复跳过 %d 条, 无法导入 %d 条\n",
+		result.TotalConversations, result.Inserted, result.Duplicates, result.Skipped)
+	for file, reason := range result.FailedConversations {
+		fmt.Printf("  会话 %s 导入失败: %s\n", file, reason)
+	}
+	if len(result.FailedConversations) > 0 {
+		return fmt.Errorf("%d 个会话导入失败", len(result.FailedConversations))
+	}
+	return nil
+}
+
+// parseUserMap 解析 -map 参数，例如 "3=7,4=9"。
+func parseUserMap(s string) (map[uint]uint, error) {
+	m := map[uint]uint{}
+	if s == "" {
+		return m, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		from, to, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("-map 格式错误: %q", pair)
+		}
+		oldID, err1 := strconv.ParseUint(from, 10, 64)
+		newID, err2 := strconv.ParseUint(to, 10, 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("-map 格式错误: %q", pair)
+		}
+		m[uint(oldID)] = uint(newID)
+	}
+	return m, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runJWTKey 执行 jwt-key 命令：在密钥目录中生成一个新的 JWT 签名密钥。
+// 生成后需要分发到所有节点的 POLYCHAT_JWT_KEYS_DIR 并重新加载，轮换步骤见 pkg/util/jwt_keys.go。
+func runJWTKey(args []string) error {
+	fs := flag.NewFlagSet("jwt-key", flag.ExitOnError)
+	dir := fs.String("dir", "", "密钥目录，即 POLYCHAT_JWT_KEYS_DIR，必填")
+	alg := fs.String("alg", "EdDSA", "签名算法: EdDSA, RS256")
+	kid := fs.String("kid", time.Now().Format("2006-01-02"), "密钥ID，即文件名；默认为当天日期，新密钥按字典序排在最后时自动用于签名")
+	fs.Parse(args)
+
+	if *dir == "" {
+		fs.Usage()
+		return errors.New("-dir 不能为空")
+	}
+
+	var key any
+	var err error
+	switch *alg {
+	case "EdDSA":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case "RS256":
+		key, err = rsa.GenerateKey(rand.Reader, 3072)
+	default:
+		return fmt.Errorf("不支持的签名算法 %q", *alg)
+	}
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(*dir, *kid+".pem")
+	// O_EXCL：不覆盖已有的密钥，否则用它签发的 token 会全部失效
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		return err
+	}
+	fmt.Printf("已生成 %s 密钥 %s\n", *alg, path)
+	return nil
+}
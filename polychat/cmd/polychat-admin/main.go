@@ -0,0 +1,84 @@
+// polychat-admin 是 polychat 的运维命令行工具，直接连接 MySQL 和 MongoDB 执行管理任务。
+//
+// 用法:
+//
+//	polychat-admin export -user <ID或用户名> [-target <ID>] [-format json|html|txt] [-out 文件名]
+//	polychat-admin import -in <文件名> [-map 旧ID=新ID,...] [-dry-run]
+//	polychat-admin role -user <ID或用户名> -role user|moderator|admin
+//	polychat-admin jwt-key -dir <密钥目录> [-alg EdDSA|RS256] [-kid 密钥ID]
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"polychat/internal/dao"
+	"polychat/pkg/database"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = runExport(os.Args[2:])
+	case "import":
+		err = runImport(os.Args[2:])
+	case "role":
+		err = runRole(os.Args[2:])
+	case "jwt-key":
+		err = runJWTKey(os.Args[2:])
+	case "-h", "--help", "help":
+		usage()
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "未知命令: %s\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "执行失败:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `polychat-admin <命令> [参数]
+
+命令:
+  export    导出用户的聊天记录为 zip 压缩包
+  import    从 export 生成的 json 压缩包恢复聊天记录
+  role      修改用户角色，用于指定第一个管理员
+  jwt-key   生成新的 JWT 签名密钥，用于轮换密钥
+
+使用 "polychat-admin <命令> -h" 查看命令参数`)
+}
+
+// initDatabases 初始化命令所需的数据库连接，返回清理函数。
+func initDatabases() func() {
+	database.InitDB()
+	database.InitMongoDB()
+	return database.CloseMongoDB
+}
+
+// resolveUser 把命令行中的用户参数解析为用户ID，既支持数字ID也支持用户名。
+func resolveUser(ctx context.Context, s string) (uint, error) {
+	if id, err := strconv.ParseUint(s, 10, 64); err == nil {
+		if _, err := dao.GetUserByID(ctx, uint(id)); err != nil {
+			return 0, fmt.Errorf("用户 %d 不存在", id)
+		}
+		return uint(id), nil
+	}
+	user, err := dao.GetUserByUsername(ctx, s)
+	if err != nil {
+		return 0, fmt.Errorf("用户 %q 不存在", s)
+	}
+	return user.ID, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"polychat/internal/service"
+	"polychat/pkg/database"
+)
+
+// runRole 执行 role 命令：修改用户角色。
+// 部署后还没有管理员时，只能通过这个命令指定第一个管理员，之后可以使用管理接口。
+// 命令行工具不连接集群，用户已建立的 WebSocket 连接不会被断开，但旧 token 在下一次请求时失效。
+func runRole(args []string) error {
+	fs := flag.NewFlagSet("role", flag.ExitOnError)
+	user := fs.String("user", "", "目标用户（ID 或用户名），必填")
+	role := fs.String("role", "", "新角色: user, moderator, admin，必填")
+	fs.Parse(args)
+
+	if *user == "" || *role == "" {
+		fs.Usage()
+		return errors.New("-user 和 -role 不能为空")
+	}
+
+	database.InitDB()
+	defer database.CloseDB()
+
+	ctx := context.Background()
+	userID, err := resolveUser(ctx, *user)
+	if err != nil {
+		return err
+	}
+	roleService := service.RoleService{}
+	if err := roleService.SetRole(ctx, 0, userID, *role, ""); err != nil {
+		return err
+	}
+	fmt.Printf("已将用户 %d 的角色修改为 %s\n", userID, *role)
+	return nil
+}
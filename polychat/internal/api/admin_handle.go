@@ -0,0 +1,337 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"polychat/internal/dao"
+	"polychat/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetRoleRequest 修改用户角色请求参数
+type SetRoleRequest struct {
+	UserID uint   `json:"user_id" binding:"required"` //目标用户ID
+	Role   string `json:"role" binding:"required"`    //新角色：user、moderator、admin
+}
+
+// maxAdminKeywordLen 查询用户时关键词的最大字符数，与邮箱字段长度一致
+const maxAdminKeywordLen = 100
+
+// AdminUserRequest 只需要指定目标用户的管理操作（解封、删除、恢复）请求参数
+type AdminUserRequest struct {
+	UserID uint `json:"user_id" binding:"required"` //目标用户ID
+}
+
+// DisconnectRequest 强制下线请求参数
+type DisconnectRequest struct {
+	UserID uint `json:"user_id" binding:"required"` //目标用户ID
+	Revoke bool `json:"revoke"`                     //是否同时吊销用户的全部 token，吊销后需要重新登录
+}
+
+// SuspendRequest 停用或封禁账号请求参数
+type SuspendRequest struct {
+	UserID   uint   `json:"user_id" binding:"required"` //目标用户ID
+	Duration string `json:"duration"`                   //封禁时长，如 "24h"、"30m"；为空表示停用，直到手动恢复
+	Reason   string `json:"reason"`                     //原因，登录时展示给用户
+}
+
+// AdminResetPasswordRequest 管理员重置密码请求参数
+type AdminResetPasswordRequest struct {
+	UserID    uint `json:"user_id" binding:"required"` //目标用户ID
+	SendEmail bool `json:"send_email"`                 //为 true 时向用户已验证的邮箱发送重置链接，否则生成临时密码
+}
+
+// AdminHandle 管理接口处理器，所有接口都需要相应的权限，见 middleware.RequirePermission
+type AdminHandle struct {
+	roleService  service.RoleService
+	adminService service.AdminService
+}
+
+// SearchUsers 查询用户列表，需要 users:view 权限。
+// 查询参数：keyword（用户名、邮箱或用户ID）、role、suspended=true、deleted=true（包括已删除的用户）、page、page_size
+//
+// 路由：GET /api/v1/admin/users
+func (h *AdminHandle) SearchUsers(c *gin.Context) {
+	actor, ok := adminActor(c)
+	if !ok {
+		return
+	}
+	page, pageSize, ok := pageQuery(c)
+	if !ok {
+		return
+	}
+
+	keyword := strings.TrimSpace(c.Query("keyword"))
+	if utf8.RuneCountInString(keyword) > maxAdminKeywordLen {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "keyword 过长"})
+		return
+	}
+
+	result, err := h.adminService.SearchUsers(c.Request.Context(), actor, dao.UserSearchQuery{
+		Keyword:        keyword,
+		Role:           c.Query("role"),
+		Suspended:      c.Query("suspended") == "true",
+		IncludeDeleted: c.Query("deleted") == "true",
+		Page:           page,
+		PageSize:       pageSize,
+	})
+	if err != nil {
+		writeAdminError(c, "查询用户失败", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "data": result})
+}
+
+// GetUserSessions 查看用户的登录会话和当前连接，需要 users:view 权限
+//
+// 路由：GET /api/v1/admin/users/sessions?user_id=
+func (h *AdminHandle) GetUserSessions(c *gin.Context) {
+	actor, ok := adminActor(c)
+	if !ok {
+		return
+	}
+	userID, err := strconv.ParseUint(c.Query("user_id"), 10, 64)
+	if err != nil || userID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "user_id 格式错误"})
+		return
+	}
+
+	result, err := h.adminService.UserSessions(c.Request.Context(), actor, uint(userID))
+	if err != nil {
+		writeAdminError(c, "查询会话失败", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "data": result})
+}
+
+// DisconnectUser 强制用户下线，需要 sessions:manage 权限
+//
+// 路由：POST /api/v1/admin/users/disconnect
+func (h *AdminHandle) DisconnectUser(c *gin.Context) {
+	var req DisconnectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "参数错误 : " + err.Error()})
+		return
+	}
+	actor, ok := adminActor(c)
+	if !ok {
+		return
+	}
+
+	if err := h.adminService.Disconnect(c.Request.Context(), actor, req.UserID, req.Revoke); err != nil {
+		writeAdminError(c, "强制下线失败", err)
+		return
+	}
+	msg := "已断开该用户的连接"
+	if req.Revoke {
+		msg = "已断开该用户的连接并吊销全部登录"
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": msg})
+}
+
+// SuspendUser 停用或封禁账号，需要 users:manage 权限
+//
+// 路由：POST /api/v1/admin/users/suspend
+func (h *AdminHandle) SuspendUser(c *gin.Context) {
+	var req SuspendRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "参数错误 : " + err.Error()})
+		return
+	}
+	var duration time.Duration
+	if req.Duration != "" {
+		var err error
+		if duration, err = time.ParseDuration(req.Duration); err != nil || duration <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "duration 格式错误，例如 24h"})
+			return
+		}
+	}
+	actor, ok := adminActor(c)
+	if !ok {
+		return
+	}
+
+	if err := h.adminService.Suspend(c.Request.Context(), actor, req.UserID, duration, strings.TrimSpace(req.Reason)); err != nil {
+		writeAdminError(c, "停用账号失败", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "账号已停用"})
+}
+
+// UnsuspendUser 恢复被停用或封禁的账号，需要 users:manage 权限
+//
+// 路由：POST /api/v1/admin/users/unsuspend
+func (h *AdminHandle) UnsuspendUser(c *gin.Context) {
+	h.userAction(c, "恢复账号失败", "账号已恢复", h.adminService.Unsuspend)
+}
+
+// DeleteUser 删除用户（软删除），需要 users:manage 权限
+//
+// 路由：POST /api/v1/admin/users/delete
+func (h *AdminHandle) DeleteUser(c *gin.Context) {
+	h.userAction(c, "删除用户失败", "用户已删除", h.adminService.Delete)
+}
+
+// RestoreUser 恢复已删除的用户，需要 users:manage 权限
+//
+// 路由：POST /api/v1/admin/users/restore
+func (h *AdminHandle) RestoreUser(c *gin.Context) {
+	h.userAction(c, "恢复用户失败", "用户已恢复", h.adminService.Restore)
+}
+
+// userAction 处理只需要目标用户ID的管理操作
+func (h *AdminHandle) userAction(c *gin.Context, failMsg, okMsg string, action func(context.Context, service.AdminActor, uint) error) {
+	var req AdminUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "参数错误 : " + err.Error()})
+		return
+	}
+	actor, ok := adminActor(c)
+	if !ok {
+		return
+	}
+
+	if err := action(c.Request.Context(), actor, req.UserID); err != nil {
+		writeAdminError(c, failMsg, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": okMsg})
+}
+
+// ResetUserPassword 重置用户密码，需要 users:manage 权限。
+// 生成临时密码时 data.password 为临时密码，只返回这一次
+//
+// 路由：POST /api/v1/admin/users/reset_password
+func (h *AdminHandle) ResetUserPassword(c *gin.Context) {
+	var req AdminResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "参数错误 : " + err.Error()})
+		return
+	}
+	actor, ok := adminActor(c)
+	if !ok {
+		return
+	}
+
+	password, err := h.adminService.ResetPassword(c.Request.Context(), actor, req.UserID, req.SendEmail)
+	if err != nil {
+		writeAdminError(c, "重置密码失败", err)
+		return
+	}
+	if req.SendEmail {
+		c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "已向该用户的邮箱发送重置链接"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "密码已重置，请将临时密码转交给用户", "data": gin.H{"password": password}})
+}
+
+// ListAuditLogs 查询审计日志，需要 audit:view 权限。
+// 查询参数：user_id（涉及的用户）、actor_id（操作人）、action、page、page_size
+//
+// 路由：GET /api/v1/admin/audit_logs
+func (h *AdminHandle) ListAuditLogs(c *gin.Context) {
+	actor, ok := adminActor(c)
+	if !ok {
+		return
+	}
+	page, pageSize, ok := pageQuery(c)
+	if !ok {
+		return
+	}
+	q := dao.AuditLogQuery{Action: c.Query("action"), Page: page, PageSize: pageSize}
+	for name, field := range map[string]*uint{"user_id": &q.UserID, "actor_id": &q.ActorID} {
+		if s := c.Query(name); s != "" {
+			id, err := strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": name + " 格式错误"})
+				return
+			}
+			*field = uint(id)
+		}
+	}
+
+	result, err := h.adminService.AuditLogs(c.Request.Context(), actor, q)
+	if err != nil {
+		writeAdminError(c, "查询审计日志失败", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "data": result})
+}
+
+// SetUserRole 修改用户角色，需要 roles:manage 权限
+//
+// 路由：POST /api/v1/admin/users/role
+func (h *AdminHandle) SetUserRole(c *gin.Context) {
+	var req SetRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "参数错误 : " + err.Error()})
+		return
+	}
+
+	actorID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": "用户未登录"})
+		return
+	}
+
+	if err := h.roleService.SetRole(c.Request.Context(), actorID.(uint), req.UserID, req.Role, c.ClientIP()); err != nil {
+		writeAdminError(c, "修改角色失败", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "角色已修改，该用户需要重新登录"})
+}
+
+// adminActor 返回当前执行管理操作的用户，未登录时写入 401 响应并返回 false
+func adminActor(c *gin.Context) (service.AdminActor, bool) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": "用户未登录"})
+		return service.AdminActor{}, false
+	}
+	return service.AdminActor{ID: userID.(uint), Role: c.GetString("role"), IP: c.ClientIP()}, true
+}
+
+// pageQuery 解析可选的 page 和 page_size 查询参数，格式错误时写入 400 响应并返回 false
+func pageQuery(c *gin.Context) (page, pageSize int, ok bool) {
+	var err error
+	if s := c.Query("page"); s != "" {
+		if page, err = strconv.Atoi(s); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "page 格式错误"})
+			return 0, 0, false
+		}
+	}
+	if s := c.Query("page_size"); s != "" {
+		if pageSize, err = strconv.Atoi(s); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "page_size 格式错误"})
+			return 0, 0, false
+		}
+	}
+	return page, pageSize, true
+}
+
+// writeAdminError 把管理操作的错误转换为响应，业务错误返回 400，其他错误返回 500
+func writeAdminError(c *gin.Context, msg string, err error) {
+	if errors.Is(err, service.ErrInvalidRole) ||
+		errors.Is(err, service.ErrChangeOwnRole) ||
+		errors.Is(err, service.ErrTargetNotFound) ||
+		errors.Is(err, service.ErrActOnSelf) ||
+		errors.Is(err, service.ErrTargetProtected) ||
+		errors.Is(err, service.ErrInvalidDuration) ||
+		errors.Is(err, service.ErrUserNotDeleted) ||
+		errors.Is(err, service.ErrUserDeleted) ||
+		errors.Is(err, service.ErrNoVerifiedEmail) ||
+		errors.Is(err, service.ErrSuspendReasonLen) {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": msg + " : " + err.Error()})
+		return
+	}
+	slog.ErrorContext(c.Request.Context(), msg, "err", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": msg})
+}
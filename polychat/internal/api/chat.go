@@ -4,6 +4,7 @@ package api
 import (
 	"net/http"
 	"polychat/internal/ws"
+	"polychat/pkg/metrics"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -29,6 +30,14 @@ func ConnectWS(c *gin.Context) {
 	}
 	// 断言转换为uint类型
 	userID := uid.(uint)
+	// 服务正在关闭时拒绝新的连接
+	if ws.ClientMgr.Draining() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"code": "503",
+			"msg":  "服务正在关闭，请稍后重连",
+		})
+		return
+	}
 	//将HTTP连接升级为WebSocket连接
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -39,13 +48,15 @@ func ConnectWS(c *gin.Context) {
 		return
 	}
 	//注册登录
-	ws.ClientMgr.Register(userID, conn)
+	ctx, cancel := connContext(c)
+	ws.ClientMgr.Register(ctx, userID, conn, c.ClientIP())
 
 	//go协程处理连接
 	go func() {
+		defer cancel()
 		//注销连接
 		defer func() {
-			ws.ClientMgr.UnRegister(userID)
+			ws.ClientMgr.UnRegister(userID, conn)
 		}()
 
 		//读取信息
@@ -61,6 +72,17 @@ func ConnectWS(c *gin.Context) {
 			if msg.Type == "" {
 				msg.Type = ws.TypeChat
 			}
+			// 不支持的类型不计入消息指标，拒绝通知同样受频率限制
+			if !ws.ClientFrameAllowed(msg.Type) {
+				if allowFrame(ctx, userID, msg.Type) {
+					rejectFrame(userID, msg.Type)
+				}
+				continue
+			}
+			metrics.CountMessage(msg.Type, metrics.MessageReceived)
+			if !allowFrame(ctx, userID, msg.Type) || msg.Type == ws.TypeHeartbeat {
+				continue
+			}
 			//发送信息
 			ws.ClientMgr.SendMessage(msg)
 		}
@@ -9,14 +9,22 @@
 package api
 
 import (
-	"fmt"
+	"context"
+	"log/slog"
 	"net/http"
 	"polychat/internal/service"
 	"polychat/internal/ws"
+	"polychat/pkg/logger"
+	"polychat/pkg/metrics"
+	"polychat/pkg/ratelimit"
+	"polychat/pkg/telemetry"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // wsUpgrader 是 WebSocket 升级器，与 chat.go 中的 upgrader 配置一致。
@@ -31,6 +39,15 @@ var wsUpgrader = websocket.Upgrader{
 // msgService 是消息业务服务的包级实例，供 ConnectWSWithHistory 使用。
 var msgService = service.MessageService{}
 
+// connContext 为新的 WebSocket 连接创建 context：
+// 继承升级请求的日志字段（request_id、user_id）和链路，附加 conn_id。
+// 它不随升级请求结束而取消，而是在连接断开时由读协程调用返回的 cancel 取消，
+// 使连接断开（包括服务关闭时主动断开）后仍在执行的数据库查询能够及时中止。
+func connContext(c *gin.Context) (context.Context, context.CancelFunc) {
+	ctx := context.WithoutCancel(c.Request.Context())
+	return context.WithCancel(logger.With(ctx, "conn_id", logger.NewID()))
+}
+
 // ConnectWSWithHistory 处理 WebSocket 连接请求，并在消息转发时自动持久化到 MongoDB。
 // 该函数的工作流程与原有 ConnectWS 完全一致，仅在发送消息前增加了持久化步骤：
 //  1. 验证用户身份（从 JWT 中间件获取 userID）
@@ -55,6 +72,15 @@ func ConnectWSWithHistory(c *gin.Context) {
 	// 断言转换为 uint 类型
 	userID := uid.(uint)
 
+	// 服务正在关闭时拒绝新的连接，客户端应重连到其他节点
+	if ws.ClientMgr.Draining() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"code": "503",
+			"msg":  "服务正在关闭，请稍后重连",
+		})
+		return
+	}
+
 	// 将 HTTP 连接升级为 WebSocket 连接
 	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -66,13 +92,17 @@ func ConnectWSWithHistory(c *gin.Context) {
 	}
 
 	// 注册到全局客户端管理器（复用已有的 ws.ClientMgr）
-	ws.ClientMgr.Register(userID, conn)
+	ctx, cancel := connContext(c)
+	ws.ClientMgr.Register(ctx, userID, conn, c.ClientIP())
 
 	// 启动 goroutine 处理连接
 	go func() {
+		// 注销之后再取消连接 context
+		defer cancel()
 		// 连接关闭时注销
 		defer func() {
-			ws.ClientMgr.UnRegister(userID)
+			ws.Typing.Clear(userID)
+			ws.ClientMgr.UnRegister(userID, conn)
 		}()
 
 		// 循环读取消息
@@ -82,28 +112,161 @@ func ConnectWSWithHistory(c *gin.Context) {
 			if err != nil {
 				break
 			}
+			handleFrame(ctx, userID, msg)
+		}
+	}()
+}
 
-			// 防止 ID 伪造（与原逻辑一致）
-			msg.SenderID = userID
-			msg.Timestamp = time.Now().Unix()
-			if msg.Type == "" {
-				msg.Type = ws.TypeChat
-			}
+// handleFrame 处理客户端发来的一帧消息。
+// 每帧生成一个独立的根 span，并链接到建立连接时的 HTTP span，
+// 避免长连接上的所有消息都挂在同一条链路下。
+func handleFrame(ctx context.Context, userID uint, msg ws.Message) {
+	// 防止 ID 伪造（与原逻辑一致）
+	msg.SenderID = userID
+	msg.Timestamp = time.Now().Unix()
+	if msg.Type == "" {
+		msg.Type = ws.TypeChat
+	}
+	// 不支持的类型不计入消息指标，拒绝通知同样受频率限制
+	if !ws.ClientFrameAllowed(msg.Type) {
+		if allowFrame(ctx, userID, msg.Type) {
+			rejectFrame(userID, msg.Type)
+		}
+		return
+	}
+	metrics.CountMessage(msg.Type, metrics.MessageReceived)
+
+	ctx, span := telemetry.Tracer().Start(ctx, "WS "+msg.Type,
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(ctx)),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("polychat.message.type", msg.Type),
+			attribute.Int64("polychat.sender_id", int64(msg.SenderID)),
+			attribute.Int64("polychat.receiver_id", int64(msg.ReceiverID)),
+		))
+	defer span.End()
+
+	if !allowFrame(ctx, userID, msg.Type) {
+		span.SetAttributes(attribute.Bool("polychat.rate_limited", true))
+		return
+	}
 
-			// 【新增】将消息持久化到 MongoDB（异步，不阻塞消息转发）
-			// 即使持久化失败，消息仍然会被转发给在线用户
-			go func(m ws.Message) {
-				if err := msgService.SaveMessage(m); err != nil {
-					fmt.Printf("[MongoDB] 消息持久化失败: type=%s sender=%d receiver=%d err=%v\n",
-						m.Type, m.SenderID, m.ReceiverID, err)
-				} else {
-					fmt.Printf("[MongoDB] 消息持久化成功: sender=%d receiver=%d\n",
-						m.SenderID, m.ReceiverID)
-				}
-			}(msg)
-
-			// 发送消息给接收方（复用已有的 ws.ClientMgr）
-			ws.ClientMgr.SendMessage(msg)
+	// 不需要持久化的控制帧单独处理
+	switch msg.Type {
+	case ws.TypeHeartbeat:
+		return
+	case ws.TypeRead:
+		// 已读上报只用于阅后即焚计时，不转发也不持久化
+		if err := msgService.MarkRead(ctx, userID, msg.ID); err != nil {
+			span.RecordError(err)
+			slog.WarnContext(ctx, "处理已读上报失败", "msg_id", msg.ID, "err", err)
 		}
-	}()
+		return
+	case ws.TypeTypingStart:
+		ws.Typing.Start(ctx, msg)
+		return
+	case ws.TypeTypingStop:
+		ws.Typing.Stop(msg)
+		return
+	}
+
+	// 分配消息ID并应用会话的阅后即焚设置
+	msgService.PrepareMessage(ctx, &msg)
+	span.SetAttributes(attribute.String("polychat.message.id", msg.ID))
+
+	// 【新增】将消息持久化到 MongoDB（异步，不阻塞消息转发）
+	// 即使持久化失败，消息仍然会被转发给在线用户。
+	// 阅后即焚消息同步保存：接收方可能在收到后立即上报已读，消息必须已经写入，否则已读会丢失；
+	// 保存失败的阅后即焚消息无法按时删除，不转发，并通知发送方。
+	// 服务关闭期间不再接受异步写入，这时的消息同样不转发，并通知发送方
+	var err error
+	if msg.ExpiresIn > 0 {
+		err = msgService.SaveMessage(ctx, msg)
+	} else {
+		err = msgService.SaveMessageAsync(ctx, msg)
+	}
+	if err != nil {
+		// 失败原因已由 MessageService 记录日志和指标
+		span.RecordError(err)
+		ws.ClientMgr.SendMessageTo(userID, ws.Message{
+			Type:       ws.TypeError,
+			ReceiverID: userID,
+			Timestamp:  time.Now().Unix(),
+			Error:      &ws.ErrorInfo{Code: ws.ErrorSaveFailed, Type: msg.Type},
+		})
+		return
+	}
+
+	// 发送消息给接收方（复用已有的 ws.ClientMgr）
+	ws.ClientMgr.SendMessage(msg)
+
+	// 告知发送方服务器分配的消息ID，便于之后处理撤回等通知
+	if msg.Type == ws.TypeChat {
+		ws.ClientMgr.SendMessageTo(userID, ws.Message{
+			ID:         msg.ID,
+			Type:       ws.TypeAck,
+			SenderID:   msg.SenderID,
+			ReceiverID: msg.ReceiverID,
+			Timestamp:  msg.Timestamp,
+			ExpiresIn:  msg.ExpiresIn,
+		})
+	}
+}
+
+// rejectFrame 向发送方推送 error 通知：客户端不能发送该类型的消息
+func rejectFrame(userID uint, msgType string) {
+	ws.ClientMgr.SendMessageTo(userID, ws.Message{
+		Type:       ws.TypeError,
+		ReceiverID: userID,
+		Timestamp:  time.Now().Unix(),
+		Error:      &ws.ErrorInfo{Code: ws.ErrorUnsupportedType, Type: msgType},
+	})
+}
+
+// frameLimits 各类消息的发送频率限制，按用户计算，同一用户的多个连接共享额度。
+// 可通过环境变量 POLYCHAT_RATELIMIT_WS_<类型> 覆盖，例如 POLYCHAT_RATELIMIT_WS_CHAT=10/s:30。
+var frameLimits = map[string]ratelimit.Limit{
+	ws.TypeChat:        ratelimit.Rule("ws_chat", ratelimit.Limit{Rate: 5, Burst: 20}),
+	ws.TypeHeartbeat:   ratelimit.Rule("ws_heartbeat", ratelimit.Limit{Rate: 1, Burst: 5}),
+	ws.TypeRead:        ratelimit.Rule("ws_read", ratelimit.Limit{Rate: 20, Burst: 100}),
+	ws.TypeTypingStart: ratelimit.Rule("ws_typing_start", ratelimit.Limit{Rate: 2, Burst: 5}),
+	ws.TypeTypingStop:  ratelimit.Rule("ws_typing_stop", ratelimit.Limit{Rate: 2, Burst: 5}),
+}
+
+// defaultFrameLimit 其他类型消息共用的限制（POLYCHAT_RATELIMIT_WS_DEFAULT）
+var defaultFrameLimit = ratelimit.Rule("ws_default", ratelimit.Limit{Rate: 5, Burst: 20})
+
+// allowFrame 检查用户发送该类型消息是否超出频率限制。
+// 超出时向发送方推送 error 通知并返回 false；限流后端出错时放行。
+func allowFrame(ctx context.Context, userID uint, msgType string) bool {
+	rule, limit := msgType, defaultFrameLimit
+	if l, ok := frameLimits[msgType]; ok {
+		limit = l
+	} else {
+		// 未知类型共用一个令牌桶，防止客户端用任意类型名绕过限制
+		rule = "default"
+	}
+
+	res, err := ratelimit.Default.Allow(ctx, "ws:"+rule+":user:"+strconv.FormatUint(uint64(userID), 10), limit)
+	if err != nil {
+		slog.WarnContext(ctx, "限流检查失败，放行消息", "type", msgType, "err", err)
+		return true
+	}
+	if res.Allowed {
+		return true
+	}
+
+	metrics.RateLimited.WithLabelValues("ws_" + rule).Inc()
+	ws.ClientMgr.SendMessageTo(userID, ws.Message{
+		Type:       ws.TypeError,
+		ReceiverID: userID,
+		Timestamp:  time.Now().Unix(),
+		Error: &ws.ErrorInfo{
+			Code:       ws.ErrorRateLimited,
+			Type:       msgType,
+			RetryAfter: res.RetryAfter.Milliseconds(),
+		},
+	})
+	return false
 }
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"polychat/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EmailRequest 绑定邮箱请求参数
+type EmailRequest struct {
+	Email string `json:"email" binding:"required"` //新邮箱
+}
+
+// VerifyEmailRequest 验证邮箱请求参数
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"` //邮件中的验证 token
+}
+
+// UpdateEmail 绑定或修改邮箱，并发送验证邮件
+func (h *UserHandle) UpdateEmail(c *gin.Context) {
+	var req EmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "参数错误 : " + err.Error()})
+		return
+	}
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": "用户未登录"})
+		return
+	}
+
+	if err := h.emailService.SetEmail(c.Request.Context(), userID.(uint), req.Email); err != nil {
+		writeEmailError(c, "绑定邮箱失败", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "验证邮件已发送，请查收"})
+}
+
+// ResendEmailVerification 重新发送验证邮件
+func (h *UserHandle) ResendEmailVerification(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": "用户未登录"})
+		return
+	}
+
+	if err := h.emailService.ResendVerification(c.Request.Context(), userID.(uint)); err != nil {
+		writeEmailError(c, "发送验证邮件失败", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "验证邮件已发送，请查收"})
+}
+
+// VerifyEmail 使用邮件中的 token 完成邮箱验证，不需要登录
+func (h *UserHandle) VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "参数错误 : " + err.Error()})
+		return
+	}
+
+	if err := h.emailService.Verify(c.Request.Context(), req.Token); err != nil {
+		writeEmailError(c, "邮箱验证失败", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "邮箱验证成功"})
+}
+
+// writeEmailError 把邮箱相关的错误转换为响应，业务错误返回 400，其他错误返回 500
+func writeEmailError(c *gin.Context, msg string, err error) {
+	if writeValidationError(c, msg, err) {
+		return
+	}
+	if errors.Is(err, service.ErrEmailNotSet) ||
+		errors.Is(err, service.ErrEmailVerified) ||
+		errors.Is(err, service.ErrInvalidVerifyToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": msg + " : " + err.Error()})
+		return
+	}
+	slog.ErrorContext(c.Request.Context(), msg, "err", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": msg})
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"polychat/internal/ws"
+	"polychat/pkg/database"
+	"polychat/pkg/version"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readyCheckTimeout 就绪检查中单个依赖的超时时间
+const readyCheckTimeout = 2 * time.Second
+
+// errNotInitialized 依赖尚未完成初始化
+var errNotInitialized = errors.New("not initialized")
+
+// Healthz 存活检查，进程能处理请求即返回 200，不检查外部依赖。
+//
+// 路由：GET /healthz
+// 返回示例：{"code": 200, "msg": "ok"}
+func Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"msg":  "ok",
+	})
+}
+
+// Readyz 就绪检查：依次 ping MySQL、MongoDB 和节点间 Broker，全部可用时返回 200，否则返回 503。
+// 服务正在关闭时也返回 503，让负载均衡尽快把流量切走。
+//
+// 路由：GET /readyz
+// 返回示例：
+//
+//	{
+//	  "code": 503,
+//	  "msg": "not ready",
+//	  "checks": {"mysql": "ok", "mongodb": "ok", "broker": "dial tcp ...: connection refused"}
+//	}
+func Readyz(c *gin.Context) {
+	checks := gin.H{}
+	ready := true
+	check := func(name string, ping func(ctx context.Context) error) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), readyCheckTimeout)
+		defer cancel()
+		if err := ping(ctx); err != nil {
+			checks[name] = err.Error()
+			ready = false
+			return
+		}
+		checks[name] = "ok"
+	}
+
+	check("mysql", func(ctx context.Context) error {
+		if database.DB == nil {
+			return errNotInitialized
+		}
+		sqlDB, err := database.DB.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.PingContext(ctx)
+	})
+	check("mongodb", func(ctx context.Context) error {
+		if database.MongoClient == nil {
+			return errNotInitialized
+		}
+		return database.MongoClient.Ping(ctx, nil)
+	})
+	if ws.ClientMgr.Broker != nil {
+		check("broker", ws.ClientMgr.Broker.Ping)
+	}
+
+	if ws.ClientMgr.Draining() {
+		checks["server"] = "shutting down"
+		ready = false
+	}
+
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"code":   503,
+			"msg":    "not ready",
+			"checks": checks,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":   200,
+		"msg":    "ready",
+		"checks": checks,
+	})
+}
+
+// Version 返回构建信息。
+//
+// 路由：GET /version
+// 返回示例：
+//
+//	{
+//	  "code": 200,
+//	  "msg": "ok",
+//	  "data": {"version": "v1.2.0", "commit": "aa4c3af...", "modified": false, "go_version": "go1.25.3"}
+//	}
+func Version(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"msg":  "ok",
+		"data": version.Get(),
+	})
+}
@@ -0,0 +1,20 @@
+package api
+
+import (
+	"net/http"
+
+	"polychat/pkg/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS 公开验证 polychat token 的公钥，其他服务据此验证 token 签名，按 token 头部的 kid 选择公钥。
+// 同一密钥还签发两步验证、重置密码等非会话 token，验证方必须同时校验 aud 为 util.SessionAudience（"polychat"）。
+// 响应允许缓存 5 分钟，轮换密钥时新密钥应至少提前这么久加入密钥目录。
+//
+// 路由：GET /.well-known/jwks.json
+// 返回示例：{"keys": [{"kty": "OKP", "kid": "2026-10-19", "use": "sig", "alg": "EdDSA", "crv": "Ed25519", "x": "..."}]}
+func JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, util.JWKS())
+}
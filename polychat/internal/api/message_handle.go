@@ -1,9 +1,15 @@
 // Package api 提供 HTTP/WebSocket 请求处理器。
 // 本文件负责处理聊天消息历史记录的 REST API 请求。
-// 提供 GET /api/v1/message/history 接口，支持分页查询两个用户之间的历史消息。
+// 提供 GET /api/v1/message/history 接口，支持分页查询两个用户之间的历史消息；
+// 提供 GET /api/v1/message/search 接口，支持在自己参与的会话中全文搜索消息；
+// 提供 GET /api/v1/message/export 接口，支持把聊天记录导出为 zip 压缩包；
+// 提供 GET/POST /api/v1/message/retention 接口，查询和设置会话的消息保留天数；
+// 提供 GET/POST /api/v1/message/disappearing 接口，查询和设置会话的阅后即焚。
 package api
 
 import (
+	"errors"
+	"log/slog"
 	"net/http"
 	"strconv"
 
@@ -15,7 +21,9 @@ import (
 
 // MessageHandle 消息相关的 HTTP 请求处理器。
 type MessageHandle struct {
-	messageService service.MessageService
+	messageService   service.MessageService
+	exportService    service.ExportService
+	retentionService service.RetentionService
 }
 
 // GetHistory 获取当前用户与指定好友之间的聊天历史记录。
@@ -77,7 +85,7 @@ func (h *MessageHandle) GetHistory(c *gin.Context) {
 	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "50"))
 
 	// 调用 service 层获取历史消息
-	messages, total, err := h.messageService.GetHistory(userID, uint(targetID), page, pageSize)
+	messages, total, err := h.messageService.GetHistory(c.Request.Context(), userID, uint(targetID), page, pageSize)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code": 500,
@@ -101,3 +109,373 @@ func (h *MessageHandle) GetHistory(c *gin.Context) {
 		},
 	})
 }
+
+// SearchMessages 在当前用户参与的会话中按关键词搜索聊天记录。
+//
+// 请求方式: GET /api/v1/message/search
+// 请求参数 (Query):
+//   - keyword:    必填，搜索关键词，多个关键词用空格分隔
+//   - target_id:  可选，只搜索与该好友之间的会话
+//   - start_time: 可选，起始时间（Unix 秒）
+//   - end_time:   可选，结束时间（Unix 秒）
+//   - cursor:     可选，上一页返回的 next_cursor
+//   - limit:      可选，每页条数（默认 20，最大 50）
+//
+// 响应格式:
+//
+//	{
+//	    "code": 200,
+//	    "data": {
+//	        "results": [            // 命中结果，按时间倒序
+//	            {"id": "...", "sender_id": 1, ..., "snippet": "...<mark>关键词</mark>..."}
+//	        ],
+//	        "next_cursor": "..."    // 下一页游标，为空表示没有更多结果
+//	    }
+//	}
+//
+// 错误响应:
+//
+//	{"code": 400, "msg": "keyword 不能为空"}
+//	{"code": 500, "msg": "搜索消息失败"}
+func (h *MessageHandle) SearchMessages(c *gin.Context) {
+	uid, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"msg":  "用户未登录",
+		})
+		return
+	}
+	userID := uid.(uint)
+
+	keyword := c.Query("keyword")
+	if keyword == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"msg":  "keyword 不能为空",
+		})
+		return
+	}
+
+	// 解析可选的会话过滤条件和时间范围
+	var targetID uint64
+	if s := c.Query("target_id"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code": 400,
+				"msg":  "target_id 格式错误",
+			})
+			return
+		}
+		targetID = id
+	}
+	startTime, err := strconv.ParseInt(c.DefaultQuery("start_time", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"msg":  "start_time 格式错误",
+		})
+		return
+	}
+	endTime, err := strconv.ParseInt(c.DefaultQuery("end_time", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"msg":  "end_time 格式错误",
+		})
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+
+	hits, nextCursor, err := h.messageService.SearchMessages(c.Request.Context(), userID, keyword, uint(targetID),
+		startTime, endTime, c.Query("cursor"), limit)
+	if err != nil {
+		// 参数类错误直接返回给前端，数据库错误统一提示
+		if errors.Is(err, service.ErrSearchKeywordEmpty) ||
+			errors.Is(err, service.ErrSearchTimeRange) ||
+			errors.Is(err, service.ErrSearchCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code": 400,
+				"msg":  err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code": 500,
+			"msg":  "搜索消息失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": gin.H{
+			"results":     hits,
+			"next_cursor": nextCursor,
+		},
+	})
+}
+
+// ExportHistory 导出当前用户的聊天记录为 zip 压缩包。
+//
+// 请求方式: GET /api/v1/message/export
+// 请求参数 (Query):
+//   - target_id: 可选，只导出与该好友之间的会话；不传则导出全部会话
+//   - format:    可选，json / html / txt（默认 json，只有 json 格式可以再导入）
+//
+// 成功时直接返回 application/zip 文件流（Content-Disposition: attachment）。
+//
+// 错误响应:
+//
+//	{"code": 400, "msg": "不支持的导出格式，可选值: json, html, txt"}
+//	{"code": 404, "msg": "没有可导出的聊天记录"}
+//	{"code": 500, "msg": "导出失败"}
+func (h *MessageHandle) ExportHistory(c *gin.Context) {
+	uid, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"msg":  "用户未登录",
+		})
+		return
+	}
+	userID := uid.(uint)
+
+	format, err := service.ParseExportFormat(c.Query("format"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"msg":  err.Error(),
+		})
+		return
+	}
+
+	var targetID uint64
+	if s := c.Query("target_id"); s != "" {
+		targetID, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code": 400,
+				"msg":  "target_id 格式错误",
+			})
+			return
+		}
+	}
+
+	// 先确定要导出的会话，出错时还可以返回 JSON 错误
+	peers, err := h.exportService.Peers(c.Request.Context(), userID, uint(targetID))
+	if err != nil {
+		if errors.Is(err, service.ErrExportEmpty) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"code": 404,
+				"msg":  err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code": 500,
+			"msg":  "导出失败",
+		})
+		return
+	}
+
+	// 开始流式写入 zip，此后响应头已发出，出错只能记录日志并中断连接
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="`+service.ExportFileName(userID, uint(targetID))+`"`)
+	c.Status(http.StatusOK)
+	if err := h.exportService.Export(c.Request.Context(), c.Writer, userID, peers, format); err != nil {
+		slog.ErrorContext(c.Request.Context(), "聊天记录导出失败", "target_id", targetID, "err", err)
+		c.Abort()
+	}
+}
+
+// SetRetentionReq 设置会话保留策略请求参数
+type SetRetentionReq struct {
+	TargetID      uint `json:"target_id" binding:"required"`
+	RetentionDays *int `json:"retention_days" binding:"required"` // 0 表示关闭自动删除
+}
+
+// GetRetention 获取与指定好友之间会话的消息保留天数。
+//
+// 请求方式: GET /api/v1/message/retention?target_id=2
+//
+// 响应格式:
+//
+//	{"code": 200, "data": {"target_id": 2, "retention_days": 7}}
+func (h *MessageHandle) GetRetention(c *gin.Context) {
+	uid, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"msg":  "用户未登录",
+		})
+		return
+	}
+	userID := uid.(uint)
+
+	targetID, err := strconv.ParseUint(c.Query("target_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"msg":  "target_id 格式错误",
+		})
+		return
+	}
+
+	days, err := h.retentionService.GetConversationRetention(c.Request.Context(), userID, uint(targetID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code": 500,
+			"msg":  "查询保留策略失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": gin.H{
+			"target_id":      targetID,
+			"retention_days": days,
+		},
+	})
+}
+
+// SetRetention 设置与指定好友之间会话的消息保留天数，对会话双方同时生效。
+//
+// 请求方式: POST /api/v1/message/retention
+// 请求体:
+//
+//	{"target_id": 2, "retention_days": 7}   // retention_days 为 0 表示关闭
+//
+// 响应格式:
+//
+//	{"code": 200, "msg": "设置成功"}
+func (h *MessageHandle) SetRetention(c *gin.Context) {
+	uid, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"msg":  "用户未登录",
+		})
+		return
+	}
+	userID := uid.(uint)
+
+	var req SetRetentionReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"msg":  "参数错误 : " + err.Error(),
+		})
+		return
+	}
+
+	if err := h.retentionService.SetConversationRetention(c.Request.Context(), userID, req.TargetID, *req.RetentionDays); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"msg":  err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"msg":  "设置成功",
+	})
+}
+
+// SetDisappearingReq 设置会话阅后即焚请求参数
+type SetDisappearingReq struct {
+	TargetID  uint   `json:"target_id" binding:"required"`
+	ExpiresIn *int64 `json:"expires_in" binding:"required"` // 阅读后多少秒删除，0 表示关闭
+}
+
+// GetDisappearing 获取与指定好友之间会话的阅后即焚设置。
+//
+// 请求方式: GET /api/v1/message/disappearing?target_id=2
+//
+// 响应格式:
+//
+//	{"code": 200, "data": {"target_id": 2, "expires_in": 30}}
+func (h *MessageHandle) GetDisappearing(c *gin.Context) {
+	uid, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"msg":  "用户未登录",
+		})
+		return
+	}
+	userID := uid.(uint)
+
+	targetID, err := strconv.ParseUint(c.Query("target_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"msg":  "target_id 格式错误",
+		})
+		return
+	}
+
+	seconds, err := h.messageService.GetDisappearing(c.Request.Context(), userID, uint(targetID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code": 500,
+			"msg":  "查询阅后即焚设置失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": gin.H{
+			"target_id":  targetID,
+			"expires_in": seconds,
+		},
+	})
+}
+
+// SetDisappearing 开启或关闭与指定好友之间会话的阅后即焚，会话中会收到一条系统通知。
+//
+// 请求方式: POST /api/v1/message/disappearing
+// 请求体:
+//
+//	{"target_id": 2, "expires_in": 30}   // expires_in 为 0 表示关闭
+//
+// 响应格式:
+//
+//	{"code": 200, "msg": "设置成功"}
+func (h *MessageHandle) SetDisappearing(c *gin.Context) {
+	uid, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"msg":  "用户未登录",
+		})
+		return
+	}
+	userID := uid.(uint)
+
+	var req SetDisappearingReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"msg":  "参数错误 : " + err.Error(),
+		})
+		return
+	}
+
+	if err := h.messageService.SetDisappearing(c.Request.Context(), userID, req.TargetID, *req.ExpiresIn); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"msg":  err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"msg":  "设置成功",
+	})
+}
@@ -0,0 +1,204 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"polychat/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 外部登录的状态 cookie：只在回调地址下发送，有效期与状态 token 一致。
+// 身份提供方回调是跨站的顶级跳转，SameSite=Lax 时浏览器仍会带上 cookie。
+const (
+	oidcStateCookie = "polychat_oidc"
+	oidcCookiePath  = "/api/v1/oidc"
+	oidcCookieAge   = 600
+)
+
+// LinkIdentityRequest 绑定外部账号请求参数
+type LinkIdentityRequest struct {
+	Password string `json:"password"` //当前密码，通过单点登录注册、没有密码的用户不需要填写
+}
+
+// UnlinkIdentityRequest 解绑外部账号请求参数
+type UnlinkIdentityRequest struct {
+	ID uint `json:"id" binding:"required"` //绑定关系ID，见 GET /user/oidc
+}
+
+// OIDCHandle 单点登录处理器
+type OIDCHandle struct {
+	oidcService service.OIDCService
+}
+
+// Login 发起单点登录：跳转到身份提供方的登录页
+//
+// 路由：GET /api/v1/oidc/login
+func (h *OIDCHandle) Login(c *gin.Context) {
+	redirect, err := h.oidcService.BeginLogin(c.Request.Context())
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "发起单点登录失败", "err", err)
+		redirectOIDCResult(c, url.Values{"sso_error": {oidcErrorMessage(err)}})
+		return
+	}
+	setOIDCStateCookie(c, redirect.StateToken, oidcCookieAge)
+	c.Redirect(http.StatusFound, redirect.URL)
+}
+
+// Callback 身份提供方登录完成后的回调，处理完成后跳转回首页，结果放在 URL 的 fragment 中
+// （fragment 不会发送给服务器，也不会出现在 Referer 和访问日志中）：
+//   - 登录成功：#sso_token=...&user_id=...&username=...
+//   - 需要两步验证：#sso_challenge=...
+//   - 绑定成功：#sso_linked=1
+//   - 失败：#sso_error=原因
+//
+// 路由：GET /api/v1/oidc/callback
+func (h *OIDCHandle) Callback(c *gin.Context) {
+	stateToken, _ := c.Cookie(oidcStateCookie)
+	setOIDCStateCookie(c, "", -1)
+
+	// 用户在身份提供方拒绝授权等情况下，回调只带有 error 参数
+	if providerErr := c.Query("error"); providerErr != "" {
+		slog.WarnContext(c.Request.Context(), "身份提供方返回错误", "error", providerErr, "description", c.Query("error_description"))
+		redirectOIDCResult(c, url.Values{"sso_error": {"身份提供方登录未完成"}})
+		return
+	}
+
+	result, err := h.oidcService.Callback(c.Request.Context(), stateToken, c.Query("state"), c.Query("code"), c.ClientIP())
+	if err != nil {
+		if !isOIDCBusinessError(err) {
+			slog.ErrorContext(c.Request.Context(), "单点登录失败", "err", err)
+		}
+		redirectOIDCResult(c, url.Values{"sso_error": {oidcErrorMessage(err)}})
+		return
+	}
+
+	switch {
+	case result.Linked:
+		redirectOIDCResult(c, url.Values{"sso_linked": {"1"}})
+	case result.Login.TwoFactorRequired:
+		redirectOIDCResult(c, url.Values{"sso_challenge": {result.Login.ChallengeToken}})
+	default:
+		redirectOIDCResult(c, url.Values{
+			"sso_token": {result.Login.Token},
+			"user_id":   {strconv.FormatUint(uint64(result.Login.UserID), 10)},
+			"username":  {result.Login.Username},
+		})
+	}
+}
+
+// LinkIdentity 发起绑定外部账号，返回身份提供方的授权地址，客户端跳转过去完成登录后回到 Callback
+//
+// 路由：POST /api/v1/user/oidc/link
+func (h *OIDCHandle) LinkIdentity(c *gin.Context) {
+	var req LinkIdentityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "参数错误 : " + err.Error()})
+		return
+	}
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": "用户未登录"})
+		return
+	}
+
+	redirect, err := h.oidcService.BeginLink(c.Request.Context(), userID.(uint), req.Password)
+	if err != nil {
+		writeOIDCError(c, "绑定外部账号失败", err)
+		return
+	}
+	setOIDCStateCookie(c, redirect.StateToken, oidcCookieAge)
+	c.JSON(http.StatusOK, gin.H{"code": 200, "data": gin.H{"url": redirect.URL}})
+}
+
+// ListIdentities 查询已绑定的外部账号
+//
+// 路由：GET /api/v1/user/oidc
+func (h *OIDCHandle) ListIdentities(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": "用户未登录"})
+		return
+	}
+
+	identities, err := h.oidcService.Identities(c.Request.Context(), userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": "查询外部账号失败 : " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "data": identities})
+}
+
+// UnlinkIdentity 解绑外部账号
+//
+// 路由：POST /api/v1/user/oidc/unlink
+func (h *OIDCHandle) UnlinkIdentity(c *gin.Context) {
+	var req UnlinkIdentityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "参数错误 : " + err.Error()})
+		return
+	}
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": "用户未登录"})
+		return
+	}
+
+	if err := h.oidcService.Unlink(c.Request.Context(), userID.(uint), req.ID, c.ClientIP()); err != nil {
+		writeOIDCError(c, "解绑外部账号失败", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "已解绑外部账号"})
+}
+
+// setOIDCStateCookie 设置或清除（maxAge < 0）外部登录的状态 cookie
+func setOIDCStateCookie(c *gin.Context, value string, maxAge int) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, value, maxAge, oidcCookiePath, "", secure, true)
+}
+
+// redirectOIDCResult 跳转回首页，结果放在 fragment 中由前端读取
+func redirectOIDCResult(c *gin.Context, result url.Values) {
+	c.Redirect(http.StatusFound, "/#"+result.Encode())
+}
+
+// isOIDCBusinessError 是否为可以直接展示给用户的错误
+func isOIDCBusinessError(err error) bool {
+	var suspended *service.AccountSuspendedError
+	return errors.As(err, &suspended) ||
+		errors.Is(err, service.ErrOIDCDisabled) ||
+		errors.Is(err, service.ErrOIDCState) ||
+		errors.Is(err, service.ErrOIDCFailed) ||
+		errors.Is(err, service.ErrIdentityLinked) ||
+		errors.Is(err, service.ErrIdentityNotLinked) ||
+		errors.Is(err, service.ErrIdentityEmailUsed) ||
+		errors.Is(err, service.ErrIdentityNotFound) ||
+		errors.Is(err, service.ErrLastLoginMethod) ||
+		errors.Is(err, service.ErrIdentityUserDeleted) ||
+		errors.Is(err, service.ErrWrongPassword)
+}
+
+// oidcErrorMessage 返回展示给用户的错误原因，其他错误不暴露细节
+func oidcErrorMessage(err error) string {
+	if isOIDCBusinessError(err) {
+		return err.Error()
+	}
+	return "单点登录失败，请稍后再试"
+}
+
+// writeOIDCError 把单点登录相关的错误转换为响应，业务错误返回 400，其他错误返回 500
+func writeOIDCError(c *gin.Context, msg string, err error) {
+	if isOIDCBusinessError(err) {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": msg + " : " + err.Error()})
+		return
+	}
+	slog.ErrorContext(c.Request.Context(), msg, "err", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": msg})
+}
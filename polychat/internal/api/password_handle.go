@@ -0,0 +1,102 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"polychat/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ChangePasswordRequest 修改密码请求参数
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password" binding:"required"` //当前密码
+	NewPassword string `json:"new_password" binding:"required"` //新密码
+}
+
+// ForgotPasswordRequest 申请重置密码请求参数
+type ForgotPasswordRequest struct {
+	Username string `json:"username" binding:"required"` //用户名，重置邮件发送到该用户绑定的邮箱
+}
+
+// ResetPasswordRequest 重置密码请求参数
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`        //邮件中的重置 token
+	NewPassword string `json:"new_password" binding:"required"` //新密码
+}
+
+// PasswordHandle 密码管理处理器
+type PasswordHandle struct {
+	passwordService service.PasswordService
+}
+
+// ChangePassword 修改密码。成功后其他设备上的登录全部失效，响应中返回当前客户端使用的新 token。
+func (h *PasswordHandle) ChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "参数错误 : " + err.Error()})
+		return
+	}
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": "用户未登录"})
+		return
+	}
+
+	token, err := h.passwordService.ChangePassword(c.Request.Context(), userID.(uint), req.OldPassword, req.NewPassword, c.ClientIP())
+	if writeValidationError(c, "修改密码失败", err) {
+		return
+	}
+	if err != nil {
+		if errors.Is(err, service.ErrWrongPassword) {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "修改密码失败 : " + err.Error()})
+			return
+		}
+		slog.ErrorContext(c.Request.Context(), "修改密码失败", "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": "修改密码失败，请稍后再试"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "密码已修改，其他设备需要重新登录", "token": token})
+}
+
+// ForgotPassword 申请重置密码。无论用户名是否存在都返回相同的响应。
+func (h *PasswordHandle) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "参数错误 : " + err.Error()})
+		return
+	}
+
+	if err := h.passwordService.RequestReset(c.Request.Context(), req.Username, c.ClientIP()); err != nil {
+		slog.ErrorContext(c.Request.Context(), "申请重置密码失败", "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": "申请重置密码失败，请稍后再试"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "如果该账号绑定了邮箱，重置密码的邮件已发送"})
+}
+
+// ResetPassword 使用邮件中的 token 设置新密码
+func (h *PasswordHandle) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "参数错误 : " + err.Error()})
+		return
+	}
+
+	if err := h.passwordService.ResetPassword(c.Request.Context(), req.Token, req.NewPassword, c.ClientIP()); err != nil {
+		if writeValidationError(c, "重置密码失败", err) {
+			return
+		}
+		if errors.Is(err, service.ErrInvalidResetToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "重置密码失败 : " + err.Error()})
+			return
+		}
+		slog.ErrorContext(c.Request.Context(), "重置密码失败", "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": "重置密码失败，请稍后再试"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "密码已重置，请使用新密码登录"})
+}
@@ -20,6 +20,9 @@ type FriendDTO struct {
 	RelationType uint   `json:"relation_type"`
 	Note         string `json:"note"`
 	IsOnline     bool   `json:"is_online"`
+	LastSeen     *int64 `json:"last_seen,omitempty"` // 最后在线时间（Unix 秒），对方隐藏在线状态时不返回
+	// Status 自定义状态和免打扰标记，对方隐藏在线状态时不返回
+	Status *ws.UserStatus `json:"status,omitempty"`
 }
 
 // PendingRequestDTO 待处理好友请求响应对象
@@ -73,7 +76,7 @@ func (h *RelationHandler) AddFriend(ctx *gin.Context) {
 	}
 	ownerID := userID.(uint)
 
-	if err := h.relationService.AddFriend(ownerID, req.TargetID, req.Note); err != nil {
+	if err := h.relationService.AddFriend(ctx.Request.Context(), ownerID, req.TargetID, req.Note); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
 		return
 	}
@@ -107,7 +110,7 @@ func (h *RelationHandler) AcceptFriend(ctx *gin.Context) {
 	}
 	currentUserID := userID.(uint)
 
-	if err := h.relationService.AcceptFriendRequest(currentUserID, req.RequesterID); err != nil {
+	if err := h.relationService.AcceptFriendRequest(ctx.Request.Context(), currentUserID, req.RequesterID); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
 		return
 	}
@@ -141,7 +144,7 @@ func (h *RelationHandler) RejectFriend(ctx *gin.Context) {
 	}
 	currentUserID := userID.(uint)
 
-	if err := h.relationService.RejectFriendRequest(currentUserID, req.RequesterID); err != nil {
+	if err := h.relationService.RejectFriendRequest(ctx.Request.Context(), currentUserID, req.RequesterID); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
 		return
 	}
@@ -158,7 +161,7 @@ func (h *RelationHandler) GetPendingRequests(ctx *gin.Context) {
 	}
 	currentUserID := userID.(uint)
 
-	relations, err := h.relationService.GetPendingRequests(currentUserID)
+	relations, err := h.relationService.GetPendingRequests(ctx.Request.Context(), currentUserID)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -168,7 +171,7 @@ func (h *RelationHandler) GetPendingRequests(ctx *gin.Context) {
 	var dtos []PendingRequestDTO
 	for _, r := range relations {
 		ownerName := ""
-		user, err := dao.GetUserByID(r.OwnerID)
+		user, err := dao.GetUserByID(ctx.Request.Context(), r.OwnerID)
 		if err == nil {
 			ownerName = user.Username
 		}
@@ -199,7 +202,7 @@ func (h *RelationHandler) DeleteFriend(ctx *gin.Context) {
 	}
 	ownerID := userID.(uint)
 
-	if err := h.relationService.DeleteFriend(ownerID, req.TargetID); err != nil {
+	if err := h.relationService.DeleteFriend(ctx.Request.Context(), ownerID, req.TargetID); err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -215,22 +218,41 @@ func (h *RelationHandler) GetFriend(ctx *gin.Context) {
 	}
 	ownerID := userID.(uint)
 
-	relations, err := h.relationService.GetFriend(ownerID)
+	relations, err := h.relationService.GetFriend(ctx.Request.Context(), ownerID)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// 转换为DTO并填充在线状态
+	// 批量查询好友的用户信息，用于填充最后在线时间和隐私设置
+	targetIDs := make([]uint, 0, len(relations))
+	for _, r := range relations {
+		targetIDs = append(targetIDs, r.TargetID)
+	}
+	users, err := dao.GetUsersByIDs(ctx.Request.Context(), targetIDs)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 转换为DTO并填充在线状态和自定义状态（对方隐藏在线状态时一律显示为离线）
 	var friendDTOs []FriendDTO
 	for _, r := range relations {
-		friendDTOs = append(friendDTOs, FriendDTO{
+		dto := FriendDTO{
 			OwnerID:      r.OwnerID,
 			TargetID:     r.TargetID,
 			RelationType: r.RelationType,
 			Note:         r.Note,
-			IsOnline:     ws.ClientMgr.IsUserOnline(r.TargetID),
-		})
+		}
+		if user, ok := users[r.TargetID]; ok && !user.HidePresence {
+			dto.IsOnline = ws.ClientMgr.IsUserOnline(r.TargetID)
+			if user.LastSeen != nil {
+				lastSeen := user.LastSeen.Unix()
+				dto.LastSeen = &lastSeen
+			}
+			dto.Status = service.StatusOf(user)
+		}
+		friendDTOs = append(friendDTOs, dto)
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{"code": 200, "data": friendDTOs})
@@ -251,7 +273,7 @@ func (h *RelationHandler) UpdateFriendNote(ctx *gin.Context) {
 	}
 	ownerID := userID.(uint)
 
-	if err := h.relationService.UpdateFriendNote(ownerID, req.TargetID, req.Note); err != nil {
+	if err := h.relationService.UpdateFriendNote(ctx.Request.Context(), ownerID, req.TargetID, req.Note); err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
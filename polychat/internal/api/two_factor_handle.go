@@ -0,0 +1,163 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"polychat/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TwoFactorLoginRequest 登录第二步的请求参数
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"` //登录第一步返回的 challenge token
+	Code           string `json:"code" binding:"required"`            //验证器应用中的验证码或恢复码
+}
+
+// TwoFactorCodeRequest 需要验证码的两步验证设置请求参数
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"` //验证器应用中的验证码
+}
+
+// TwoFactorDisableRequest 关闭两步验证的请求参数
+type TwoFactorDisableRequest struct {
+	Password string `json:"password" binding:"required"` //当前密码
+	Code     string `json:"code" binding:"required"`     //验证码或恢复码
+}
+
+// LoginTwoFactor 登录第二步：提交 challenge token 和验证码，换取会话 token
+func (h *UserHandle) LoginTwoFactor(c *gin.Context) {
+	var req TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "参数错误 : " + err.Error()})
+		return
+	}
+
+	result, err := h.userService.VerifyTwoFactor(c.Request.Context(), req.ChallengeToken, req.Code, c.ClientIP())
+	if err != nil {
+		writeLoginError(c, err)
+		return
+	}
+	writeLoginSuccess(c, result)
+}
+
+// GetTwoFactor 查询两步验证状态
+func (h *UserHandle) GetTwoFactor(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": "用户未登录"})
+		return
+	}
+
+	status, err := h.twoFactorService.Status(c.Request.Context(), userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": "查询两步验证状态失败 : " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "data": status})
+}
+
+// SetupTwoFactor 开始绑定两步验证，返回密钥和 otpauth:// 地址
+func (h *UserHandle) SetupTwoFactor(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": "用户未登录"})
+		return
+	}
+
+	setup, err := h.twoFactorService.Setup(c.Request.Context(), userID.(uint))
+	if err != nil {
+		writeTwoFactorError(c, "获取两步验证密钥失败", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "请使用验证器应用添加后提交验证码", "data": setup})
+}
+
+// ConfirmTwoFactor 提交验证码确认绑定，开启两步验证并返回恢复码
+func (h *UserHandle) ConfirmTwoFactor(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "参数错误 : " + err.Error()})
+		return
+	}
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": "用户未登录"})
+		return
+	}
+
+	codes, err := h.twoFactorService.Confirm(c.Request.Context(), userID.(uint), req.Code)
+	if err != nil {
+		writeTwoFactorError(c, "开启两步验证失败", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"msg":  "两步验证已开启，请妥善保存恢复码，它们只显示这一次",
+		"data": gin.H{"recovery_codes": codes},
+	})
+}
+
+// DisableTwoFactor 关闭两步验证
+func (h *UserHandle) DisableTwoFactor(c *gin.Context) {
+	var req TwoFactorDisableRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "参数错误 : " + err.Error()})
+		return
+	}
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": "用户未登录"})
+		return
+	}
+
+	if err := h.twoFactorService.Disable(c.Request.Context(), userID.(uint), req.Password, req.Code); err != nil {
+		writeTwoFactorError(c, "关闭两步验证失败", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "两步验证已关闭"})
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码，之前的恢复码全部作废
+func (h *UserHandle) RegenerateRecoveryCodes(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "参数错误 : " + err.Error()})
+		return
+	}
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": "用户未登录"})
+		return
+	}
+
+	codes, err := h.twoFactorService.RegenerateRecoveryCodes(c.Request.Context(), userID.(uint), req.Code)
+	if err != nil {
+		writeTwoFactorError(c, "生成恢复码失败", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"msg":  "恢复码已重新生成，请妥善保存，它们只显示这一次",
+		"data": gin.H{"recovery_codes": codes},
+	})
+}
+
+// writeTwoFactorError 把两步验证设置的错误转换为响应，业务错误返回 400，其他错误返回 500
+func writeTwoFactorError(c *gin.Context, msg string, err error) {
+	if errors.Is(err, service.ErrTwoFactorEnabled) ||
+		errors.Is(err, service.ErrTwoFactorNotSetup) ||
+		errors.Is(err, service.ErrTwoFactorNotEnabled) ||
+		errors.Is(err, service.ErrInvalidTwoFactorCode) ||
+		errors.Is(err, service.ErrInvalidCredentials) {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": msg + " : " + err.Error()})
+		return
+	}
+	slog.ErrorContext(c.Request.Context(), msg, "err", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": msg})
+}
@@ -1,20 +1,54 @@
 package api
 
 import (
+	"errors"
+	"log/slog"
+	"math"
 	"net/http"
 	"polychat/internal/service"
+	"polychat/internal/validation"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type UserHandle struct {
-	userService service.UserService
+	userService      service.UserService
+	presenceService  service.PresenceService
+	statusService    service.StatusService
+	twoFactorService service.TwoFactorService
+	emailService     service.EmailService
 }
 
 // RegisterRequest 注册请求参数
 type RegisterRequest struct {
-	Username string `json:"username" binding:"required"` //用户名不能为空
-	Password string `json:"password" binding:"required"` //密码不能为空
+	Username string `json:"username"` //用户名，规则见 validation.Username
+	Password string `json:"password"` //密码，规则见 validation.Password
+	Email    string `json:"email"`    //邮箱，可选，填写后发送验证邮件
+}
+
+// ProfileRequest 修改个人资料请求参数，省略的字段不修改
+type ProfileRequest struct {
+	Username *string `json:"username"` //新用户名
+	Avatar   *string `json:"avatar"`   //头像URL，空字符串表示清除头像
+}
+
+// PrivacyRequest 隐私设置请求参数
+type PrivacyRequest struct {
+	HidePresence *bool `json:"hide_presence" binding:"required"` //是否对好友隐藏在线状态
+}
+
+// StatusRequest 自定义状态请求参数，text 和 emoji 都为空表示清除状态
+type StatusRequest struct {
+	Text      string `json:"text"`       //状态文字
+	Emoji     string `json:"emoji"`      //状态表情
+	ExpiresIn int64  `json:"expires_in"` //有效时长（秒），0 表示不过期
+}
+
+// DoNotDisturbRequest 免打扰请求参数
+type DoNotDisturbRequest struct {
+	Enabled *bool `json:"enabled" binding:"required"` //是否开启免打扰
 }
 
 // LoginRequest 登录请求参数
@@ -33,7 +67,10 @@ func (h *UserHandle) Register(c *gin.Context) {
 	}
 
 	//调用user_service的Register方法
-	err := h.userService.Register(req.Username, req.Password)
+	err := h.userService.Register(c.Request.Context(), req.Username, req.Password, req.Email)
+	if writeValidationError(c, "注册失败", err) {
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": "注册失败 : " + err.Error()})
 		return
@@ -43,6 +80,16 @@ func (h *UserHandle) Register(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "注册成功"})
 }
 
+// writeValidationError 参数校验失败时返回 400，errors 中列出每个字段的错误原因，返回是否已写入响应
+func writeValidationError(c *gin.Context, msg string, err error) bool {
+	var errs validation.Errors
+	if !errors.As(err, &errs) {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": msg + " : " + err.Error(), "errors": errs})
+	return true
+}
+
 func (h *UserHandle) Login(c *gin.Context) {
 	var req LoginRequest
 	//绑定JSON参数到req结构体
@@ -52,18 +99,177 @@ func (h *UserHandle) Login(c *gin.Context) {
 	}
 
 	//调用user_service的Login方法
-	token, userID, err := h.userService.Login(req.Username, req.Password)
+	result, err := h.userService.Login(c.Request.Context(), req.Username, req.Password, c.ClientIP())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": "登录失败 : " + err.Error()})
+		writeLoginError(c, err)
+		return
+	}
+
+	//开启了两步验证：返回 challenge token，客户端提交验证码后才发放会话 token
+	if result.TwoFactorRequired {
+		c.JSON(http.StatusOK, gin.H{
+			"code":                200,
+			"msg":                 "请输入两步验证码",
+			"two_factor_required": true,
+			"challenge_token":     result.ChallengeToken,
+		})
 		return
 	}
 
 	//登录成功
+	writeLoginSuccess(c, result)
+}
+
+// writeLoginSuccess 返回登录成功的会话 token
+func writeLoginSuccess(c *gin.Context, result *service.LoginResult) {
 	c.JSON(http.StatusOK, gin.H{
 		"code":     200,
 		"msg":      "登录成功",
-		"token":    token,
-		"user_id":  userID,
-		"username": req.Username,
+		"token":    result.Token,
+		"user_id":  result.UserID,
+		"username": result.Username,
+		"role":     result.Role,
 	})
 }
+
+// writeLoginError 把登录失败的原因转换为响应
+func writeLoginError(c *gin.Context, err error) {
+	var throttled *service.LoginThrottledError
+	var suspended *service.AccountSuspendedError
+	switch {
+	case errors.Is(err, service.ErrInvalidCredentials),
+		errors.Is(err, service.ErrInvalidTwoFactorCode),
+		errors.Is(err, service.ErrInvalidChallenge):
+		c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": "登录失败 : " + err.Error()})
+	case errors.As(err, &suspended):
+		c.JSON(http.StatusForbidden, gin.H{"code": 403, "msg": "登录失败 : " + err.Error()})
+	case errors.As(err, &throttled):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"code": 429, "msg": "登录失败 : " + err.Error()})
+	default:
+		slog.ErrorContext(c.Request.Context(), "登录失败", "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": "登录失败，请稍后再试"})
+	}
+}
+
+// UpdatePrivacy 更新隐私设置
+func (h *UserHandle) UpdatePrivacy(c *gin.Context) {
+	var req PrivacyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "参数错误 : " + err.Error()})
+		return
+	}
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": "用户未登录"})
+		return
+	}
+
+	if err := h.presenceService.SetHidePresence(c.Request.Context(), userID.(uint), *req.HidePresence); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": "更新隐私设置失败 : " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "更新隐私设置成功"})
+}
+
+// GetStatus 获取自己的自定义状态和免打扰设置
+func (h *UserHandle) GetStatus(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": "用户未登录"})
+		return
+	}
+
+	status, err := h.statusService.GetStatus(c.Request.Context(), userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": "查询状态失败 : " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "data": status})
+}
+
+// UpdateStatus 设置或清除自定义状态
+func (h *UserHandle) UpdateStatus(c *gin.Context) {
+	var req StatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "参数错误 : " + err.Error()})
+		return
+	}
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": "用户未登录"})
+		return
+	}
+
+	err := h.statusService.SetStatus(c.Request.Context(), userID.(uint), req.Text, req.Emoji, time.Duration(req.ExpiresIn)*time.Second)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "更新状态失败 : " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "更新状态成功"})
+}
+
+// UpdateDoNotDisturb 开启或关闭免打扰
+func (h *UserHandle) UpdateDoNotDisturb(c *gin.Context) {
+	var req DoNotDisturbRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "参数错误 : " + err.Error()})
+		return
+	}
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": "用户未登录"})
+		return
+	}
+
+	if err := h.statusService.SetDoNotDisturb(c.Request.Context(), userID.(uint), *req.Enabled); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": "更新免打扰设置失败 : " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "更新免打扰设置成功"})
+}
+
+// GetProfile 获取自己的个人资料
+func (h *UserHandle) GetProfile(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": "用户未登录"})
+		return
+	}
+
+	profile, err := h.userService.GetProfile(c.Request.Context(), userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": "查询个人资料失败 : " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "data": profile})
+}
+
+// UpdateProfile 修改用户名和头像
+func (h *UserHandle) UpdateProfile(c *gin.Context) {
+	var req ProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "参数错误 : " + err.Error()})
+		return
+	}
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": "用户未登录"})
+		return
+	}
+
+	profile, err := h.userService.UpdateProfile(c.Request.Context(), userID.(uint), req.Username, req.Avatar)
+	if writeValidationError(c, "修改个人资料失败", err) {
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "修改个人资料失败", "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": "修改个人资料失败，请稍后再试"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "个人资料已更新", "data": profile})
+}
@@ -0,0 +1,44 @@
+package dao
+
+import (
+	"context"
+	"polychat/internal/model"
+	"polychat/pkg/database"
+	"polychat/pkg/metrics"
+)
+
+// GetConversationSetting 查询两个用户之间的会话设置，不存在时返回 gorm.ErrRecordNotFound
+func GetConversationSetting(ctx context.Context, userID, targetID uint) (*model.ConversationSetting, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "GetConversationSetting")()
+	ctx, cancel := withTimeout(ctx, queryTimeout)
+	defer cancel()
+	a, b := model.ConversationKey(userID, targetID)
+	var setting model.ConversationSetting
+	err := database.DB.WithContext(ctx).Where("user_a = ? AND user_b = ?", a, b).First(&setting).Error
+	if err != nil {
+		return nil, err
+	}
+	return &setting, nil
+}
+
+// SaveConversationSetting 保存会话设置（不存在则创建，存在则覆盖）
+func SaveConversationSetting(ctx context.Context, setting *model.ConversationSetting) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "SaveConversationSetting")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	setting.UserA, setting.UserB = model.ConversationKey(setting.UserA, setting.UserB)
+	return database.DB.WithContext(ctx).Save(setting).Error
+}
+
+// GetRetentionSettings 获取所有启用了消息保留期限的会话设置
+func GetRetentionSettings(ctx context.Context) ([]model.ConversationSetting, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "GetRetentionSettings")()
+	ctx, cancel := withTimeout(ctx, queryTimeout)
+	defer cancel()
+	var settings []model.ConversationSetting
+	err := database.DB.WithContext(ctx).Where("retention_days > 0").Find(&settings).Error
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
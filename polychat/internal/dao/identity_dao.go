@@ -0,0 +1,78 @@
+package dao
+
+import (
+	"context"
+	"time"
+
+	"polychat/internal/model"
+	"polychat/pkg/database"
+	"polychat/pkg/metrics"
+
+	"gorm.io/gorm"
+)
+
+// GetUserIdentity 根据身份提供方和外部账号标识查询绑定关系，未绑定时返回 gorm.ErrRecordNotFound
+func GetUserIdentity(ctx context.Context, provider, subject string) (*model.UserIdentity, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "GetUserIdentity")()
+	ctx, cancel := withTimeout(ctx, queryTimeout)
+	defer cancel()
+	var identity model.UserIdentity
+	err := database.DB.WithContext(ctx).Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// GetUserIdentities 查询用户绑定的全部外部账号，按绑定时间排序
+func GetUserIdentities(ctx context.Context, userID uint) ([]model.UserIdentity, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "GetUserIdentities")()
+	ctx, cancel := withTimeout(ctx, queryTimeout)
+	defer cancel()
+	var identities []model.UserIdentity
+	err := database.DB.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&identities).Error
+	return identities, err
+}
+
+// CreateUserIdentity 绑定外部账号，该外部账号已绑定其他用户时返回 gorm.ErrDuplicatedKey
+func CreateUserIdentity(ctx context.Context, identity *model.UserIdentity) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "CreateUserIdentity")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	return database.DB.WithContext(ctx).Create(identity).Error
+}
+
+// CreateUserWithIdentity 在同一个事务中创建用户并绑定外部账号。
+// 用户名已存在或外部账号已绑定时返回 gorm.ErrDuplicatedKey，此时两者都不会创建。
+func CreateUserWithIdentity(ctx context.Context, user *model.User, identity *model.UserIdentity) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "CreateUserWithIdentity")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	return database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		identity.UserID = user.ID
+		return tx.Create(identity).Error
+	})
+}
+
+// TouchUserIdentity 记录外部账号的最近登录时间和身份提供方返回的最新邮箱
+func TouchUserIdentity(ctx context.Context, id uint, email string, usedAt time.Time) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "TouchUserIdentity")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	return database.DB.WithContext(ctx).Model(&model.UserIdentity{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"email":        email,
+		"last_used_at": usedAt,
+	}).Error
+}
+
+// DeleteUserIdentity 解绑用户的一个外部账号，返回是否删除；绑定关系不属于该用户时不删除
+func DeleteUserIdentity(ctx context.Context, userID, id uint) (bool, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "DeleteUserIdentity")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	res := database.DB.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).Delete(&model.UserIdentity{})
+	return res.RowsAffected > 0, res.Error
+}
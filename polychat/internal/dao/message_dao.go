@@ -5,12 +5,20 @@ package dao
 
 import (
 	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
+	"unicode"
 
 	"polychat/internal/model"
 	"polychat/pkg/database"
+	"polychat/pkg/metrics"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -21,8 +29,9 @@ type MessageDAO struct{}
 // SaveMessage 将一条聊天消息保存到 MongoDB。
 // 参数 msg 是要保存的消息，ID 字段会由 MongoDB 自动生成。
 // 返回错误信息（如果有）。
-func (d *MessageDAO) SaveMessage(msg *model.ChatMessage) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (d *MessageDAO) SaveMessage(ctx context.Context, msg *model.ChatMessage) error {
+	defer metrics.ObserveQuery(metrics.MongoDB, "SaveMessage")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
 	defer cancel()
 
 	_, err := database.MongoMessageColl.InsertOne(ctx, msg)
@@ -43,8 +52,9 @@ func (d *MessageDAO) SaveMessage(msg *model.ChatMessage) error {
 //   - []model.ChatMessage: 消息列表（按时间倒序）
 //   - int64:               总消息数（用于前端分页计算）
 //   - error:               错误信息
-func (d *MessageDAO) GetMessageHistory(userID, targetID uint, page, pageSize int) ([]model.ChatMessage, int64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (d *MessageDAO) GetMessageHistory(ctx context.Context, userID, targetID uint, page, pageSize int) ([]model.ChatMessage, int64, error) {
+	defer metrics.ObserveQuery(metrics.MongoDB, "GetMessageHistory")()
+	ctx, cancel := withTimeout(ctx, queryTimeout)
 	defer cancel()
 
 	// 构建查询条件：双向匹配（A发给B 或 B发给A）
@@ -85,3 +95,398 @@ func (d *MessageDAO) GetMessageHistory(userID, targetID uint, page, pageSize int
 
 	return messages, total, nil
 }
+
+// MessageSearchQuery 聊天记录全文搜索的查询条件。
+type MessageSearchQuery struct {
+	// UserID 当前用户ID，只会搜索该用户参与的会话
+	UserID uint
+	// Keyword 搜索关键词，多个关键词用空格分隔
+	Keyword string
+	// TargetID 可选，限定只搜索与该用户之间的会话，0 表示不限定
+	TargetID uint
+	// StartTime / EndTime 可选，时间范围（Unix 秒，闭区间），0 表示不限定
+	StartTime int64
+	EndTime   int64
+	// AfterTimestamp / AfterID 游标位置，只返回排在该消息之后（更早）的记录。
+	// AfterID 为零值时表示从最新的消息开始。
+	AfterTimestamp int64
+	AfterID        primitive.ObjectID
+	// Limit 本次最多返回的条数
+	Limit int
+}
+
+// SearchMessages 基于 content 全文索引搜索聊天记录，中日韩文字的关键词使用正则匹配，见 keywordConds。
+// 结果按 (timestamp, _id) 倒序排列，配合 AfterTimestamp/AfterID 实现游标分页，
+// 避免 skip 分页在数据量较大时的性能问题以及新消息插入导致的翻页错位。
+//
+// 查询条件始终包含“当前用户是发送方或接收方”，保证用户只能搜到自己参与的会话。
+func (d *MessageDAO) SearchMessages(ctx context.Context, q MessageSearchQuery) ([]model.ChatMessage, error) {
+	defer metrics.ObserveQuery(metrics.MongoDB, "SearchMessages")()
+	ctx, cancel := withTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	// 会话范围：指定了 TargetID 时只查两人之间的会话，否则查当前用户参与的全部会话
+	var member bson.M
+	if q.TargetID != 0 {
+		member = bson.M{"$or": bson.A{
+			bson.M{"sender_id": q.UserID, "receiver_id": q.TargetID},
+			bson.M{"sender_id": q.TargetID, "receiver_id": q.UserID},
+		}}
+	} else {
+		member = bson.M{"$or": bson.A{
+			bson.M{"sender_id": q.UserID},
+			bson.M{"receiver_id": q.UserID},
+		}}
+	}
+
+	conds := append(keywordConds(q.Keyword), member)
+
+	// 时间范围
+	if q.StartTime > 0 || q.EndTime > 0 {
+		tsRange := bson.M{}
+		if q.StartTime > 0 {
+			tsRange["$gte"] = q.StartTime
+		}
+		if q.EndTime > 0 {
+			tsRange["$lte"] = q.EndTime
+		}
+		conds = append(conds, bson.M{"timestamp": tsRange})
+	}
+
+	// 游标：取排在上一页最后一条之后的记录
+	if !q.AfterID.IsZero() {
+		conds = append(conds, bson.M{"$or": bson.A{
+			bson.M{"timestamp": bson.M{"$lt": q.AfterTimestamp}},
+			bson.M{"timestamp": q.AfterTimestamp, "_id": bson.M{"$lt": q.AfterID}},
+		}})
+	}
+
+	findOpts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(q.Limit))
+
+	cursor, err := database.MongoMessageColl.Find(ctx, bson.M{"$and": conds}, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []model.ChatMessage
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// keywordConds 返回按关键词匹配消息内容的查询条件。
+//
+// content 的全文索引（default_language: none）只按空格和标点分词，
+// 不含空格的中文、日文、韩文句子整句是一个词，搜索其中的一部分无法命中。
+// 因此关键词包含 CJK 字符时改用 $regex 做子串匹配：每个词都必须出现（不区分大小写），
+// 以 "-" 开头的词必须不出现。正则无法使用全文索引，但查询始终限定在当前用户参与的会话内，
+// 由 sender_id/receiver_id 索引缩小扫描范围。
+func keywordConds(keyword string) bson.A {
+	if !containsCJK(keyword) {
+		return bson.A{bson.M{"$text": bson.M{"$search": keyword}}}
+	}
+	var conds bson.A
+	for _, f := range strings.Fields(strings.ReplaceAll(keyword, `"`, " ")) {
+		if exclude, ok := strings.CutPrefix(f, "-"); ok {
+			if exclude != "" {
+				conds = append(conds, bson.M{"content": bson.M{"$not": primitive.Regex{Pattern: regexp.QuoteMeta(exclude), Options: "i"}}})
+			}
+			continue
+		}
+		conds = append(conds, bson.M{"content": primitive.Regex{Pattern: regexp.QuoteMeta(f), Options: "i"}})
+	}
+	return conds
+}
+
+// containsCJK 字符串中是否包含汉字、假名或韩文
+func containsCJK(s string) bool {
+	for _, r := range s {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListConversationPeers 返回与指定用户有过聊天记录的所有用户ID（去重、升序）。
+// 分别统计用户作为发送方时的 receiver_id 和作为接收方时的 sender_id。
+func (d *MessageDAO) ListConversationPeers(ctx context.Context, userID uint) ([]uint, error) {
+	defer metrics.ObserveQuery(metrics.MongoDB, "ListConversationPeers")()
+	ctx, cancel := withTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	sent, err := database.MongoMessageColl.Distinct(ctx, "receiver_id", bson.M{"sender_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	received, err := database.MongoMessageColl.Distinct(ctx, "sender_id", bson.M{"receiver_id": userID})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[uint]bool)
+	var peers []uint
+	for _, v := range append(sent, received...) {
+		id, ok := toUint(v)
+		if !ok || id == userID || seen[id] {
+			continue
+		}
+		seen[id] = true
+		peers = append(peers, id)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i] < peers[j] })
+	return peers, nil
+}
+
+// IterateConversation 按时间正序逐条遍历两个用户之间的全部聊天记录。
+// 使用游标逐条解码，内存占用与会话长度无关，适合导出等需要处理大量历史消息的场景。
+// fn 返回错误时立即停止遍历并返回该错误。
+func (d *MessageDAO) IterateConversation(ctx context.Context, userID, targetID uint, fn func(msg *model.ChatMessage) error) error {
+	defer metrics.ObserveQuery(metrics.MongoDB, "IterateConversation")()
+	ctx, cancel := withTimeout(ctx, exportTimeout)
+	defer cancel()
+
+	filter := bson.M{
+		"$or": bson.A{
+			bson.M{"sender_id": userID, "receiver_id": targetID},
+			bson.M{"sender_id": targetID, "receiver_id": userID},
+		},
+	}
+	findOpts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}})
+
+	cursor, err := database.MongoMessageColl.Find(ctx, filter, findOpts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var msg model.ChatMessage
+		if err := cursor.Decode(&msg); err != nil {
+			return err
+		}
+		if err := fn(&msg); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// toUint 将 Distinct 返回的数值（int32/int64，取决于写入时的类型）转换为 uint。
+func toUint(v interface{}) (uint, bool) {
+	switch n := v.(type) {
+	case int32:
+		return uint(n), n >= 0
+	case int64:
+		return uint(n), n >= 0
+	case float64:
+		return uint(n), n >= 0
+	default:
+		return 0, false
+	}
+}
+
+// InsertMessagesSkipDuplicates 批量写入消息，_id 已存在的消息会被跳过。
+// 使用无序批量写入（ordered=false），单条重复不会中断整批写入。
+//
+// 返回值：
+//   - int:   实际写入的条数
+//   - int:   因 _id 重复被跳过的条数
+//   - error: 除重复键以外的错误
+func (d *MessageDAO) InsertMessagesSkipDuplicates(ctx context.Context, msgs []model.ChatMessage) (int, int, error) {
+	defer metrics.ObserveQuery(metrics.MongoDB, "InsertMessagesSkipDuplicates")()
+	if len(msgs) == 0 {
+		return 0, 0, nil
+	}
+	ctx, cancel := withTimeout(ctx, bulkTimeout)
+	defer cancel()
+
+	docs := make([]interface{}, len(msgs))
+	for i := range msgs {
+		docs[i] = msgs[i]
+	}
+
+	_, err := database.MongoMessageColl.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err == nil {
+		return len(msgs), 0, nil
+	}
+
+	// 区分重复键错误和其他错误
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return 0, 0, err
+	}
+	duplicates := 0
+	for _, we := range bulkErr.WriteErrors {
+		if !mongo.IsDuplicateKeyError(we) {
+			return 0, 0, err
+		}
+		duplicates++
+	}
+	return len(msgs) - duplicates, duplicates, nil
+}
+
+// deleteBatchSize 批量删除消息时每批处理的条数
+const deleteBatchSize = 1000
+
+// MessageCleanupFunc 消息删除后的关联数据清理函数。
+// ids 是本批被删除的消息ID，用于清理附件、表情回应、已读回执等依附于消息的数据。
+type MessageCleanupFunc func(ctx context.Context, ids []primitive.ObjectID) error
+
+// messageCleanups 已注册的关联数据清理函数
+var messageCleanups []MessageCleanupFunc
+
+// RegisterMessageCleanup 注册一个消息删除后的清理函数。
+// 存储依附于消息的数据的模块应在初始化时注册，保证自动删除消息时不会留下孤儿数据。
+func RegisterMessageCleanup(fn MessageCleanupFunc) {
+	messageCleanups = append(messageCleanups, fn)
+}
+
+// DeleteMessagesBefore 删除时间戳早于 before（Unix 秒）的消息，返回删除的条数。
+// userID 和 targetID 都不为 0 时只删除这两个用户之间的消息，否则删除全部会话中的过期消息。
+//
+// 为了能够清理关联数据，先按批查出消息ID再按ID删除，每删除一批就执行一次已注册的清理函数。
+func (d *MessageDAO) DeleteMessagesBefore(ctx context.Context, before int64, userID, targetID uint) (int64, error) {
+	defer metrics.ObserveQuery(metrics.MongoDB, "DeleteMessagesBefore")()
+	filter := bson.M{"timestamp": bson.M{"$lt": before}}
+	if userID != 0 && targetID != 0 {
+		filter["$or"] = bson.A{
+			bson.M{"sender_id": userID, "receiver_id": targetID},
+			bson.M{"sender_id": targetID, "receiver_id": userID},
+		}
+	}
+	return d.deleteMessages(ctx, filter)
+}
+
+// deleteMessages 按批删除匹配 filter 的消息，并执行关联数据清理。
+func (d *MessageDAO) deleteMessages(ctx context.Context, filter bson.M) (int64, error) {
+	var total int64
+	for {
+		ctx, cancel := withTimeout(ctx, bulkTimeout)
+		ids, err := findMessageIDs(ctx, filter, deleteBatchSize)
+		if err != nil || len(ids) == 0 {
+			cancel()
+			return total, err
+		}
+
+		deleted, err := deleteMessagesByID(ctx, ids)
+		total += deleted
+		cancel()
+		if err != nil {
+			return total, err
+		}
+
+		if len(ids) < deleteBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// findMessageIDs 查询匹配 filter 的消息ID，最多 limit 条。
+func findMessageIDs(ctx context.Context, filter bson.M, limit int) ([]primitive.ObjectID, error) {
+	findOpts := options.Find().
+		SetProjection(bson.M{"_id": 1}).
+		SetLimit(int64(limit))
+	cursor, err := database.MongoMessageColl.Find(ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.ID
+	}
+	return ids, nil
+}
+
+// deleteMessagesByID 按ID删除消息，并执行已注册的关联数据清理函数。
+func deleteMessagesByID(ctx context.Context, ids []primitive.ObjectID) (int64, error) {
+	res, err := database.MongoMessageColl.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return 0, err
+	}
+	for _, cleanup := range messageCleanups {
+		if err := cleanup(ctx, ids); err != nil {
+			return res.DeletedCount, err
+		}
+	}
+	return res.DeletedCount, nil
+}
+
+// DeleteMessagesByID 按ID删除消息，返回删除的条数。
+func (d *MessageDAO) DeleteMessagesByID(ctx context.Context, ids []primitive.ObjectID) (int64, error) {
+	defer metrics.ObserveQuery(metrics.MongoDB, "DeleteMessagesByID")()
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	ctx, cancel := withTimeout(ctx, bulkTimeout)
+	defer cancel()
+	return deleteMessagesByID(ctx, ids)
+}
+
+// MarkMessageRead 把一条阅后即焚消息标记为已读，并把删除时间提前到 now + expires_in。
+// 只有消息的接收方可以标记已读，已经标记过的消息不会重复计时。
+// 返回更新后的消息；消息不存在、不是阅后即焚消息或已读过时返回 mongo.ErrNoDocuments。
+func (d *MessageDAO) MarkMessageRead(ctx context.Context, id primitive.ObjectID, receiverID uint, now time.Time) (*model.ChatMessage, error) {
+	defer metrics.ObserveQuery(metrics.MongoDB, "MarkMessageRead")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":         id,
+		"receiver_id": receiverID,
+		"expires_in":  bson.M{"$gt": 0},
+		"read_at":     bson.M{"$exists": false},
+	}
+	// 使用聚合管道更新，删除时间取 min(原删除时间, now + expires_in)
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"read_at": now,
+			"expire_at": bson.M{"$min": bson.A{
+				"$expire_at",
+				bson.M{"$add": bson.A{now, bson.M{"$multiply": bson.A{"$expires_in", 1000}}}},
+			}},
+		}}},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var msg model.ChatMessage
+	if err := database.MongoMessageColl.FindOneAndUpdate(ctx, filter, update, opts).Decode(&msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// FindExpiredMessages 查询删除时间已到的阅后即焚消息，最多 limit 条。
+func (d *MessageDAO) FindExpiredMessages(ctx context.Context, now time.Time, limit int) ([]model.ChatMessage, error) {
+	defer metrics.ObserveQuery(metrics.MongoDB, "FindExpiredMessages")()
+	ctx, cancel := withTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	findOpts := options.Find().
+		SetSort(bson.D{{Key: "expire_at", Value: 1}}).
+		SetLimit(int64(limit))
+	cursor, err := database.MongoMessageColl.Find(ctx, bson.M{"expire_at": bson.M{"$lte": now}}, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []model.ChatMessage
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
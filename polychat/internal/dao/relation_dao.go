@@ -1,24 +1,35 @@
 package dao
 
 import (
+	"context"
 	"polychat/internal/model"
 	"polychat/pkg/database"
+	"polychat/pkg/metrics"
 )
 
 // CreateRelation 创建好友关系
-func CreateRelation(relation *model.Relation) error {
-	return database.DB.Create(relation).Error
+func CreateRelation(ctx context.Context, relation *model.Relation) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "CreateRelation")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	return database.DB.WithContext(ctx).Create(relation).Error
 }
 
 // DeleteRelation 删除好友关系
-func DeleteRelation(ownerID, targetID uint) error {
-	return database.DB.Delete(&model.Relation{}, "owner_id = ? AND target_id = ?", ownerID, targetID).Error
+func DeleteRelation(ctx context.Context, ownerID, targetID uint) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "DeleteRelation")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	return database.DB.WithContext(ctx).Delete(&model.Relation{}, "owner_id = ? AND target_id = ?", ownerID, targetID).Error
 }
 
 // GetRelation 获取已确认的好友列表（relation_type = 1）
-func GetRelation(ownerID uint) ([]model.Relation, error) {
+func GetRelation(ctx context.Context, ownerID uint) ([]model.Relation, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "GetRelation")()
+	ctx, cancel := withTimeout(ctx, queryTimeout)
+	defer cancel()
 	var relations []model.Relation
-	err := database.DB.Where("owner_id = ? AND relation_type = 1", ownerID).Find(&relations).Error
+	err := database.DB.WithContext(ctx).Where("owner_id = ? AND relation_type = 1", ownerID).Find(&relations).Error
 	if err != nil {
 		return nil, err
 	}
@@ -26,15 +37,21 @@ func GetRelation(ownerID uint) ([]model.Relation, error) {
 }
 
 // UpdateRelationNote 更改好友关系备注
-func UpdateRelationNote(ownerID, targetID uint, note string) error {
-	return database.DB.Model(&model.Relation{}).Where("owner_id = ? AND target_id = ?",
+func UpdateRelationNote(ctx context.Context, ownerID, targetID uint, note string) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "UpdateRelationNote")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	return database.DB.WithContext(ctx).Model(&model.Relation{}).Where("owner_id = ? AND target_id = ?",
 		ownerID, targetID).Update("note", note).Error
 }
 
 // GetPendingRequests 获取待处理的好友请求（当前用户是被请求方）
-func GetPendingRequests(targetID uint) ([]model.Relation, error) {
+func GetPendingRequests(ctx context.Context, targetID uint) ([]model.Relation, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "GetPendingRequests")()
+	ctx, cancel := withTimeout(ctx, queryTimeout)
+	defer cancel()
 	var relations []model.Relation
-	err := database.DB.Where("target_id = ? AND relation_type = 0", targetID).Find(&relations).Error
+	err := database.DB.WithContext(ctx).Where("target_id = ? AND relation_type = 0", targetID).Find(&relations).Error
 	if err != nil {
 		return nil, err
 	}
@@ -42,9 +59,12 @@ func GetPendingRequests(targetID uint) ([]model.Relation, error) {
 }
 
 // GetRelationByPair 查询两个用户之间的关系记录
-func GetRelationByPair(ownerID, targetID uint) (*model.Relation, error) {
+func GetRelationByPair(ctx context.Context, ownerID, targetID uint) (*model.Relation, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "GetRelationByPair")()
+	ctx, cancel := withTimeout(ctx, queryTimeout)
+	defer cancel()
 	var relation model.Relation
-	err := database.DB.Where("owner_id = ? AND target_id = ?", ownerID, targetID).First(&relation).Error
+	err := database.DB.WithContext(ctx).Where("owner_id = ? AND target_id = ?", ownerID, targetID).First(&relation).Error
 	if err != nil {
 		return nil, err
 	}
@@ -52,7 +72,10 @@ func GetRelationByPair(ownerID, targetID uint) (*model.Relation, error) {
 }
 
 // UpdateRelationType 更新关系类型（0=待处理, 1=已确认）
-func UpdateRelationType(ownerID, targetID uint, relationType uint) error {
-	return database.DB.Model(&model.Relation{}).Where("owner_id = ? AND target_id = ?",
+func UpdateRelationType(ctx context.Context, ownerID, targetID uint, relationType uint) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "UpdateRelationType")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	return database.DB.WithContext(ctx).Model(&model.Relation{}).Where("owner_id = ? AND target_id = ?",
 		ownerID, targetID).Update("relation_type", relationType).Error
 }
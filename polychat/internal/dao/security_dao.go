@@ -0,0 +1,173 @@
+package dao
+
+import (
+	"context"
+	"time"
+
+	"polychat/internal/model"
+	"polychat/pkg/database"
+	"polychat/pkg/metrics"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GetLoginFailure 查询登录失败计数，不存在时返回 gorm.ErrRecordNotFound
+func GetLoginFailure(ctx context.Context, scope, subject string) (*model.LoginFailure, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "GetLoginFailure")()
+	ctx, cancel := withTimeout(ctx, queryTimeout)
+	defer cancel()
+	var failure model.LoginFailure
+	err := database.DB.WithContext(ctx).Where("scope = ? AND subject = ?", scope, subject).First(&failure).Error
+	if err != nil {
+		return nil, err
+	}
+	return &failure, nil
+}
+
+// UpdateLoginFailure 在事务中锁定并修改登录失败计数，记录不存在时 fn 收到零值记录。
+// 并发的失败登录会依次执行 fn，不会丢失计数。返回修改后的记录。
+func UpdateLoginFailure(ctx context.Context, scope, subject string, fn func(f *model.LoginFailure)) (*model.LoginFailure, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "UpdateLoginFailure")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	var failure model.LoginFailure
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("scope = ? AND subject = ?", scope, subject).First(&failure).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		failure.Scope, failure.Subject = scope, subject
+		fn(&failure)
+		return tx.Save(&failure).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &failure, nil
+}
+
+// DeleteLoginFailure 清除登录失败计数
+func DeleteLoginFailure(ctx context.Context, scope, subject string) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "DeleteLoginFailure")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	return database.DB.WithContext(ctx).Delete(&model.LoginFailure{}, "scope = ? AND subject = ?", scope, subject).Error
+}
+
+// CreateAuditLog 写入一条审计日志
+func CreateAuditLog(ctx context.Context, entry *model.AuditLog) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "CreateAuditLog")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	return database.DB.WithContext(ctx).Create(entry).Error
+}
+
+// ReplaceRecoveryCodes 删除用户的全部恢复码，写入新生成的恢复码
+func ReplaceRecoveryCodes(ctx context.Context, userID uint, codeHashes []string) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "ReplaceRecoveryCodes")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	return database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, userID, codeHashes)
+	})
+}
+
+// replaceRecoveryCodes 在事务 tx 中替换用户的恢复码
+func replaceRecoveryCodes(tx *gorm.DB, userID uint, codeHashes []string) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	if len(codeHashes) == 0 {
+		return nil
+	}
+	codes := make([]model.RecoveryCode, 0, len(codeHashes))
+	for _, h := range codeHashes {
+		codes = append(codes, model.RecoveryCode{UserID: userID, CodeHash: h})
+	}
+	return tx.Create(&codes).Error
+}
+
+// GetUnusedRecoveryCodes 查询用户尚未使用的恢复码
+func GetUnusedRecoveryCodes(ctx context.Context, userID uint) ([]model.RecoveryCode, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "GetUnusedRecoveryCodes")()
+	ctx, cancel := withTimeout(ctx, queryTimeout)
+	defer cancel()
+	var codes []model.RecoveryCode
+	err := database.DB.WithContext(ctx).Where("user_id = ? AND used_at IS NULL", userID).Find(&codes).Error
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// MarkRecoveryCodeUsed 把恢复码标记为已使用，返回是否标记成功；已被使用过时返回 false
+func MarkRecoveryCodeUsed(ctx context.Context, id uint, usedAt time.Time) (bool, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "MarkRecoveryCodeUsed")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	res := database.DB.WithContext(ctx).Model(&model.RecoveryCode{}).Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", usedAt)
+	return res.RowsAffected > 0, res.Error
+}
+
+// CreateLoginSession 记录一次登录，同时清理该用户已过期的会话记录
+func CreateLoginSession(ctx context.Context, session *model.LoginSession) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "CreateLoginSession")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	return database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND expires_at <= ?", session.UserID, session.CreatedAt).
+			Delete(&model.LoginSession{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(session).Error
+	})
+}
+
+// GetActiveLoginSessions 查询用户未过期的会话记录，最近的在前（包括已被吊销的，见 LoginSession.TokenVersion）
+func GetActiveLoginSessions(ctx context.Context, userID uint, now time.Time) ([]model.LoginSession, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "GetActiveLoginSessions")()
+	ctx, cancel := withTimeout(ctx, queryTimeout)
+	defer cancel()
+	var sessions []model.LoginSession
+	err := database.DB.WithContext(ctx).Where("user_id = ? AND expires_at > ?", userID, now).
+		Order("id DESC").Find(&sessions).Error
+	return sessions, err
+}
+
+// AuditLogQuery 查询审计日志的条件，零值字段不作为条件
+type AuditLogQuery struct {
+	UserID   uint
+	ActorID  uint
+	Action   string
+	Page     int
+	PageSize int
+}
+
+// ListAuditLogs 按条件分页查询审计日志，最近的在前，返回本页记录和符合条件的总数
+func ListAuditLogs(ctx context.Context, q AuditLogQuery) ([]model.AuditLog, int64, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "ListAuditLogs")()
+	ctx, cancel := withTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	db := database.DB.WithContext(ctx).Model(&model.AuditLog{})
+	if q.UserID != 0 {
+		db = db.Where("user_id = ?", q.UserID)
+	}
+	if q.ActorID != 0 {
+		db = db.Where("actor_id = ?", q.ActorID)
+	}
+	if q.Action != "" {
+		db = db.Where("action = ?", q.Action)
+	}
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var logs []model.AuditLog
+	err := db.Order("id DESC").Offset((q.Page - 1) * q.PageSize).Limit(q.PageSize).Find(&logs).Error
+	return logs, total, err
+}
@@ -0,0 +1,29 @@
+package dao
+
+import (
+	"context"
+	"time"
+
+	"polychat/pkg/config"
+)
+
+// 数据库操作的超时时间，按操作类型区分，可通过环境变量调整，设为 0 表示不限制。
+// 超时只是上限：调用方的 context 先被取消（客户端断开、服务关闭）时，查询会随之取消。
+var (
+	// writeTimeout 单条记录的写入和更新
+	writeTimeout = config.GetDuration("POLYCHAT_DB_WRITE_TIMEOUT", 5*time.Second)
+	// queryTimeout 普通查询
+	queryTimeout = config.GetDuration("POLYCHAT_DB_QUERY_TIMEOUT", 10*time.Second)
+	// bulkTimeout 批量写入和按批删除中的每一批
+	bulkTimeout = config.GetDuration("POLYCHAT_DB_BULK_TIMEOUT", 30*time.Second)
+	// exportTimeout 遍历会话导出。导出需要完整遍历一个会话，耗时与消息量成正比，因此比普通查询宽松得多。
+	exportTimeout = config.GetDuration("POLYCHAT_DB_EXPORT_TIMEOUT", 10*time.Minute)
+)
+
+// withTimeout 为一次数据库操作设置超时，d <= 0 时只继承调用方的取消
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
@@ -1,19 +1,46 @@
 package dao
 
 import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
 	"polychat/internal/model"
 	"polychat/pkg/database"
+	"polychat/pkg/metrics"
+
+	"gorm.io/gorm"
 )
 
 // 调用数据库中的DB来创建新用户
-func CreateUser(user *model.User) error {
-	return database.DB.Create(user).Error
+func CreateUser(ctx context.Context, user *model.User) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "CreateUser")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	return database.DB.WithContext(ctx).Create(user).Error
 }
 
 // 根据用户名来查询用户
-func GetUserByUsername(username string) (*model.User, error) {
+func GetUserByUsername(ctx context.Context, username string) (*model.User, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "GetUserByUsername")()
+	ctx, cancel := withTimeout(ctx, queryTimeout)
+	defer cancel()
+	var user model.User
+	err := database.DB.WithContext(ctx).Where("username = ?", username).First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetUserByVerifiedEmail 查询已验证该邮箱的用户，没有时返回 gorm.ErrRecordNotFound
+func GetUserByVerifiedEmail(ctx context.Context, email string) (*model.User, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "GetUserByVerifiedEmail")()
+	ctx, cancel := withTimeout(ctx, queryTimeout)
+	defer cancel()
 	var user model.User
-	err := database.DB.Where("username = ?", username).First(&user).Error
+	err := database.DB.WithContext(ctx).Where("email = ? AND email_verified_at IS NOT NULL", email).First(&user).Error
 	if err != nil {
 		return nil, err
 	}
@@ -21,11 +48,298 @@ func GetUserByUsername(username string) (*model.User, error) {
 }
 
 // GetUserByID 根据用户ID查询用户
-func GetUserByID(userID uint) (*model.User, error) {
+func GetUserByID(ctx context.Context, userID uint) (*model.User, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "GetUserByID")()
+	ctx, cancel := withTimeout(ctx, queryTimeout)
+	defer cancel()
+	var user model.User
+	err := database.DB.WithContext(ctx).First(&user, userID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetUsersByIDs 批量查询用户，返回以用户ID为键的map，不存在的用户不会出现在结果中
+func GetUsersByIDs(ctx context.Context, userIDs []uint) (map[uint]*model.User, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "GetUsersByIDs")()
+	ctx, cancel := withTimeout(ctx, queryTimeout)
+	defer cancel()
+	users := make(map[uint]*model.User, len(userIDs))
+	if len(userIDs) == 0 {
+		return users, nil
+	}
+	var list []model.User
+	if err := database.DB.WithContext(ctx).Where("id IN ?", userIDs).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	for i := range list {
+		users[list[i].ID] = &list[i]
+	}
+	return users, nil
+}
+
+// UpdateUserLastSeen 更新用户的最后在线时间
+func UpdateUserLastSeen(ctx context.Context, userID uint, lastSeen time.Time) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "UpdateUserLastSeen")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	return database.DB.WithContext(ctx).Model(&model.User{}).Where("id = ?", userID).Update("last_seen", lastSeen).Error
+}
+
+// UpdateUserHidePresence 更新用户是否隐藏在线状态
+func UpdateUserHidePresence(ctx context.Context, userID uint, hide bool) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "UpdateUserHidePresence")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	return database.DB.WithContext(ctx).Model(&model.User{}).Where("id = ?", userID).Update("hide_presence", hide).Error
+}
+
+// UpdateUserStatus 更新用户的自定义状态，expiresAt 为 nil 表示不过期
+func UpdateUserStatus(ctx context.Context, userID uint, text, emoji string, expiresAt *time.Time) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "UpdateUserStatus")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	return database.DB.WithContext(ctx).Model(&model.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"status_text":       text,
+		"status_emoji":      emoji,
+		"status_expires_at": expiresAt,
+	}).Error
+}
+
+// UpdateUserDoNotDisturb 更新用户的免打扰设置
+func UpdateUserDoNotDisturb(ctx context.Context, userID uint, on bool) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "UpdateUserDoNotDisturb")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	return database.DB.WithContext(ctx).Model(&model.User{}).Where("id = ?", userID).Update("do_not_disturb", on).Error
+}
+
+// UpdateUserTOTPSecret 保存待确认的 TOTP 密钥，两步验证仍保持关闭
+func UpdateUserTOTPSecret(ctx context.Context, userID uint, secret string) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "UpdateUserTOTPSecret")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	return database.DB.WithContext(ctx).Model(&model.User{}).Where("id = ? AND totp_enabled = ?", userID, false).
+		Update("totp_secret", secret).Error
+}
+
+// UpdateUserTOTPLastStep 记录验证通过的 TOTP 时间步。
+// 只有 step 大于已记录的时间步时才更新，返回是否更新；并发提交同一个验证码时只有一次能成功。
+func UpdateUserTOTPLastStep(ctx context.Context, userID uint, step int64) (bool, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "UpdateUserTOTPLastStep")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	res := database.DB.WithContext(ctx).Model(&model.User{}).Where("id = ? AND totp_last_step < ?", userID, step).
+		Update("totp_last_step", step)
+	return res.RowsAffected > 0, res.Error
+}
+
+// EnableUserTOTP 开启两步验证，并用 codeHashes 替换该用户的全部恢复码
+func EnableUserTOTP(ctx context.Context, userID uint, step int64, codeHashes []string) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "EnableUserTOTP")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	return database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&model.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"totp_enabled":   true,
+			"totp_last_step": step,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return replaceRecoveryCodes(tx, userID, codeHashes)
+	})
+}
+
+// DisableUserTOTP 关闭两步验证，清除密钥和全部恢复码
+func DisableUserTOTP(ctx context.Context, userID uint) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "DisableUserTOTP")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	return database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&model.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"totp_secret":    "",
+			"totp_enabled":   false,
+			"totp_last_step": 0,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error
+	})
+}
+
+// UpdateUserPassword 修改密码并递增 token 版本，使之前签发的 token 全部失效。
+// 只有当前 token 版本仍为 tokenVersion 时才修改，返回是否修改；
+// 这保证同一个重置密码 token 只能使用一次，并发修改密码时也只有一次成功。
+func UpdateUserPassword(ctx context.Context, userID, tokenVersion uint, passwordHash string) (bool, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "UpdateUserPassword")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	res := database.DB.WithContext(ctx).Model(&model.User{}).
+		Where("id = ? AND token_version = ?", userID, tokenVersion).
+		Updates(map[string]interface{}{
+			"password":      passwordHash,
+			"token_version": gorm.Expr("token_version + 1"),
+		})
+	return res.RowsAffected > 0, res.Error
+}
+
+// UpdateUserProfile 修改用户名和头像，username 为空表示不修改用户名。
+// 用户名已被其他用户使用时返回 gorm.ErrDuplicatedKey
+func UpdateUserProfile(ctx context.Context, userID uint, username, avatar string) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "UpdateUserProfile")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	updates := map[string]interface{}{"avatar": avatar}
+	if username != "" {
+		updates["username"] = username
+	}
+	return database.DB.WithContext(ctx).Model(&model.User{}).Where("id = ?", userID).Updates(updates).Error
+}
+
+// UpdateUserRole 修改用户角色并递增 token 版本，使带有旧角色的 token 全部失效
+func UpdateUserRole(ctx context.Context, userID uint, role string) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "UpdateUserRole")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	return database.DB.WithContext(ctx).Model(&model.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"role":          role,
+		"token_version": gorm.Expr("token_version + 1"),
+	}).Error
+}
+
+// UpdateUserEmail 修改邮箱，新邮箱需要重新验证
+func UpdateUserEmail(ctx context.Context, userID uint, email string) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "UpdateUserEmail")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	return database.DB.WithContext(ctx).Model(&model.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"email":             email,
+		"email_verified_at": nil,
+	}).Error
+}
+
+// MarkUserEmailVerified 把邮箱标记为已验证。
+// 只有用户当前的邮箱仍是 email 时才标记，返回是否标记；验证链接发出后用户修改了邮箱时返回 false。
+func MarkUserEmailVerified(ctx context.Context, userID uint, email string, verifiedAt time.Time) (bool, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "MarkUserEmailVerified")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	res := database.DB.WithContext(ctx).Model(&model.User{}).Where("id = ? AND email = ?", userID, email).
+		Update("email_verified_at", verifiedAt)
+	return res.RowsAffected > 0, res.Error
+}
+
+// UserSearchQuery 管理员查询用户列表的条件
+type UserSearchQuery struct {
+	// Keyword 可选，匹配用户名或邮箱的一部分；为数字时同时匹配用户ID
+	Keyword string
+	// Role 可选，只返回该角色的用户
+	Role string
+	// Suspended 可选，只返回当前被停用或封禁的用户
+	Suspended bool
+	// IncludeDeleted 是否包括已删除的用户
+	IncludeDeleted bool
+	Page           int
+	PageSize       int
+}
+
+// SearchUsers 按条件分页查询用户，按用户ID排序，返回本页用户和符合条件的总数
+func SearchUsers(ctx context.Context, q UserSearchQuery) ([]model.User, int64, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "SearchUsers")()
+	ctx, cancel := withTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	db := database.DB.WithContext(ctx).Model(&model.User{})
+	if q.IncludeDeleted {
+		db = db.Unscoped()
+	}
+	if q.Keyword != "" {
+		like := "%" + escapeLike(q.Keyword) + "%"
+		if id, err := strconv.ParseUint(q.Keyword, 10, 64); err == nil {
+			db = db.Where("id = ? OR username LIKE ? OR email LIKE ?", id, like, like)
+		} else {
+			db = db.Where("username LIKE ? OR email LIKE ?", like, like)
+		}
+	}
+	if q.Role != "" {
+		db = db.Where("role = ?", q.Role)
+	}
+	if q.Suspended {
+		db = db.Where("disabled = ? OR suspended_until > ?", true, time.Now())
+	}
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var users []model.User
+	err := db.Order("id").Offset((q.Page - 1) * q.PageSize).Limit(q.PageSize).Find(&users).Error
+	return users, total, err
+}
+
+// escapeLike 转义 LIKE 模式中的通配符，使关键词按字面匹配
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// GetUserByIDUnscoped 根据用户ID查询用户，包括已删除的用户
+func GetUserByIDUnscoped(ctx context.Context, userID uint) (*model.User, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "GetUserByIDUnscoped")()
+	ctx, cancel := withTimeout(ctx, queryTimeout)
+	defer cancel()
 	var user model.User
-	err := database.DB.First(&user, userID).Error
+	err := database.DB.WithContext(ctx).Unscoped().First(&user, userID).Error
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
+
+// RevokeUserTokens 递增 token 版本，使用户之前签发的 token 全部失效
+func RevokeUserTokens(ctx context.Context, userID uint) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "RevokeUserTokens")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	return database.DB.WithContext(ctx).Model(&model.User{}).Where("id = ?", userID).
+		Update("token_version", gorm.Expr("token_version + 1")).Error
+}
+
+// SuspendUser 停用（disabled 为 true）或封禁账号到 until，reason 为展示给用户的原因
+func SuspendUser(ctx context.Context, userID uint, disabled bool, until *time.Time, reason string) error {
+	defer metrics.ObserveQuery(metrics.MySQL, "SuspendUser")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	return database.DB.WithContext(ctx).Model(&model.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"disabled":        disabled,
+		"suspended_until": until,
+		"suspend_reason":  reason,
+	}).Error
+}
+
+// UnsuspendUser 恢复被停用或封禁的账号
+func UnsuspendUser(ctx context.Context, userID uint) error {
+	return SuspendUser(ctx, userID, false, nil, "")
+}
+
+// SoftDeleteUser 软删除用户（设置 deleted_at），返回是否删除。
+// 删除后的用户查询不到、不能登录，用户名仍被占用，防止他人注册同名账号冒充。
+func SoftDeleteUser(ctx context.Context, userID uint) (bool, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "SoftDeleteUser")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	res := database.DB.WithContext(ctx).Delete(&model.User{}, userID)
+	return res.RowsAffected > 0, res.Error
+}
+
+// RestoreUser 恢复已软删除的用户，返回是否恢复
+func RestoreUser(ctx context.Context, userID uint) (bool, error) {
+	defer metrics.ObserveQuery(metrics.MySQL, "RestoreUser")()
+	ctx, cancel := withTimeout(ctx, writeTimeout)
+	defer cancel()
+	res := database.DB.WithContext(ctx).Unscoped().Model(&model.User{}).
+		Where("id = ? AND deleted_at IS NOT NULL", userID).Update("deleted_at", nil)
+	return res.RowsAffected > 0, res.Error
+}
@@ -1,11 +1,17 @@
 package middleware
 
 import (
+	"log/slog"
 	"net/http"
+	"polychat/internal/dao"
+	"polychat/internal/model"
+	"polychat/pkg/logger"
 	"polychat/pkg/util"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 func JWTAuthMiddleware() gin.HandlerFunc {
@@ -42,9 +48,38 @@ func JWTAuthMiddleware() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+		// 修改或重置密码后 token 版本递增，之前签发的 token 不再有效
+		user, err := dao.GetUserByID(c.Request.Context(), claims.UserID)
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusUnauthorized, gin.H{"code": "403", "msg": "token无效: 用户不存在"})
+			} else {
+				slog.ErrorContext(c.Request.Context(), "查询用户失败", "user_id", claims.UserID, "err", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"code": "500", "msg": "服务器错误"})
+			}
+			c.Abort()
+			return
+		}
+		// 修改角色时 token 版本同样递增，这里再校验一次角色，权限判断以数据库中的角色为准
+		role := model.NormalizeRole(user.Role)
+		if user.TokenVersion != claims.TokenVersion || model.NormalizeRole(claims.Role) != role {
+			c.JSON(http.StatusUnauthorized, gin.H{"code": "403", "msg": "token已失效，请重新登录"})
+			c.Abort()
+			return
+		}
+		// 停用或封禁期间已签发的 token 也不能访问接口
+		if user.Suspended(time.Now()) {
+			c.JSON(http.StatusForbidden, gin.H{"code": "403", "msg": "账号已被停用"})
+			c.Abort()
+			return
+		}
+
 		//将claims中的用户信息设置到上下文
 		c.Set("userID", claims.UserID) // 便利后续使用
+		c.Set("role", role)            // 供 RequirePermission 使用
 		c.Set("claims", claims)
+		// 之后的日志都带上 user_id
+		c.Request = c.Request.WithContext(logger.With(c.Request.Context(), "user_id", claims.UserID))
 		c.Next()
 	}
 }
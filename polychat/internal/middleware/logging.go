@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"log/slog"
+	"regexp"
+	"time"
+
+	"polychat/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader 请求ID的请求头/响应头名称
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID 接受上游（网关、负载均衡）传入的请求ID的格式，避免日志注入
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestIDMiddleware 为每个请求分配请求ID：
+// 优先沿用上游传入的 X-Request-ID，否则生成新的ID；
+// 请求ID写入响应头，并放入请求 context 的日志字段，后续 service / DAO 的日志都会带上 request_id。
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = logger.NewID()
+		}
+		c.Header(RequestIDHeader, id)
+		c.Set("requestID", id)
+		c.Request = c.Request.WithContext(logger.With(c.Request.Context(), "request_id", id))
+		c.Next()
+	}
+}
+
+// AccessLogMiddleware 在请求结束后记录访问日志，替代 gin 默认的文本日志。
+// 5xx 记为 error，4xx 记为 warn，其余记为 info。
+// WebSocket 升级请求在升级完成时即返回，latency 不包括连接时长。
+func AccessLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", status,
+			"latency", time.Since(start),
+			"client_ip", c.ClientIP(),
+			"bytes", c.Writer.Size(),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.String())
+		}
+		slog.Log(c.Request.Context(), level, "HTTP 请求", attrs...)
+	}
+}
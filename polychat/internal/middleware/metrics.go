@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"polychat/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsMiddleware 记录每个 HTTP 请求的耗时。
+// route 标签使用路由模板（如 /api/v1/message/history），未匹配到路由的请求统一记为 "unmatched"，
+// 避免路径参数或扫描请求导致标签基数无限增长。
+// WebSocket 升级请求在升级完成时即返回，耗时不包括连接时长。
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.HTTPRequestDuration.
+			WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
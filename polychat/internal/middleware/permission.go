@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+
+	"polychat/internal/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequirePermission 要求当前用户的角色拥有权限 perm，否则返回 403。
+// 必须放在 JWTAuthMiddleware 之后，角色取自 JWTAuthMiddleware 从数据库查出的用户角色：
+//
+//	admin.POST("/users/role", middleware.RequirePermission(model.PermManageRoles), adminHandle.SetUserRole)
+func RequirePermission(perm model.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		if !model.HasPermission(role, perm) {
+			slog.WarnContext(c.Request.Context(), "权限不足", "role", role, "permission", perm, "path", c.FullPath())
+			c.JSON(http.StatusForbidden, gin.H{"code": 403, "msg": "权限不足"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"polychat/pkg/metrics"
+	"polychat/pkg/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitKey 返回限流维度的 key，key 相同的请求共享同一个令牌桶
+type RateLimitKey func(c *gin.Context) string
+
+// ByIP 按客户端IP限流，用于登录、注册等未登录即可访问的接口。
+// 客户端IP的来源受 gin 的可信代理配置控制，见 main.go 中的 POLYCHAT_TRUSTED_PROXIES。
+func ByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// ByUser 按登录用户限流，需放在 JWTAuthMiddleware 之后；未登录时退化为按IP限流
+func ByUser(c *gin.Context) string {
+	if uid, ok := c.Get("userID"); ok {
+		return "user:" + strconv.FormatUint(uint64(uid.(uint)), 10)
+	}
+	return ByIP(c)
+}
+
+// RateLimitMiddleware 按规则 name 限流，超出限制时返回 429 并通过 Retry-After 告知客户端等待的秒数。
+// 规则参数为 def，可通过环境变量 POLYCHAT_RATELIMIT_<NAME> 覆盖（见 ratelimit.Rule）。
+// 限流后端出错时放行请求，避免 Redis 故障导致全部接口不可用。
+func RateLimitMiddleware(name string, def ratelimit.Limit, key RateLimitKey) gin.HandlerFunc {
+	limit := ratelimit.Rule(name, def)
+	if !limit.Enabled() {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		res, err := ratelimit.Default.Allow(ctx, "http:"+name+":"+key(c), limit)
+		if err != nil {
+			slog.WarnContext(ctx, "限流检查失败，放行请求", "rule", name, "err", err)
+			c.Next()
+			return
+		}
+		if !res.Allowed {
+			metrics.RateLimited.WithLabelValues(name).Inc()
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(res.RetryAfter.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"code": "429",
+				"msg":  "请求过于频繁，请稍后再试",
+			})
+			return
+		}
+		c.Next()
+	}
+}
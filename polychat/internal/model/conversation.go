@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// ConversationSetting 会话级别设置表
+// 一个会话由两个用户组成，主键固定为 (UserA, UserB) 且 UserA < UserB，
+// 保证同一个会话无论由哪一方修改都落在同一条记录上。
+type ConversationSetting struct {
+	UserA uint `gorm:"primaryKey" json:"user_a"`
+	UserB uint `gorm:"primaryKey" json:"user_b"`
+	// RetentionDays 消息保留天数，超过该天数的消息会被自动删除，0 表示不启用
+	RetentionDays int `gorm:"not null;default:0" json:"retention_days"`
+	// DisappearSeconds 阅后即焚时长（秒），开启后会话中的新消息在阅读后经过该时长删除，0 表示关闭
+	DisappearSeconds int64     `gorm:"not null;default:0" json:"disappear_seconds"`
+	UpdatedBy        uint      `gorm:"not null" json:"updated_by"` // 最后修改设置的用户ID
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// ConversationKey 返回两个用户所在会话的主键（较小的ID在前）
+func ConversationKey(userID, targetID uint) (uint, uint) {
+	if userID < targetID {
+		return userID, targetID
+	}
+	return targetID, userID
+}
@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// UserIdentity 外部身份提供方（OIDC）的账号与本地用户的绑定关系。
+// 同一个外部账号 (Provider, Subject) 只能绑定一个本地用户，一个本地用户可以绑定多个外部账号。
+type UserIdentity struct {
+	ID     uint `gorm:"primaryKey" json:"id"`
+	UserID uint `gorm:"not null;index" json:"user_id"`
+	// Provider 身份提供方名称，见 POLYCHAT_OIDC_PROVIDER_NAME
+	Provider string `gorm:"type:varchar(64);not null;uniqueIndex:idx_identity_subject" json:"provider"`
+	// Subject 外部账号的唯一标识（ID Token 的 sub），不会随用户修改邮箱或用户名改变
+	Subject string `gorm:"type:varchar(255);not null;uniqueIndex:idx_identity_subject" json:"subject"`
+	// Email 绑定或最近一次登录时身份提供方返回的邮箱，仅用于展示
+	Email      string     `gorm:"type:varchar(100)" json:"email"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
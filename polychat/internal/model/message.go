@@ -4,7 +4,11 @@
 // 但增加了 MongoDB 特有的 _id 字段用于文档唯一标识。
 package model
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 // ChatMessage 表示存储在 MongoDB 中的一条聊天消息文档。
 // 集合名称: messages
@@ -13,8 +17,8 @@ type ChatMessage struct {
 	// ID 是 MongoDB 自动生成的文档唯一标识符 (_id)
 	ID primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 
-	// Type 消息类型，与 ws.TypeChat / ws.TypeHeartbeat 对应
-	// 目前仅 "chat" 类型的消息会被持久化
+	// Type 消息类型，与 ws.TypeChat / ws.TypeSystem 等对应
+	// 目前仅 "chat" 和 "system" 类型的消息会被持久化
 	Type string `bson:"type" json:"type"`
 
 	// SenderID 发送者的用户ID（对应 MySQL users 表的主键）
@@ -29,4 +33,14 @@ type ChatMessage struct {
 	// Timestamp 消息发送时间的 Unix 时间戳（秒）
 	// 由服务器在收到消息时生成，保证时间一致性
 	Timestamp int64 `bson:"timestamp" json:"timestamp"`
+
+	// ExpiresIn 阅后即焚时长（秒），接收方阅读后经过该时长删除；0 表示普通消息
+	ExpiresIn int64 `bson:"expires_in,omitempty" json:"expires_in,omitempty"`
+
+	// ReadAt 接收方阅读的时间，仅阅后即焚消息记录
+	ReadAt *time.Time `bson:"read_at,omitempty" json:"read_at,omitempty"`
+
+	// ExpireAt 消息的删除时间，仅阅后即焚消息有值。
+	// 发送时设置为未读消息的最长保留时间，阅读后提前到 ReadAt + ExpiresIn。
+	ExpireAt *time.Time `bson:"expire_at,omitempty" json:"expire_at,omitempty"`
 }
@@ -0,0 +1,52 @@
+package model
+
+// 用户角色，保存在 User.Role 中，同时写入会话 token 的 role 字段
+const (
+	RoleUser      = "user"      // 普通用户
+	RoleModerator = "moderator" // 版主：处理违规内容和用户
+	RoleAdmin     = "admin"     // 管理员：拥有全部权限，可以修改其他用户的角色
+)
+
+// Permission 权限，接口通过 middleware.RequirePermission 声明需要的权限
+type Permission string
+
+// 权限列表
+const (
+	PermViewUsers      Permission = "users:view"       // 查看用户资料、登录设备等管理信息
+	PermManageUsers    Permission = "users:manage"     // 封禁、解封和删除用户
+	PermManageSessions Permission = "sessions:manage"  // 强制用户下线
+	PermModerate       Permission = "content:moderate" // 处理违规消息
+	PermViewAuditLog   Permission = "audit:view"       // 查看安全审计日志
+	PermManageRoles    Permission = "roles:manage"     // 修改用户角色
+)
+
+// rolePermissions 每个角色拥有的权限，管理员拥有全部权限
+var rolePermissions = map[string][]Permission{
+	RoleUser:      {},
+	RoleModerator: {PermViewUsers, PermManageSessions, PermModerate},
+	RoleAdmin:     {PermViewUsers, PermManageUsers, PermManageSessions, PermModerate, PermViewAuditLog, PermManageRoles},
+}
+
+// ValidRole 是否为已定义的角色
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// NormalizeRole 返回角色名，空字符串（添加角色之前的用户和 token）视为普通用户
+func NormalizeRole(role string) string {
+	if role == "" {
+		return RoleUser
+	}
+	return role
+}
+
+// HasPermission 角色是否拥有权限，未定义的角色没有任何权限
+func HasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[NormalizeRole(role)] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package model
+
+import "time"
+
+// 登录失败计数的统计维度
+const (
+	LoginScopeUser = "user" // 按用户名统计，用户名不存在时同样计数，避免暴露账号是否存在
+	LoginScopeIP   = "ip"   // 按客户端IP统计
+)
+
+// LoginFailure 登录失败计数表，主键为 (Scope, Subject)。
+// 统计窗口内连续失败的次数，用于逐步延长重试间隔，达到上限后临时锁定。
+type LoginFailure struct {
+	Scope   string `gorm:"primaryKey;type:varchar(10)"`
+	Subject string `gorm:"primaryKey;type:varchar(64)"` // 小写用户名或IP
+	// Count 当前统计窗口内的失败次数，距上次失败超过统计窗口后重新计数
+	Count        int       `gorm:"not null;default:0"`
+	LastFailedAt time.Time `gorm:"not null"`
+	// LockedUntil 锁定截止时间，为空表示未锁定
+	LockedUntil *time.Time
+}
+
+// 审计事件类型
+const (
+	AuditLoginLockout             = "login_lockout"                // 登录失败次数过多，账号或IP被临时锁定
+	AuditTwoFactorEnabled         = "2fa_enabled"                  // 开启两步验证
+	AuditTwoFactorDisabled        = "2fa_disabled"                 // 关闭两步验证
+	AuditRecoveryCodeUsed         = "2fa_recovery_code_used"       // 使用恢复码完成两步验证
+	AuditRecoveryCodesRegenerated = "2fa_recovery_codes_generated" // 重新生成恢复码
+	AuditPasswordChanged          = "password_changed"             // 修改密码
+	AuditPasswordResetRequested   = "password_reset_requested"     // 申请通过邮件重置密码
+	AuditPasswordReset            = "password_reset"               // 通过邮件重置密码
+	AuditSSOLogin                 = "sso_login"                    // 通过外部身份提供方登录
+	AuditSSORegistered            = "sso_registered"               // 首次通过外部身份提供方登录，自动创建本地用户
+	AuditSSOLinked                = "sso_linked"                   // 绑定外部账号
+	AuditSSOUnlinked              = "sso_unlinked"                 // 解绑外部账号
+	AuditRoleChanged              = "role_changed"                 // 修改用户角色，Detail 为 "旧角色 -> 新角色"
+	AuditAdminSearchUsers         = "admin_search_users"           // 管理员查询用户列表，Detail 为查询条件
+	AuditAdminViewSessions        = "admin_view_sessions"          // 管理员查看用户的登录会话和连接
+	AuditAdminViewAuditLogs       = "admin_view_audit_logs"        // 管理员查看审计日志，Detail 为查询条件
+	AuditAdminDisconnect          = "admin_disconnect"             // 管理员强制用户下线
+	AuditAdminRevokeSessions      = "admin_revoke_sessions"        // 管理员吊销用户的全部 token
+	AuditAdminPasswordReset       = "admin_password_reset"         // 管理员重置用户密码
+	AuditUserSuspended            = "user_suspended"               // 停用或封禁账号
+	AuditUserUnsuspended          = "user_unsuspended"             // 恢复账号
+	AuditUserDeleted              = "user_deleted"                 // 删除账号（软删除）
+	AuditUserRestored             = "user_restored"                // 恢复已删除的账号
+)
+
+// AuditLog 安全审计日志表，记录账号锁定等安全相关事件
+type AuditLog struct {
+	ID     uint   `gorm:"primaryKey" json:"id"`
+	Action string `gorm:"type:varchar(50);not null;index" json:"action"` // 事件类型，见 Audit* 常量
+	// UserID 事件涉及的用户，0 表示无法对应到用户（如用户名不存在或按IP锁定）
+	UserID uint `gorm:"not null;default:0;index" json:"user_id"`
+	// ActorID 执行操作的用户，管理员操作时为管理员ID，用户本人的操作和系统事件为 0
+	ActorID   uint      `gorm:"not null;default:0;index" json:"actor_id"`
+	IP        string    `gorm:"type:varchar(64)" json:"ip"`
+	Detail    string    `gorm:"type:varchar(255)" json:"detail"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// 登录会话的登录方式
+const (
+	SessionPassword       = "password"        // 密码登录（包括随后的两步验证）
+	SessionSSO            = "sso"             // 单点登录
+	SessionPasswordChange = "password_change" // 修改密码后重新签发给当前客户端
+)
+
+// LoginSession 登录会话记录表：每次签发会话 token 时记录一条，供管理员查看用户在哪些地方登录过。
+// token 本身是无状态的，这里只是记录；吊销需要递增用户的 token 版本。
+type LoginSession struct {
+	ID     uint `gorm:"primaryKey" json:"id"`
+	UserID uint `gorm:"not null;index" json:"user_id"`
+	// TokenVersion 签发时用户的 token 版本，与用户当前版本不同说明该会话已被吊销
+	TokenVersion uint      `gorm:"not null" json:"token_version"`
+	Method       string    `gorm:"type:varchar(20);not null" json:"method"` // 登录方式，见 Session* 常量
+	IP           string    `gorm:"type:varchar(64)" json:"ip"`
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `gorm:"index" json:"expires_at"`
+}
+
+// RecoveryCode 两步验证的恢复码表。
+// 用户无法使用验证器应用时，可以用恢复码代替验证码，每个恢复码只能使用一次。
+// 只保存 bcrypt 哈希，明文仅在生成时返回给用户一次。
+type RecoveryCode struct {
+	ID       uint   `gorm:"primaryKey"`
+	UserID   uint   `gorm:"not null;index"`
+	CodeHash string `gorm:"type:varchar(100);not null"`
+	// UsedAt 使用时间，为空表示未使用
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
@@ -1,6 +1,8 @@
 package model
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
@@ -18,5 +20,54 @@ type User struct {
 	//密码允许重复
 	Password string `gorm:"type:varchar(100);not null"` // 存加密之后的哈希值
 	Email    string `gorm:"type:varchar(100)"`          //邮箱email
-	Avatar   string `gorm:"type:varchar(255)"`          // 头像URL
+	//邮箱验证时间，为空表示邮箱未验证；修改邮箱后需要重新验证。
+	//只有验证过的邮箱才会用于重置密码和通知
+	EmailVerifiedAt *time.Time
+	Avatar          string `gorm:"type:varchar(255)"` // 头像URL
+	//最后在线时间，用户下线时记录，从未上线过为空
+	LastSeen *time.Time
+	//隐藏在线状态：开启后好友看不到该用户的在线状态和最后在线时间
+	HidePresence bool `gorm:"not null;default:false"`
+	//自定义状态：文字、表情和可选的过期时间（为空表示不过期）
+	StatusText      string `gorm:"type:varchar(100)"`
+	StatusEmoji     string `gorm:"type:varchar(32)"`
+	StatusExpiresAt *time.Time
+	//免打扰：开启后不推送好友请求、上线下线等通知，但聊天消息照常送达
+	DoNotDisturb bool `gorm:"not null;default:false"`
+	//两步验证：TOTPSecret 为 base32 编码的 TOTP 密钥，开始绑定时生成；
+	//用户提交验证码确认绑定后 TOTPEnabled 才开启，登录时才需要验证码
+	TOTPSecret  string `gorm:"type:varchar(64)"`
+	TOTPEnabled bool   `gorm:"not null;default:false"`
+	//最近一次验证通过的 TOTP 时间步，同一个验证码不能重复使用
+	TOTPLastStep int64 `gorm:"not null;default:0"`
+	//token 版本：修改或重置密码、修改角色时递增，签发时版本号不同的 token 全部失效
+	TokenVersion uint `gorm:"not null;default:0"`
+	//角色，见 Role* 常量，决定用户拥有的权限
+	Role string `gorm:"type:varchar(20);not null;default:user"`
+	//账号停用：Disabled 为管理员停用，直到手动恢复；SuspendedUntil 为临时封禁的截止时间。
+	//停用或封禁期间不能登录，已签发的 token 也不能访问接口
+	Disabled       bool `gorm:"not null;default:false"`
+	SuspendedUntil *time.Time
+	SuspendReason  string `gorm:"type:varchar(255)"`
+}
+
+// Suspended 账号当前是否被停用或封禁
+func (u *User) Suspended(now time.Time) bool {
+	return u.Disabled || (u.SuspendedUntil != nil && u.SuspendedUntil.After(now))
+}
+
+// VerifiedEmail 返回已验证的邮箱，未绑定或未验证时返回空字符串
+func (u *User) VerifiedEmail() string {
+	if u.EmailVerifiedAt == nil {
+		return ""
+	}
+	return u.Email
+}
+
+// ActiveStatus 返回当前有效的自定义状态，已过期时返回空字符串
+func (u *User) ActiveStatus(now time.Time) (text, emoji string, expiresAt *time.Time) {
+	if u.StatusExpiresAt != nil && !u.StatusExpiresAt.After(now) {
+		return "", "", nil
+	}
+	return u.StatusText, u.StatusEmoji, u.StatusExpiresAt
 }
@@ -0,0 +1,393 @@
+package service
+
+// 管理员的用户管理：查询用户、查看会话、强制下线、停用封禁、删除和重置密码。
+// 每个操作（包括只读的查询）都记录审计日志，ActorID 为执行操作的管理员。
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+	"unicode/utf8"
+
+	"polychat/internal/dao"
+	"polychat/internal/model"
+	"polychat/internal/ws"
+
+	"gorm.io/gorm"
+)
+
+// 管理员查询的分页参数
+const (
+	adminDefaultPageSize = 20
+	adminMaxPageSize     = 100
+	// maxDetailLen 审计日志 Detail 和停用原因的最大字符数，与表字段长度一致
+	maxDetailLen = 255
+)
+
+var (
+	ErrActOnSelf        = errors.New("不能对自己执行该操作")
+	ErrTargetProtected  = errors.New("不能对同级或更高级别的用户执行该操作")
+	ErrInvalidDuration  = errors.New("封禁时长无效")
+	ErrUserNotDeleted   = errors.New("该用户未被删除")
+	ErrUserDeleted      = errors.New("该用户已被删除")
+	ErrNoVerifiedEmail  = errors.New("该用户没有已验证的邮箱，无法发送重置邮件")
+	ErrSuspendReasonLen = errors.New("停用原因不能超过255个字符")
+)
+
+// roleRank 角色的级别，非管理员只能管理级别比自己低的用户
+var roleRank = map[string]int{
+	model.RoleUser:      0,
+	model.RoleModerator: 1,
+	model.RoleAdmin:     2,
+}
+
+// AdminActor 执行管理操作的用户，Role 取自 JWTAuthMiddleware 查出的角色
+type AdminActor struct {
+	ID   uint
+	Role string
+	IP   string
+}
+
+// audit 记录一条由该用户执行的管理操作
+func (a AdminActor) audit(ctx context.Context, action string, userID uint, detail string) {
+	recordAudit(ctx, &model.AuditLog{Action: action, UserID: userID, ActorID: a.ID, IP: a.IP, Detail: detail})
+}
+
+// AdminUserView 管理员看到的用户信息，不包括密码哈希、两步验证密钥等敏感字段
+type AdminUserView struct {
+	ID             uint       `json:"id"`
+	Username       string     `json:"username"`
+	Email          string     `json:"email"`
+	EmailVerified  bool       `json:"email_verified"`
+	Avatar         string     `json:"avatar"`
+	Role           string     `json:"role"`
+	TOTPEnabled    bool       `json:"totp_enabled"`
+	Disabled       bool       `json:"disabled"`
+	SuspendedUntil *time.Time `json:"suspended_until"`
+	SuspendReason  string     `json:"suspend_reason"`
+	Suspended      bool       `json:"suspended"` // 当前是否处于停用或封禁状态
+	Online         bool       `json:"online"`
+	LastSeen       *time.Time `json:"last_seen"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeletedAt      *time.Time `json:"deleted_at"` // 为空表示未删除
+}
+
+func newAdminUserView(user *model.User, now time.Time) AdminUserView {
+	view := AdminUserView{
+		ID:             user.ID,
+		Username:       user.Username,
+		Email:          user.Email,
+		EmailVerified:  user.EmailVerifiedAt != nil,
+		Avatar:         user.Avatar,
+		Role:           model.NormalizeRole(user.Role),
+		TOTPEnabled:    user.TOTPEnabled,
+		Disabled:       user.Disabled,
+		SuspendedUntil: user.SuspendedUntil,
+		SuspendReason:  user.SuspendReason,
+		Suspended:      user.Suspended(now),
+		LastSeen:       user.LastSeen,
+		CreatedAt:      user.CreatedAt,
+	}
+	if user.DeletedAt.Valid {
+		deletedAt := user.DeletedAt.Time
+		view.DeletedAt = &deletedAt
+	}
+	return view
+}
+
+// UserPage 分页查询结果
+type UserPage struct {
+	Users    []AdminUserView `json:"users"`
+	Total    int64           `json:"total"`
+	Page     int             `json:"page"`
+	PageSize int             `json:"page_size"`
+}
+
+// AdminService 用户管理服务
+type AdminService struct{}
+
+// normalizePage 补全分页参数：页码从 1 开始，每页默认 20 条，最多 100 条
+func normalizePage(page, pageSize int) (int, int) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = adminDefaultPageSize
+	}
+	if pageSize > adminMaxPageSize {
+		pageSize = adminMaxPageSize
+	}
+	return page, pageSize
+}
+
+// SearchUsers 按关键词、角色、状态分页查询用户
+func (s *AdminService) SearchUsers(ctx context.Context, actor AdminActor, q dao.UserSearchQuery) (*UserPage, error) {
+	if q.Role != "" && !model.ValidRole(q.Role) {
+		return nil, ErrInvalidRole
+	}
+	q.Page, q.PageSize = normalizePage(q.Page, q.PageSize)
+	users, total, err := dao.SearchUsers(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	views := make([]AdminUserView, 0, len(users))
+	for i := range users {
+		view := newAdminUserView(&users[i], now)
+		view.Online = ws.ClientMgr.IsUserOnline(users[i].ID)
+		views = append(views, view)
+	}
+	actor.audit(ctx, model.AuditAdminSearchUsers, 0, truncateDetail(fmt.Sprintf("keyword=%q role=%s suspended=%t deleted=%t page=%d",
+		q.Keyword, q.Role, q.Suspended, q.IncludeDeleted, q.Page)))
+	return &UserPage{Users: views, Total: total, Page: q.Page, PageSize: q.PageSize}, nil
+}
+
+// SessionView 登录会话，Revoked 表示该会话的 token 已经因为修改密码、吊销等原因失效
+type SessionView struct {
+	model.LoginSession
+	Revoked bool `json:"revoked"`
+}
+
+// UserSessions 用户信息、未过期的登录会话和当前的 WebSocket 连接
+type UserSessions struct {
+	User       AdminUserView     `json:"user"`
+	Sessions   []SessionView     `json:"sessions"`
+	Connection ws.ConnectionInfo `json:"connection"`
+}
+
+// UserSessions 查看用户的登录会话和连接，已删除的用户也可以查看
+func (s *AdminService) UserSessions(ctx context.Context, actor AdminActor, userID uint) (*UserSessions, error) {
+	user, err := getTargetUnscoped(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	sessions, err := dao.GetActiveLoginSessions(ctx, userID, now)
+	if err != nil {
+		return nil, err
+	}
+	views := make([]SessionView, 0, len(sessions))
+	for _, session := range sessions {
+		views = append(views, SessionView{LoginSession: session, Revoked: session.TokenVersion != user.TokenVersion})
+	}
+	conn, err := ws.ClientMgr.Connection(userID)
+	if err != nil {
+		// 查询不到连接不影响查看会话
+		slog.ErrorContext(ctx, "查询用户连接失败", "user_id", userID, "err", err)
+	}
+
+	actor.audit(ctx, model.AuditAdminViewSessions, userID, "")
+	result := &UserSessions{User: newAdminUserView(user, now), Sessions: views, Connection: conn}
+	result.User.Online = conn.Online
+	return result, nil
+}
+
+// Disconnect 强制用户下线：断开用户在集群中的连接；revoke 为 true 时同时吊销用户的全部 token，
+// 否则客户端可以用原来的 token 重新连接。
+func (s *AdminService) Disconnect(ctx context.Context, actor AdminActor, userID uint, revoke bool) error {
+	user, err := getTarget(ctx, actor, userID)
+	if err != nil {
+		return err
+	}
+	if revoke {
+		if err := dao.RevokeUserTokens(ctx, user.ID); err != nil {
+			return err
+		}
+		ws.ClientMgr.Disconnect(user.ID, "sessions revoked")
+		actor.audit(ctx, model.AuditAdminRevokeSessions, user.ID, "")
+		return nil
+	}
+	ws.ClientMgr.Disconnect(user.ID, "disconnected by admin")
+	actor.audit(ctx, model.AuditAdminDisconnect, user.ID, "")
+	return nil
+}
+
+// Suspend 停用或封禁账号：duration 为 0 时停用，直到调用 Unsuspend；否则封禁 duration 后自动恢复。
+// 已签发的 token 由 JWTAuthMiddleware 拒绝，用户的连接立即断开。
+func (s *AdminService) Suspend(ctx context.Context, actor AdminActor, userID uint, duration time.Duration, reason string) error {
+	if duration < 0 {
+		return ErrInvalidDuration
+	}
+	if utf8.RuneCountInString(reason) > maxDetailLen {
+		return ErrSuspendReasonLen
+	}
+	user, err := getTarget(ctx, actor, userID)
+	if err != nil {
+		return err
+	}
+
+	var until *time.Time
+	detail := "disabled"
+	if duration > 0 {
+		t := time.Now().Add(duration)
+		until = &t
+		detail = "until " + t.Format(time.RFC3339)
+	}
+	if reason != "" {
+		detail += ": " + reason
+	}
+	if err := dao.SuspendUser(ctx, user.ID, duration == 0, until, reason); err != nil {
+		return err
+	}
+	ws.ClientMgr.Disconnect(user.ID, "account suspended")
+	actor.audit(ctx, model.AuditUserSuspended, user.ID, truncateDetail(detail))
+	return nil
+}
+
+// Unsuspend 恢复被停用或封禁的账号
+func (s *AdminService) Unsuspend(ctx context.Context, actor AdminActor, userID uint) error {
+	user, err := getTarget(ctx, actor, userID)
+	if err != nil {
+		return err
+	}
+	if err := dao.UnsuspendUser(ctx, user.ID); err != nil {
+		return err
+	}
+	actor.audit(ctx, model.AuditUserUnsuspended, user.ID, "")
+	return nil
+}
+
+// Delete 软删除用户：用户数据保留，可以通过 Restore 恢复；删除后用户的 token 失效、连接被断开
+func (s *AdminService) Delete(ctx context.Context, actor AdminActor, userID uint) error {
+	user, err := getTarget(ctx, actor, userID)
+	if err != nil {
+		return err
+	}
+	deleted, err := dao.SoftDeleteUser(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrTargetNotFound
+	}
+	ws.ClientMgr.Disconnect(user.ID, "account deleted")
+	actor.audit(ctx, model.AuditUserDeleted, user.ID, user.Username)
+	return nil
+}
+
+// Restore 恢复已删除的用户
+func (s *AdminService) Restore(ctx context.Context, actor AdminActor, userID uint) error {
+	user, err := getTargetUnscoped(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := checkTarget(actor, user); err != nil {
+		return err
+	}
+	if !user.DeletedAt.Valid {
+		return ErrUserNotDeleted
+	}
+	restored, err := dao.RestoreUser(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if !restored {
+		return ErrUserNotDeleted
+	}
+	actor.audit(ctx, model.AuditUserRestored, user.ID, user.Username)
+	return nil
+}
+
+// ResetPassword 重置用户密码。sendEmail 为 true 时向用户已验证的邮箱发送重置链接，由用户自己设置新密码，
+// 返回空字符串；否则生成一个临时密码并立即生效，只在这里返回一次，由管理员转交给用户。
+// 两种方式都会吊销用户之前的 token 并断开连接。
+func (s *AdminService) ResetPassword(ctx context.Context, actor AdminActor, userID uint, sendEmail bool) (string, error) {
+	user, err := getTarget(ctx, actor, userID)
+	if err != nil {
+		return "", err
+	}
+
+	if sendEmail {
+		if user.VerifiedEmail() == "" {
+			return "", ErrNoVerifiedEmail
+		}
+		// 先吊销，再用新的 token 版本生成重置链接，之前发出的重置链接一并失效
+		if err := dao.RevokeUserTokens(ctx, user.ID); err != nil {
+			return "", err
+		}
+		user.TokenVersion++
+		ws.ClientMgr.Disconnect(user.ID, "password reset by admin")
+		if err := sendResetMail(ctx, user, actor.IP); err != nil {
+			return "", err
+		}
+		actor.audit(ctx, model.AuditAdminPasswordReset, user.ID, "email")
+		return "", nil
+	}
+
+	password := rand.Text()
+	if err := setPassword(ctx, user, password); err != nil {
+		return "", err
+	}
+	clearLoginFailures(ctx, user.Username)
+	actor.audit(ctx, model.AuditAdminPasswordReset, user.ID, "temporary password")
+	return password, nil
+}
+
+// AuditLogPage 审计日志分页查询结果
+type AuditLogPage struct {
+	Logs     []model.AuditLog `json:"logs"`
+	Total    int64            `json:"total"`
+	Page     int              `json:"page"`
+	PageSize int              `json:"page_size"`
+}
+
+// AuditLogs 按用户、操作人和事件类型分页查询审计日志
+func (s *AdminService) AuditLogs(ctx context.Context, actor AdminActor, q dao.AuditLogQuery) (*AuditLogPage, error) {
+	q.Page, q.PageSize = normalizePage(q.Page, q.PageSize)
+	logs, total, err := dao.ListAuditLogs(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	actor.audit(ctx, model.AuditAdminViewAuditLogs, q.UserID, truncateDetail(fmt.Sprintf("actor_id=%d action=%s page=%d", q.ActorID, q.Action, q.Page)))
+	return &AuditLogPage{Logs: logs, Total: total, Page: q.Page, PageSize: q.PageSize}, nil
+}
+
+// getTarget 查询管理操作的目标用户（不包括已删除的用户），并校验操作人是否可以管理该用户
+func getTarget(ctx context.Context, actor AdminActor, userID uint) (*model.User, error) {
+	user, err := getTargetUnscoped(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.DeletedAt.Valid {
+		return nil, ErrUserDeleted
+	}
+	if err := checkTarget(actor, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// getTargetUnscoped 查询用户，包括已删除的用户
+func getTargetUnscoped(ctx context.Context, userID uint) (*model.User, error) {
+	user, err := dao.GetUserByIDUnscoped(ctx, userID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrTargetNotFound
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// checkTarget 不能对自己执行管理操作；管理员可以管理所有其他用户，其他角色只能管理级别比自己低的用户
+func checkTarget(actor AdminActor, target *model.User) error {
+	if actor.ID == target.ID {
+		return ErrActOnSelf
+	}
+	actorRole := model.NormalizeRole(actor.Role)
+	if actorRole != model.RoleAdmin && roleRank[model.NormalizeRole(target.Role)] >= roleRank[actorRole] {
+		return ErrTargetProtected
+	}
+	return nil
+}
+
+// truncateDetail 截断审计日志的 Detail，不超过字段长度
+func truncateDetail(detail string) string {
+	if runes := []rune(detail); len(runes) > maxDetailLen {
+		return string(runes[:maxDetailLen])
+	}
+	return detail
+}
@@ -0,0 +1,18 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+
+	"polychat/internal/dao"
+	"polychat/internal/model"
+)
+
+// recordAudit 记录一条审计事件：同时写入日志和 audit_logs 表。
+// 写表失败只记录错误，不影响调用方的业务流程。
+func recordAudit(ctx context.Context, entry *model.AuditLog) {
+	slog.InfoContext(ctx, "安全审计事件", "action", entry.Action, "audit_user_id", entry.UserID, "actor_id", entry.ActorID, "ip", entry.IP, "detail", entry.Detail)
+	if err := dao.CreateAuditLog(ctx, entry); err != nil {
+		slog.ErrorContext(ctx, "写入审计日志失败", "action", entry.Action, "err", err)
+	}
+}
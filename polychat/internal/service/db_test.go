@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"polychat/internal/dao"
+	"polychat/internal/model"
+	"polychat/pkg/database"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// 需要数据库的测试通过环境变量 POLYCHAT_TEST_MYSQL 指定测试库的 DSN，未设置时跳过，例如
+// POLYCHAT_TEST_MYSQL='root:pass@tcp(127.0.0.1:3306)/polychat_test?charset=utf8mb4&parseTime=True&loc=Local'。
+// 测试会在该库中建表并写入数据，不要指向生产库。
+
+// setupTestDB 连接测试库并建表，未配置时跳过测试
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	dsn := os.Getenv("POLYCHAT_TEST_MYSQL")
+	if dsn == "" {
+		t.Skip("未设置 POLYCHAT_TEST_MYSQL，跳过数据库测试")
+	}
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{Logger: logger.Discard, TranslateError: true})
+	if err != nil {
+		t.Fatalf("连接测试库失败: %v", err)
+	}
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("创建表失败: %v", err)
+	}
+	database.DB = db
+}
+
+// createTestUser 创建一个用户名唯一的测试用户，prefix 不超过 7 个字符
+func createTestUser(t *testing.T, prefix string, fn func(u *model.User)) *model.User {
+	t.Helper()
+	user := &model.User{Username: fmt.Sprintf("%s%d", prefix, time.Now().UnixNano()%1e12)}
+	if fn != nil {
+		fn(user)
+	}
+	if err := dao.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("创建测试用户失败: %v", err)
+	}
+	return user
+}
@@ -0,0 +1,222 @@
+// Package service 提供业务逻辑层。
+// 本文件负责阅后即焚（disappearing messages）：
+//   - 单条消息可以通过 ws.Message.ExpiresIn 指定阅后即焚时长
+//   - 会话可以整体开启阅后即焚，开启后新消息默认带上会话设置的时长，并在会话中发送系统通知
+//   - 接收方通过 read 帧上报已读后开始计时，到期后从 MongoDB 删除并向会话双方推送 recall 帧
+//   - 一直未读的消息在发送后 POLYCHAT_DISAPPEAR_UNREAD_TTL（默认 7 天）删除
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"polychat/internal/dao"
+	"polychat/internal/model"
+	"polychat/internal/ws"
+	"polychat/pkg/config"
+	"polychat/pkg/logger"
+	"polychat/pkg/telemetry"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gorm.io/gorm"
+)
+
+// MaxDisappearSeconds 阅后即焚时长的上限（7 天）
+const MaxDisappearSeconds = 7 * 24 * 3600
+
+// expiryBatchSize 每次扫描处理的到期消息数
+const expiryBatchSize = 500
+
+var (
+	// disappearUnreadTTL 未读的阅后即焚消息最长保留时间
+	disappearUnreadTTL = config.GetDuration("POLYCHAT_DISAPPEAR_UNREAD_TTL", 7*24*time.Hour)
+	// expiryInterval 到期消息的扫描间隔
+	expiryInterval = config.GetDuration("POLYCHAT_DISAPPEAR_INTERVAL", 5*time.Second)
+)
+
+// PrepareMessage 在消息转发和持久化之前补全服务器侧字段：
+//   - 为聊天消息分配消息ID
+//   - 客户端未指定 ExpiresIn（或不是正数）时，使用会话的阅后即焚设置，发送方不能借此绕过会话设置
+//   - 把 ExpiresIn 限制在 [0, MaxDisappearSeconds] 范围内
+func (s *MessageService) PrepareMessage(ctx context.Context, msg *ws.Message) {
+	if msg.Type != ws.TypeChat {
+		return
+	}
+	msg.ID = primitive.NewObjectID().Hex()
+
+	if msg.ExpiresIn <= 0 {
+		msg.ExpiresIn = 0
+		if setting, err := dao.GetConversationSetting(ctx, msg.SenderID, msg.ReceiverID); err == nil {
+			msg.ExpiresIn = setting.DisappearSeconds
+		}
+	}
+	if msg.ExpiresIn > MaxDisappearSeconds {
+		msg.ExpiresIn = MaxDisappearSeconds
+	}
+}
+
+// MarkRead 处理接收方上报的已读，阅后即焚消息从此刻开始计时。
+// 非阅后即焚消息、不属于该用户的消息或重复上报会被静默忽略。
+func (s *MessageService) MarkRead(ctx context.Context, userID uint, messageID string) error {
+	id, err := primitive.ObjectIDFromHex(messageID)
+	if err != nil {
+		return errors.New("消息ID格式错误")
+	}
+	_, err = s.messageDAO.MarkMessageRead(ctx, id, userID, time.Now())
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	return err
+}
+
+// GetDisappearing 获取会话的阅后即焚时长（秒），0 表示未开启。
+func (s *MessageService) GetDisappearing(ctx context.Context, userID, targetID uint) (int64, error) {
+	setting, err := dao.GetConversationSetting(ctx, userID, targetID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return setting.DisappearSeconds, nil
+}
+
+// SetDisappearing 开启或关闭会话的阅后即焚，seconds 为 0 表示关闭，只能在互为好友的双方之间设置。
+// 设置变化时向会话中发送一条系统通知，并推送给在线的双方。
+func (s *MessageService) SetDisappearing(ctx context.Context, userID, targetID uint, seconds int64) error {
+	if userID == targetID {
+		return errors.New("不能对自己设置阅后即焚")
+	}
+	if seconds < 0 || seconds > MaxDisappearSeconds {
+		return fmt.Errorf("阅后即焚时长必须在 0 到 %d 秒之间", MaxDisappearSeconds)
+	}
+	user, err := dao.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := dao.GetUserByID(ctx, targetID); err != nil {
+		return errors.New("目标用户不存在")
+	}
+	// 系统通知会写入会话并推送给对方，只有互为好友时才能设置，否则会绕过拉黑
+	if !s.relationService.AreFriends(ctx, userID, targetID) {
+		return ErrNotFriends
+	}
+
+	setting, err := dao.GetConversationSetting(ctx, userID, targetID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		setting = &model.ConversationSetting{UserA: userID, UserB: targetID}
+	}
+	if setting.DisappearSeconds == seconds {
+		return nil
+	}
+	setting.DisappearSeconds = seconds
+	setting.UpdatedBy = userID
+	if err := dao.SaveConversationSetting(ctx, setting); err != nil {
+		return err
+	}
+
+	// 在会话中发送系统通知
+	content := fmt.Sprintf("%s 关闭了阅后即焚", user.Username)
+	if seconds > 0 {
+		content = fmt.Sprintf("%s 开启了阅后即焚：新消息被阅读 %s后自动删除", user.Username, formatSeconds(seconds))
+	}
+	notice := ws.Message{
+		ID:         primitive.NewObjectID().Hex(),
+		Type:       ws.TypeSystem,
+		SenderID:   userID,
+		ReceiverID: targetID,
+		Content:    content,
+		Timestamp:  time.Now().Unix(),
+	}
+	if err := s.saveMessage(ctx, notice); err != nil {
+		return err
+	}
+	ws.ClientMgr.SendMessageTo(targetID, notice)
+	ws.ClientMgr.SendMessageTo(userID, notice)
+	return nil
+}
+
+// StartExpiryJob 启动阅后即焚消息的后台删除任务，ctx 取消时停止。
+func (s *MessageService) StartExpiryJob(ctx context.Context) {
+	if expiryInterval <= 0 {
+		slog.InfoContext(ctx, "阅后即焚删除任务已禁用")
+		return
+	}
+	ctx = logger.With(ctx, "job", "disappear_expiry")
+	go func() {
+		ticker := time.NewTicker(expiryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.ExpireMessages(ctx)
+			}
+		}
+	}()
+}
+
+// ExpireMessages 删除所有已到期的阅后即焚消息，并向会话双方推送 recall 帧。
+func (s *MessageService) ExpireMessages(ctx context.Context) {
+	ctx, span := telemetry.Tracer().Start(ctx, "job.disappear_expiry")
+	defer span.End()
+	for {
+		messages, err := s.messageDAO.FindExpiredMessages(ctx, time.Now(), expiryBatchSize)
+		if err != nil {
+			slog.ErrorContext(ctx, "查询到期消息失败", "err", err)
+			return
+		}
+		if len(messages) == 0 {
+			return
+		}
+
+		ids := make([]primitive.ObjectID, len(messages))
+		for i, m := range messages {
+			ids[i] = m.ID
+		}
+		if _, err := s.messageDAO.DeleteMessagesByID(ctx, ids); err != nil {
+			slog.ErrorContext(ctx, "删除到期消息失败", "err", err)
+			return
+		}
+		slog.DebugContext(ctx, "已删除到期的阅后即焚消息", "count", len(ids))
+
+		// 通知会话双方的所有在线连接删除该消息
+		for _, m := range messages {
+			recall := ws.Message{
+				ID:         m.ID.Hex(),
+				Type:       ws.TypeRecall,
+				SenderID:   m.SenderID,
+				ReceiverID: m.ReceiverID,
+				Timestamp:  time.Now().Unix(),
+			}
+			ws.ClientMgr.SendMessageTo(m.SenderID, recall)
+			ws.ClientMgr.SendMessageTo(m.ReceiverID, recall)
+		}
+
+		if len(messages) < expiryBatchSize {
+			return
+		}
+	}
+}
+
+// formatSeconds 把秒数格式化为便于阅读的时长，例如 "30 秒"、"5 分钟"、"1 天"。
+func formatSeconds(seconds int64) string {
+	switch {
+	case seconds%86400 == 0:
+		return fmt.Sprintf("%d 天", seconds/86400)
+	case seconds%3600 == 0:
+		return fmt.Sprintf("%d 小时", seconds/3600)
+	case seconds%60 == 0:
+		return fmt.Sprintf("%d 分钟", seconds/60)
+	default:
+		return fmt.Sprintf("%d 秒", seconds)
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+// 邮箱绑定与验证
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"polychat/internal/dao"
+	"polychat/internal/model"
+	"polychat/internal/validation"
+	"polychat/pkg/mailer"
+	"polychat/pkg/util"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrEmailNotSet        = errors.New("未绑定邮箱")
+	ErrEmailVerified      = errors.New("邮箱已验证")
+	ErrInvalidVerifyToken = errors.New("验证链接无效或已过期，请重新发送")
+)
+
+// EmailService 邮箱服务
+type EmailService struct{}
+
+// SetEmail 绑定或修改邮箱，并向新邮箱发送验证链接。新邮箱验证之前不会用于重置密码和通知。
+func (s *EmailService) SetEmail(ctx context.Context, userID uint, email string) error {
+	email, err := validation.Email(email)
+	if err != nil {
+		return validation.Field("email", err.Error())
+	}
+	user, err := dao.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.Email == email && user.EmailVerifiedAt != nil {
+		return ErrEmailVerified
+	}
+	if err := dao.UpdateUserEmail(ctx, userID, email); err != nil {
+		return err
+	}
+	user.Email = email
+	return sendVerification(ctx, user)
+}
+
+// ResendVerification 重新发送验证链接
+func (s *EmailService) ResendVerification(ctx context.Context, userID uint) error {
+	user, err := dao.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.Email == "" {
+		return ErrEmailNotSet
+	}
+	if user.EmailVerifiedAt != nil {
+		return ErrEmailVerified
+	}
+	return sendVerification(ctx, user)
+}
+
+// Verify 使用邮件中的验证 token 完成邮箱验证，重复验证同一个邮箱视为成功
+func (s *EmailService) Verify(ctx context.Context, token string) error {
+	claims, err := util.ParseEmailVerifyToken(token)
+	if err != nil {
+		return ErrInvalidVerifyToken
+	}
+	user, err := dao.GetUserByID(ctx, claims.UserID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ErrInvalidVerifyToken
+		}
+		return err
+	}
+	if user.Email != claims.Email {
+		// 验证链接发出之后修改了邮箱
+		return ErrInvalidVerifyToken
+	}
+	if user.EmailVerifiedAt != nil {
+		return nil
+	}
+	verified, err := dao.MarkUserEmailVerified(ctx, user.ID, claims.Email, time.Now())
+	if err != nil {
+		return err
+	}
+	if !verified {
+		return ErrInvalidVerifyToken
+	}
+	slog.InfoContext(ctx, "邮箱验证成功", "user_id", user.ID)
+	return nil
+}
+
+// sendVerification 向用户当前的邮箱发送验证链接
+func sendVerification(ctx context.Context, user *model.User) error {
+	token, err := util.GenerateEmailVerifyToken(user.ID, user.Email)
+	if err != nil {
+		return err
+	}
+	sendMailAsync(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "验证你的 polychat 邮箱",
+		Body: fmt.Sprintf("%s，你好：\n\n请在 24 小时内打开下面的链接验证你的邮箱：\n\n%s/?verify_token=%s\n\n"+
+			"验证之后，这个邮箱可以用于找回密码。如果这不是你本人的操作，请忽略这封邮件。\n",
+			user.Username, publicURL, url.QueryEscape(token)),
+	})
+	return nil
+}
+
+// sendMailAsync 在后台发送邮件，不随请求结束而取消，发送失败只记录日志
+func sendMailAsync(ctx context.Context, msg mailer.Message) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mailTimeout)
+	go func() {
+		defer cancel()
+		if err := mailer.Default.Send(ctx, msg); err != nil {
+			slog.ErrorContext(ctx, "发送邮件失败", "subject", msg.Subject, "err", err)
+		}
+	}()
+}
@@ -0,0 +1,264 @@
+// Package service 提供业务逻辑层。
+// 本文件负责聊天记录导出：把 MongoDB 中的会话按 JSON / HTML / 纯文本格式
+// 流式写入 zip 压缩包，用于用户备份和数据可携带（data portability）请求。
+//
+// 压缩包结构：
+//
+//	manifest.json                       导出清单（格式版本、导出者、会话列表）
+//	conversations/<peer_id>.<ext>       每个会话一个文件
+//
+// 消息通过 MongoDB 游标逐条读取、逐条写入 zip，不会把整个会话加载到内存中。
+package service
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"time"
+
+	"polychat/internal/dao"
+	"polychat/internal/model"
+)
+
+// ExportFormat 导出文件格式
+type ExportFormat string
+
+const (
+	ExportJSON ExportFormat = "json" // JSON，可被导入功能读取
+	ExportHTML ExportFormat = "html" // HTML，便于在浏览器中阅读
+	ExportText ExportFormat = "txt"  // 纯文本
+)
+
+// ExportVersion 导出格式版本号，导入时据此判断是否兼容
+const ExportVersion = 1
+
+// ErrExportFormat 不支持的导出格式
+var ErrExportFormat = errors.New("不支持的导出格式，可选值: json, html, txt")
+
+// ErrExportEmpty 没有可导出的会话
+var ErrExportEmpty = errors.New("没有可导出的聊天记录")
+
+// ParseExportFormat 解析导出格式参数，空字符串默认为 JSON。
+func ParseExportFormat(s string) (ExportFormat, error) {
+	switch ExportFormat(s) {
+	case "", ExportJSON:
+		return ExportJSON, nil
+	case ExportHTML:
+		return ExportHTML, nil
+	case ExportText:
+		return ExportText, nil
+	}
+	return "", ErrExportFormat
+}
+
+// ExportUser 导出文件中的用户信息
+type ExportUser struct {
+	ID       uint   `json:"id"`
+	Username string `json:"username"`
+	// Note 导出者给该用户设置的好友备注（仅会话对象有）
+	Note string `json:"note,omitempty"`
+}
+
+// ExportedMessage 导出文件中的一条消息
+type ExportedMessage struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	SenderID   uint   `json:"sender_id"`
+	SenderName string `json:"sender_name"`
+	ReceiverID uint   `json:"receiver_id"`
+	Content    string `json:"content"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+// ExportConversation 导出清单中的一个会话条目
+type ExportConversation struct {
+	Peer         ExportUser `json:"peer"`
+	File         string     `json:"file"`
+	MessageCount int        `json:"message_count"`
+}
+
+// ExportManifest 导出清单（manifest.json）
+type ExportManifest struct {
+	Version       int                  `json:"version"`
+	Format        ExportFormat         `json:"format"`
+	ExportedAt    int64                `json:"exported_at"`
+	Owner         ExportUser           `json:"owner"`
+	Conversations []ExportConversation `json:"conversations"`
+}
+
+// ExportService 聊天记录导出服务
+type ExportService struct {
+	messageDAO dao.MessageDAO
+}
+
+// ExportFileName 生成导出压缩包的默认文件名。
+func ExportFileName(userID uint, targetID uint) string {
+	date := time.Now().Format("20060102")
+	if targetID != 0 {
+		return fmt.Sprintf("polychat_export_%d_%d_%s.zip", userID, targetID, date)
+	}
+	return fmt.Sprintf("polychat_export_%d_%s.zip", userID, date)
+}
+
+// Peers 返回本次导出涉及的会话对象列表。
+// targetID 为 0 时返回该用户参与的全部会话，否则只返回 targetID。
+// API 层在开始写响应之前调用它，以便在出错时还能返回正常的 JSON 错误。
+func (s *ExportService) Peers(ctx context.Context, userID, targetID uint) ([]uint, error) {
+	if targetID != 0 {
+		return []uint{targetID}, nil
+	}
+	peers, err := s.messageDAO.ListConversationPeers(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(peers) == 0 {
+		return nil, ErrExportEmpty
+	}
+	return peers, nil
+}
+
+// Export 将 userID 与 peers 中每个用户之间的聊天记录写入 w（zip 格式）。
+// 用户名取自 MySQL users 表，会话对象的备注取自导出者的好友关系。
+func (s *ExportService) Export(ctx context.Context, w io.Writer, userID uint, peers []uint, format ExportFormat) error {
+	zw := zip.NewWriter(w)
+
+	manifest := ExportManifest{
+		Version:    ExportVersion,
+		Format:     format,
+		ExportedAt: time.Now().Unix(),
+		Owner:      exportUser(ctx, userID, 0),
+	}
+
+	for _, peerID := range peers {
+		peer := exportUser(ctx, peerID, userID)
+		file := fmt.Sprintf("conversations/%d.%s", peerID, format)
+
+		fw, err := zw.Create(file)
+		if err != nil {
+			return err
+		}
+		count, err := s.writeConversation(ctx, fw, manifest.Owner, peer, format)
+		if err != nil {
+			return fmt.Errorf("导出与用户 %d 的会话失败: %w", peerID, err)
+		}
+		manifest.Conversations = append(manifest.Conversations, ExportConversation{
+			Peer:         peer,
+			File:         file,
+			MessageCount: count,
+		})
+	}
+
+	// 清单放在最后写入，此时已知道每个会话的消息数
+	mw, err := zw.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(mw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// writeConversation 把一个会话按指定格式写入 w，返回写入的消息数。
+func (s *ExportService) writeConversation(ctx context.Context, w io.Writer, owner, peer ExportUser, format ExportFormat) (int, error) {
+	bw := bufio.NewWriter(w)
+	names := map[uint]string{owner.ID: owner.Username, peer.ID: peer.Username}
+	peerTitle := peer.Username
+	if peer.Note != "" {
+		peerTitle = fmt.Sprintf("%s (%s)", peer.Note, peer.Username)
+	}
+
+	// 文件头
+	switch format {
+	case ExportJSON:
+		ownerJSON, _ := json.Marshal(owner)
+		peerJSON, _ := json.Marshal(peer)
+		fmt.Fprintf(bw, "{\n  \"version\": %d,\n  \"owner\": %s,\n  \"peer\": %s,\n  \"messages\": [", ExportVersion, ownerJSON, peerJSON)
+	case ExportHTML:
+		fmt.Fprintf(bw, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>与 %s 的聊天记录</title>\n", html.EscapeString(peerTitle))
+		bw.WriteString("<style>body{font-family:sans-serif;max-width:800px;margin:auto}" +
+			".msg{margin:6px 0}.meta{color:#888;font-size:12px}.self .name{color:#2563eb}" +
+			".content{white-space:pre-wrap}</style>\n</head>\n<body>\n")
+		fmt.Fprintf(bw, "<h1>与 %s 的聊天记录</h1>\n", html.EscapeString(peerTitle))
+	case ExportText:
+		fmt.Fprintf(bw, "与 %s 的聊天记录\n导出时间: %s\n\n", peerTitle, time.Now().Format("2006-01-02 15:04:05"))
+	}
+
+	count := 0
+	err := s.messageDAO.IterateConversation(ctx, owner.ID, peer.ID, func(m *model.ChatMessage) error {
+		sender := names[m.SenderID]
+		ts := time.Unix(m.Timestamp, 0).Format("2006-01-02 15:04:05")
+
+		switch format {
+		case ExportJSON:
+			data, err := json.Marshal(ExportedMessage{
+				ID:         m.ID.Hex(),
+				Type:       m.Type,
+				SenderID:   m.SenderID,
+				SenderName: sender,
+				ReceiverID: m.ReceiverID,
+				Content:    m.Content,
+				Timestamp:  m.Timestamp,
+			})
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				bw.WriteString(",")
+			}
+			bw.WriteString("\n    ")
+			bw.Write(data)
+		case ExportHTML:
+			class := "msg"
+			if m.SenderID == owner.ID {
+				class += " self"
+			}
+			fmt.Fprintf(bw, "<div class=\"%s\"><div class=\"meta\"><span class=\"name\">%s</span> %s</div><div class=\"content\">%s</div></div>\n",
+				class, html.EscapeString(sender), ts, html.EscapeString(m.Content))
+		case ExportText:
+			fmt.Fprintf(bw, "[%s] %s: %s\n", ts, sender, m.Content)
+		}
+		count++
+
+		// 定期刷新缓冲区，把数据交给 zip 压缩，避免缓冲区无限增长
+		if bw.Buffered() >= 32*1024 {
+			return bw.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+
+	// 文件尾
+	switch format {
+	case ExportJSON:
+		bw.WriteString("\n  ]\n}\n")
+	case ExportHTML:
+		bw.WriteString("</body>\n</html>\n")
+	}
+	return count, bw.Flush()
+}
+
+// exportUser 构造导出文件中的用户信息。
+// ownerID 非 0 时，附带 ownerID 给该用户设置的好友备注。
+// 用户已被删除时用户名以 "用户<ID>" 代替，保证导出不会因为历史数据缺失而失败。
+func exportUser(ctx context.Context, userID, ownerID uint) ExportUser {
+	u := ExportUser{ID: userID, Username: fmt.Sprintf("用户%d", userID)}
+	if user, err := dao.GetUserByID(ctx, userID); err == nil {
+		u.Username = user.Username
+	}
+	if ownerID != 0 {
+		if relation, err := dao.GetRelationByPair(ctx, ownerID, userID); err == nil {
+			u.Note = relation.Note
+		}
+	}
+	return u
+}
@@ -0,0 +1,276 @@
+// Package service 提供业务逻辑层。
+// 本文件负责聊天记录导入：读取 ExportService 生成的 JSON 格式压缩包，
+// 把消息恢复到 MongoDB messages 集合，用于在不同环境之间迁移用户以及误删后的恢复。
+//
+// 导入规则：
+//   - 只支持 format=json 的导出包，HTML / 纯文本仅供阅读，无法还原
+//   - 用户ID按映射表转换：优先使用显式指定的 旧ID=新ID，否则按导出时的用户名在当前环境中查找
+//   - 消息保留导出时的ID，已存在的消息自动跳过，因此同一个压缩包重复导入是安全的
+//   - 会话文件按 JSON 流式解析，批量写入，内存占用与会话长度无关
+package service
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"polychat/internal/dao"
+	"polychat/internal/model"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// importBatchSize 每批写入 MongoDB 的消息条数
+const importBatchSize = 500
+
+// ErrImportFormat 压缩包不是可导入的格式
+var ErrImportFormat = errors.New("只能导入 json 格式的导出文件")
+
+// ImportOptions 导入选项
+type ImportOptions struct {
+	// UserMap 显式指定的用户ID映射（导出环境ID -> 当前环境ID），优先于按用户名匹配
+	UserMap map[uint]uint
+	// DryRun 为 true 时只解析和校验，不写入数据库
+	DryRun bool
+	// Progress 进度回调，每写入一批消息或处理完一个会话时调用，可为 nil
+	Progress func(ImportProgress)
+}
+
+// ImportProgress 导入进度
+type ImportProgress struct {
+	// File 当前处理的会话文件
+	File string `json:"file"`
+	// DoneConversations / TotalConversations 已处理 / 总会话数
+	DoneConversations  int `json:"done_conversations"`
+	TotalConversations int `json:"total_conversations"`
+	// Inserted 已写入的消息数
+	Inserted int `json:"inserted"`
+	// Duplicates 因已存在被跳过的消息数
+	Duplicates int `json:"duplicates"`
+	// Skipped 无法导入的消息数（发送方/接收方不属于该会话等）
+	Skipped int `json:"skipped"`
+}
+
+// ImportResult 导入结果
+type ImportResult struct {
+	ImportProgress
+	// FailedConversations 整体导入失败的会话文件及原因（例如用户无法映射）
+	FailedConversations map[string]string `json:"failed_conversations,omitempty"`
+}
+
+// ImportService 聊天记录导入服务
+type ImportService struct {
+	messageDAO dao.MessageDAO
+}
+
+// Import 从 r 中读取导出压缩包并恢复消息。
+// size 为压缩包的字节数（zip 需要随机访问中央目录）。
+// 单个会话失败不会影响其他会话，失败原因记录在 ImportResult.FailedConversations 中。
+func (s *ImportService) Import(ctx context.Context, r io.ReaderAt, size int64, opts ImportOptions) (*ImportResult, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("读取压缩包失败: %w", err)
+	}
+
+	manifest, err := readManifest(zr)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Format != ExportJSON {
+		return nil, ErrImportFormat
+	}
+	if manifest.Version > ExportVersion {
+		return nil, fmt.Errorf("导出文件版本 %d 高于当前支持的版本 %d", manifest.Version, ExportVersion)
+	}
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	result := &ImportResult{FailedConversations: map[string]string{}}
+	result.TotalConversations = len(manifest.Conversations)
+	for _, conv := range manifest.Conversations {
+		result.File = conv.File
+		f, ok := files[conv.File]
+		if !ok {
+			result.FailedConversations[conv.File] = "压缩包中缺少该文件"
+		} else if err := s.importConversation(ctx, f, opts, result); err != nil {
+			result.FailedConversations[conv.File] = err.Error()
+		}
+		result.DoneConversations++
+		if opts.Progress != nil {
+			opts.Progress(result.ImportProgress)
+		}
+	}
+	return result, nil
+}
+
+// readManifest 读取并解析压缩包中的 manifest.json。
+func readManifest(zr *zip.Reader) (*ExportManifest, error) {
+	f, err := zr.Open("manifest.json")
+	if err != nil {
+		return nil, errors.New("压缩包中缺少 manifest.json，不是有效的导出文件")
+	}
+	defer f.Close()
+
+	var manifest ExportManifest
+	if err := json.NewDecoder(f).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("解析 manifest.json 失败: %w", err)
+	}
+	return &manifest, nil
+}
+
+// importConversation 流式解析一个会话文件并分批写入。
+// 文件结构见 ExportService.writeConversation：owner 和 peer 字段在 messages 数组之前。
+func (s *ImportService) importConversation(ctx context.Context, f *zip.File, opts ImportOptions, result *ImportResult) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	dec := json.NewDecoder(rc)
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+
+	var owner, peer *ExportUser
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+
+		switch key {
+		case "owner", "peer":
+			var u ExportUser
+			if err := dec.Decode(&u); err != nil {
+				return err
+			}
+			if key == "owner" {
+				owner = &u
+			} else {
+				peer = &u
+			}
+		case "messages":
+			if owner == nil || peer == nil {
+				return errors.New("会话文件格式错误: messages 之前缺少 owner/peer")
+			}
+			idMap, err := resolveImportUsers(ctx, opts.UserMap, *owner, *peer)
+			if err != nil {
+				return err
+			}
+			if err := s.importMessages(ctx, dec, idMap, opts, result); err != nil {
+				return err
+			}
+		default:
+			// 跳过未知字段（例如 version），保持对新版本导出文件的兼容
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// importMessages 逐条解码 messages 数组，按批写入 MongoDB。
+func (s *ImportService) importMessages(ctx context.Context, dec *json.Decoder, idMap map[uint]uint, opts ImportOptions, result *ImportResult) error {
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+
+	batch := make([]model.ChatMessage, 0, importBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if !opts.DryRun {
+			inserted, duplicates, err := s.messageDAO.InsertMessagesSkipDuplicates(ctx, batch)
+			if err != nil {
+				return err
+			}
+			result.Inserted += inserted
+			result.Duplicates += duplicates
+		}
+		batch = batch[:0]
+		if opts.Progress != nil {
+			opts.Progress(result.ImportProgress)
+		}
+		return nil
+	}
+
+	for dec.More() {
+		var em ExportedMessage
+		if err := dec.Decode(&em); err != nil {
+			return err
+		}
+		senderID, ok1 := idMap[em.SenderID]
+		receiverID, ok2 := idMap[em.ReceiverID]
+		if !ok1 || !ok2 {
+			result.Skipped++
+			continue
+		}
+
+		// 保留原消息ID用于去重；ID 损坏时生成新ID（这类消息无法去重）
+		id, err := primitive.ObjectIDFromHex(em.ID)
+		if err != nil {
+			id = primitive.NewObjectID()
+		}
+		batch = append(batch, model.ChatMessage{
+			ID:         id,
+			Type:       em.Type,
+			SenderID:   senderID,
+			ReceiverID: receiverID,
+			Content:    em.Content,
+			Timestamp:  em.Timestamp,
+		})
+		if len(batch) >= importBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	return expectDelim(dec, ']')
+}
+
+// resolveImportUsers 把会话双方在导出环境中的ID映射为当前环境中的ID。
+// 优先使用显式映射，否则按用户名查找。
+func resolveImportUsers(ctx context.Context, userMap map[uint]uint, users ...ExportUser) (map[uint]uint, error) {
+	idMap := make(map[uint]uint, len(users))
+	for _, u := range users {
+		if newID, ok := userMap[u.ID]; ok {
+			if _, err := dao.GetUserByID(ctx, newID); err != nil {
+				return nil, fmt.Errorf("映射的目标用户 %d 不存在", newID)
+			}
+			idMap[u.ID] = newID
+			continue
+		}
+		user, err := dao.GetUserByUsername(ctx, u.Username)
+		if err != nil {
+			return nil, fmt.Errorf("无法映射用户 %d(%s): 当前环境中不存在该用户名", u.ID, u.Username)
+		}
+		idMap[u.ID] = user.ID
+	}
+	return idMap, nil
+}
+
+// expectDelim 读取下一个 JSON token 并检查是否为指定的分隔符。
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != want {
+		return fmt.Errorf("会话文件格式错误: 期望 %q", want)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package service
+
+// 登录防暴力破解：按用户名和按IP分别统计连续失败次数，
+// 失败次数增加时逐步延长允许再次尝试的间隔，达到上限后临时锁定并记录审计事件。
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"polychat/internal/dao"
+	"polychat/internal/model"
+	"polychat/pkg/config"
+	"polychat/pkg/util"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// loginMaxFailuresPerUser 同一用户名连续失败多少次后锁定
+	loginMaxFailuresPerUser = config.GetInt("POLYCHAT_LOGIN_MAX_FAILURES", 5)
+	// loginMaxFailuresPerIP 同一IP连续失败多少次后锁定，IP 后面可能有多个用户，因此比按用户名宽松
+	loginMaxFailuresPerIP = config.GetInt("POLYCHAT_LOGIN_MAX_FAILURES_PER_IP", 20)
+	// loginFailureWindow 统计窗口，距上次失败超过该时长后重新计数
+	loginFailureWindow = config.GetDuration("POLYCHAT_LOGIN_FAILURE_WINDOW", 15*time.Minute)
+	// loginLockout 锁定时长
+	loginLockout = config.GetDuration("POLYCHAT_LOGIN_LOCKOUT", 15*time.Minute)
+)
+
+const (
+	// loginDelayBase 第二次失败之后要求的最短重试间隔，之后每失败一次翻倍
+	loginDelayBase = time.Second
+	// loginDelayMax 重试间隔的上限
+	loginDelayMax = 30 * time.Second
+)
+
+// ErrInvalidCredentials 用户名或密码错误。两种情况返回同一个错误，避免暴露用户名是否存在。
+var ErrInvalidCredentials = errors.New("用户名或密码错误")
+
+// LoginThrottledError 登录尝试过于频繁或已被临时锁定
+type LoginThrottledError struct {
+	RetryAfter time.Duration // 距离允许再次尝试的时间
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("登录尝试过于频繁，请 %d 秒后再试", int(e.RetryAfter.Round(time.Second)/time.Second))
+}
+
+// dummyPasswordHash 用户名不存在时参与比对的哈希，使其耗时与用户存在时一致。
+// 第一次用到时才生成，避免拖慢不需要登录的命令行工具的启动。
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := util.HashPassword("polychat-dummy-password")
+	return hash
+})
+
+// loginSubject 一个登录失败的统计对象
+type loginSubject struct {
+	scope       string
+	subject     string
+	maxFailures int
+}
+
+// loginSubjects 返回本次登录涉及的统计对象：用户名（不区分大小写）和客户端IP
+func loginSubjects(username, ip string) []loginSubject {
+	subjects := []loginSubject{{model.LoginScopeUser, strings.ToLower(username), loginMaxFailuresPerUser}}
+	if ip != "" {
+		subjects = append(subjects, loginSubject{model.LoginScopeIP, ip, loginMaxFailuresPerIP})
+	}
+	return subjects
+}
+
+// loginDelay 连续失败 count 次之后要求的最短重试间隔
+func loginDelay(count int) time.Duration {
+	if count < 2 {
+		return 0
+	}
+	delay := loginDelayBase
+	for i := 2; i < count && delay < loginDelayMax; i++ {
+		delay *= 2
+	}
+	return min(delay, loginDelayMax)
+}
+
+// checkLoginAllowed 检查是否允许本次登录尝试，不允许时返回 *LoginThrottledError。
+// 查询失败时放行，避免数据库抖动导致所有用户无法登录。
+func checkLoginAllowed(ctx context.Context, subjects []loginSubject, now time.Time) error {
+	var wait time.Duration
+	for _, s := range subjects {
+		f, err := dao.GetLoginFailure(ctx, s.scope, s.subject)
+		if err != nil {
+			if err != gorm.ErrRecordNotFound {
+				slog.ErrorContext(ctx, "查询登录失败计数失败", "scope", s.scope, "err", err)
+			}
+			continue
+		}
+		if f.LockedUntil != nil && f.LockedUntil.After(now) {
+			wait = max(wait, f.LockedUntil.Sub(now))
+			continue
+		}
+		if now.Sub(f.LastFailedAt) >= loginFailureWindow {
+			continue
+		}
+		if next := f.LastFailedAt.Add(loginDelay(f.Count)); next.After(now) {
+			wait = max(wait, next.Sub(now))
+		}
+	}
+	if wait > 0 {
+		return &LoginThrottledError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// recordLoginFailure 记录一次登录失败，失败次数达到上限时锁定并写入审计日志。
+// userID 为用户名对应的用户，用户名不存在时为 0。
+func recordLoginFailure(ctx context.Context, subjects []loginSubject, userID uint, ip string, now time.Time) {
+	for _, s := range subjects {
+		locked := false
+		f, err := dao.UpdateLoginFailure(ctx, s.scope, s.subject, func(f *model.LoginFailure) {
+			// 锁定已过期或距上次失败超过统计窗口，重新计数
+			if (f.LockedUntil != nil && !f.LockedUntil.After(now)) || now.Sub(f.LastFailedAt) >= loginFailureWindow {
+				f.Count = 0
+				f.LockedUntil = nil
+			}
+			f.Count++
+			f.LastFailedAt = now
+			if f.Count >= s.maxFailures && f.LockedUntil == nil {
+				until := now.Add(loginLockout)
+				f.LockedUntil = &until
+				locked = true
+			}
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "记录登录失败计数失败", "scope", s.scope, "err", err)
+			continue
+		}
+		if !locked {
+			continue
+		}
+
+		entry := &model.AuditLog{Action: model.AuditLoginLockout, IP: ip}
+		if s.scope == model.LoginScopeUser {
+			entry.UserID = userID
+			entry.Detail = fmt.Sprintf("用户名 %s 连续登录失败 %d 次，锁定至 %s", s.subject, f.Count, f.LockedUntil.Format(time.RFC3339))
+		} else {
+			entry.Detail = fmt.Sprintf("IP %s 连续登录失败 %d 次，锁定至 %s", s.subject, f.Count, f.LockedUntil.Format(time.RFC3339))
+		}
+		recordAudit(ctx, entry)
+	}
+}
+
+// clearLoginFailures 登录成功后清除用户名的失败计数。
+// IP 的计数不清除，否则攻击者可以用自己的账号登录来重置对其他账号的猜测次数。
+func clearLoginFailures(ctx context.Context, username string) {
+	if err := dao.DeleteLoginFailure(ctx, model.LoginScopeUser, strings.ToLower(username)); err != nil {
+		slog.ErrorContext(ctx, "清除登录失败计数失败", "err", err)
+	}
+}
+
+// AccountSuspendedError 账号被停用或封禁，不能登录
+type AccountSuspendedError struct {
+	Until  *time.Time // 封禁截止时间，为空表示停用，需要管理员恢复
+	Reason string
+}
+
+func (e *AccountSuspendedError) Error() string {
+	msg := "账号已被停用"
+	if e.Until != nil {
+		msg = "账号已被封禁至 " + e.Until.Format("2006-01-02 15:04")
+	}
+	if e.Reason != "" {
+		msg += "，原因：" + e.Reason
+	}
+	return msg
+}
+
+// checkSuspended 账号被停用或封禁时返回 *AccountSuspendedError
+func checkSuspended(user *model.User, now time.Time) error {
+	if !user.Suspended(now) {
+		return nil
+	}
+	err := &AccountSuspendedError{Reason: user.SuspendReason}
+	if !user.Disabled {
+		err.Until = user.SuspendedUntil
+	}
+	return err
+}
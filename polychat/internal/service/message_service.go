@@ -1,33 +1,52 @@
 // Package service 提供业务逻辑层，处于 API 处理器和 DAO 数据访问层之间。
-// 本文件负责聊天消息的业务逻辑，包括消息持久化和历史记录查询。
+// 本文件负责聊天消息的业务逻辑，包括消息持久化、历史记录查询、全文搜索和阅后即焚。
 // 业务逻辑层负责参数校验、数据转换等，将 DAO 层的原始数据操作封装为业务语义明确的方法。
 package service
 
 import (
-	"fmt"
+	"context"
+	"encoding/base64"
+	"errors"
+	"html"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
 
 	"polychat/internal/dao"
 	"polychat/internal/model"
 	"polychat/internal/ws"
+	"polychat/pkg/metrics"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // MessageService 聊天消息业务服务。
-// 提供消息持久化和历史记录查询功能。
+// 提供消息持久化、历史记录查询、全文搜索和阅后即焚功能。
 type MessageService struct {
-	messageDAO dao.MessageDAO
+	messageDAO      dao.MessageDAO
+	relationService RelationService
 }
 
-// SaveMessage 将一条 WebSocket 消息持久化到 MongoDB。
-// 仅保存 type 为 "chat" 的消息，心跳等其他类型不做持久化。
+// SaveMessage 将一条客户端发来的 WebSocket 消息持久化到 MongoDB。
+// 仅保存 type 为 "chat" 的消息，其他类型不做持久化；
+// 系统通知由服务器生成，通过 saveMessage 保存，不接受客户端发来的 system 消息。
 //
-// 参数 msg 是从 WebSocket 接收到的消息（已由服务器设置好 SenderID 和 Timestamp）。
+// 参数 msg 是从 WebSocket 接收到的消息（已由服务器设置好 SenderID 和 Timestamp，
+// 并经过 PrepareMessage 处理）。
 // 返回错误信息（如果有）。
-func (s *MessageService) SaveMessage(msg ws.Message) error {
-	// 仅持久化聊天类型消息
+func (s *MessageService) SaveMessage(ctx context.Context, msg ws.Message) error {
 	if msg.Type != ws.TypeChat {
 		return nil
 	}
+	return s.saveMessage(ctx, msg)
+}
 
+// saveMessage 将消息转换为 MongoDB 文档并保存，不检查消息类型
+func (s *MessageService) saveMessage(ctx context.Context, msg ws.Message) error {
 	// 将 ws.Message 转换为 MongoDB 文档模型
 	chatMsg := &model.ChatMessage{
 		Type:       msg.Type,
@@ -36,16 +55,85 @@ func (s *MessageService) SaveMessage(msg ws.Message) error {
 		Content:    msg.Content,
 		Timestamp:  msg.Timestamp,
 	}
+	// 使用服务器预先分配的消息ID，保证客户端收到的ID与数据库一致
+	if id, err := primitive.ObjectIDFromHex(msg.ID); err == nil {
+		chatMsg.ID = id
+	}
+	// 阅后即焚消息：未读状态下最多保留 disappearUnreadTTL
+	if msg.ExpiresIn > 0 {
+		expireAt := time.Unix(msg.Timestamp, 0).Add(disappearUnreadTTL)
+		chatMsg.ExpiresIn = msg.ExpiresIn
+		chatMsg.ExpireAt = &expireAt
+	}
 
-	if err := s.messageDAO.SaveMessage(chatMsg); err != nil {
-		fmt.Printf("消息持久化失败: sender=%d, receiver=%d, err=%v\n",
-			msg.SenderID, msg.ReceiverID, err)
+	if err := s.messageDAO.SaveMessage(ctx, chatMsg); err != nil {
+		slog.ErrorContext(ctx, "消息持久化失败",
+			"msg_id", msg.ID, "type", msg.Type, "sender_id", msg.SenderID, "receiver_id", msg.ReceiverID, "err", err)
+		metrics.CountMessage(msg.Type, metrics.MessageFailed)
 		return err
 	}
+	metrics.CountMessage(msg.Type, metrics.MessagePersisted)
+
+	return nil
+}
+
+// pendingWrites 跟踪尚未完成的异步持久化，服务关闭时等待它们完成。
+// draining 为 true 表示已经开始等待，之后不再接受新的异步写入：
+// WaitGroup 计数为 0 时调用 Wait 之后不能再 Add。两者都由 pendingMu 保护。
+var (
+	pendingMu     sync.Mutex
+	pendingWrites sync.WaitGroup
+	draining      bool
+)
+
+// ErrShuttingDown 服务正在关闭，不再接受新的异步持久化
+var ErrShuttingDown = errors.New("服务正在关闭")
 
+// SaveMessageAsync 在后台协程中持久化消息，不阻塞消息转发。
+// 持久化失败只记录日志，消息仍然会被转发给在线用户。
+// 写入不会因为 ctx 取消（例如连接断开）而中止，ctx 只用于传递日志字段。
+// 服务开始关闭（已调用 WaitPendingWrites）后返回 ErrShuttingDown，消息没有保存。
+func (s *MessageService) SaveMessageAsync(ctx context.Context, msg ws.Message) error {
+	ctx = context.WithoutCancel(ctx)
+	pendingMu.Lock()
+	if draining {
+		pendingMu.Unlock()
+		slog.WarnContext(ctx, "服务正在关闭，消息未保存", "msg_id", msg.ID, "sender_id", msg.SenderID)
+		metrics.CountMessage(msg.Type, metrics.MessageFailed)
+		return ErrShuttingDown
+	}
+	pendingWrites.Add(1)
+	pendingMu.Unlock()
+	go func() {
+		defer pendingWrites.Done()
+		if err := s.SaveMessage(ctx, msg); err == nil {
+			slog.DebugContext(ctx, "消息持久化成功",
+				"msg_id", msg.ID, "sender_id", msg.SenderID, "receiver_id", msg.ReceiverID)
+		}
+	}()
 	return nil
 }
 
+// WaitPendingWrites 停止接受新的异步持久化，并等待已有的全部完成，ctx 到期时返回 ctx.Err()。
+// 服务关闭时在断开 MongoDB 之前调用，避免丢失最后一批消息。
+func WaitPendingWrites(ctx context.Context) error {
+	pendingMu.Lock()
+	draining = true
+	pendingMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		pendingWrites.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // GetHistory 获取两个用户之间的聊天历史记录（分页）。
 // 返回双向聊天记录（A发给B + B发给A），按时间倒序排列。
 //
@@ -59,7 +147,7 @@ func (s *MessageService) SaveMessage(msg ws.Message) error {
 //   - []model.ChatMessage: 消息列表
 //   - int64:               总消息数
 //   - error:               错误信息
-func (s *MessageService) GetHistory(userID, targetID uint, page, pageSize int) ([]model.ChatMessage, int64, error) {
+func (s *MessageService) GetHistory(ctx context.Context, userID, targetID uint, page, pageSize int) ([]model.ChatMessage, int64, error) {
 	// 参数校验与默认值
 	if page < 1 {
 		page = 1
@@ -71,5 +159,198 @@ func (s *MessageService) GetHistory(userID, targetID uint, page, pageSize int) (
 		pageSize = 100
 	}
 
-	return s.messageDAO.GetMessageHistory(userID, targetID, page, pageSize)
+	return s.messageDAO.GetMessageHistory(ctx, userID, targetID, page, pageSize)
+}
+
+// 搜索相关的默认参数
+const (
+	searchDefaultLimit = 20 // 默认每次返回条数
+	searchMaxLimit     = 50 // 每次最多返回条数
+	snippetContext     = 20 // 摘要中关键词前后各保留的字符数（按 rune 计）
+)
+
+// 搜索参数错误，API 层据此返回 400
+var (
+	ErrSearchKeywordEmpty = errors.New("搜索关键词不能为空")
+	ErrSearchTimeRange    = errors.New("起始时间不能晚于结束时间")
+	ErrSearchCursor       = errors.New("cursor 格式错误")
+)
+
+// SearchHit 一条搜索命中结果。
+// Snippet 是截取自消息内容的片段，命中的关键词用 <mark></mark> 包裹，
+// 其余部分已做 HTML 转义，前端可以直接作为 HTML 渲染。
+type SearchHit struct {
+	model.ChatMessage
+	Snippet string `json:"snippet"`
+}
+
+// SearchMessages 在当前用户参与的会话中按关键词搜索聊天记录。
+//
+// 参数说明：
+//   - userID:    当前登录用户的ID
+//   - keyword:   搜索关键词，不能为空
+//   - targetID:  可选，只搜索与该用户的会话，0 表示搜索全部会话
+//   - startTime: 可选，起始时间（Unix 秒），0 表示不限
+//   - endTime:   可选，结束时间（Unix 秒），0 表示不限
+//   - cursor:    可选，上一页返回的 next_cursor，为空表示第一页
+//   - limit:     每页条数（默认 20，最大 50）
+//
+// 返回值：
+//   - []SearchHit: 命中结果（按时间倒序）
+//   - string:      下一页游标，为空表示没有更多结果
+//   - error:       错误信息
+func (s *MessageService) SearchMessages(ctx context.Context, userID uint, keyword string, targetID uint,
+	startTime, endTime int64, cursor string, limit int) ([]SearchHit, string, error) {
+	keyword = strings.TrimSpace(keyword)
+	if keyword == "" {
+		return nil, "", ErrSearchKeywordEmpty
+	}
+	if startTime > 0 && endTime > 0 && startTime > endTime {
+		return nil, "", ErrSearchTimeRange
+	}
+	if limit < 1 {
+		limit = searchDefaultLimit
+	}
+	if limit > searchMaxLimit {
+		limit = searchMaxLimit
+	}
+
+	query := dao.MessageSearchQuery{
+		UserID:    userID,
+		Keyword:   keyword,
+		TargetID:  targetID,
+		StartTime: startTime,
+		EndTime:   endTime,
+		// 多取一条用于判断是否还有下一页
+		Limit: limit + 1,
+	}
+	if cursor != "" {
+		ts, id, err := decodeSearchCursor(cursor)
+		if err != nil {
+			return nil, "", ErrSearchCursor
+		}
+		query.AfterTimestamp = ts
+		query.AfterID = id
+	}
+
+	messages, err := s.messageDAO.SearchMessages(ctx, query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	nextCursor := ""
+	if len(messages) > limit {
+		messages = messages[:limit]
+		last := messages[len(messages)-1]
+		nextCursor = encodeSearchCursor(last.Timestamp, last.ID)
+	}
+
+	terms := searchTerms(keyword)
+	hits := make([]SearchHit, 0, len(messages))
+	for _, m := range messages {
+		hits = append(hits, SearchHit{
+			ChatMessage: m,
+			Snippet:     buildSnippet(m.Content, terms),
+		})
+	}
+	return hits, nextCursor, nil
+}
+
+// encodeSearchCursor 将分页位置编码为不透明的游标字符串。
+// 格式为 base64url("<timestamp>:<ObjectID hex>")。
+func encodeSearchCursor(ts int64, id primitive.ObjectID) string {
+	raw := strconv.FormatInt(ts, 10) + ":" + id.Hex()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeSearchCursor 解析 encodeSearchCursor 生成的游标。
+func decodeSearchCursor(cursor string) (int64, primitive.ObjectID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, primitive.NilObjectID, err
+	}
+	tsStr, idStr, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return 0, primitive.NilObjectID, errors.New("invalid cursor")
+	}
+	ts, err := strconv.ParseInt(tsStr, 10, 64)
+	if err != nil {
+		return 0, primitive.NilObjectID, err
+	}
+	id, err := primitive.ObjectIDFromHex(idStr)
+	if err != nil {
+		return 0, primitive.NilObjectID, err
+	}
+	return ts, id, nil
+}
+
+// searchTerms 从搜索关键词中提取需要高亮的词。
+// 与 MongoDB $text 语法保持一致：去掉引号，忽略以 "-" 开头的排除词。
+func searchTerms(keyword string) []string {
+	var terms []string
+	for _, f := range strings.Fields(strings.ReplaceAll(keyword, `"`, " ")) {
+		if strings.HasPrefix(f, "-") {
+			continue
+		}
+		terms = append(terms, f)
+	}
+	return terms
+}
+
+// buildSnippet 截取消息内容中第一个命中关键词附近的片段，并高亮所有命中的关键词。
+// 匹配不区分大小写；如果没有找到关键词（例如全文索引做了分词），则返回内容开头部分。
+func buildSnippet(content string, terms []string) string {
+	runes := []rune(content)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	// 找出所有命中区间 [start, end)
+	type span struct{ start, end int }
+	var spans []span
+	for _, term := range terms {
+		t := []rune(strings.ToLower(term))
+		if len(t) == 0 {
+			continue
+		}
+		for i := 0; i+len(t) <= len(lower); i++ {
+			if string(lower[i:i+len(t)]) == string(t) {
+				spans = append(spans, span{i, i + len(t)})
+				i += len(t) - 1
+			}
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	// 以第一个命中位置为中心截取窗口
+	from, to := 0, len(runes)
+	if len(spans) > 0 {
+		from = max(spans[0].start-snippetContext, 0)
+		to = min(spans[0].end+snippetContext, len(runes))
+	} else {
+		to = min(2*snippetContext, len(runes))
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, sp := range spans {
+		// 跳过窗口外或与前一个区间重叠的命中
+		if sp.start < pos || sp.end > to {
+			continue
+		}
+		b.WriteString(html.EscapeString(string(runes[pos:sp.start])))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(string(runes[sp.start:sp.end])))
+		b.WriteString("</mark>")
+		pos = sp.end
+	}
+	b.WriteString(html.EscapeString(string(runes[pos:to])))
+	if to < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
 }
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"polychat/internal/ws"
+)
+
+func TestSaveMessageAsyncAfterDrain(t *testing.T) {
+	t.Cleanup(func() {
+		pendingMu.Lock()
+		draining = false
+		pendingMu.Unlock()
+	})
+	s := &MessageService{}
+	ctx := context.Background()
+	// 非 chat 消息不访问数据库
+	msg := ws.Message{Type: ws.TypeTypingStart, SenderID: 1, ReceiverID: 2, Timestamp: time.Now().Unix()}
+
+	if err := s.SaveMessageAsync(ctx, msg); err != nil {
+		t.Fatalf("关闭前: err = %v", err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if err := WaitPendingWrites(waitCtx); err != nil {
+		t.Fatal(err)
+	}
+	// 开始等待之后不再接受新的写入，否则 Add 与 Wait 并发
+	if err := s.SaveMessageAsync(ctx, msg); !errors.Is(err, ErrShuttingDown) {
+		t.Fatalf("关闭后: err = %v，期望 ErrShuttingDown", err)
+	}
+}
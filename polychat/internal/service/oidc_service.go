@@ -0,0 +1,410 @@
+package service
+
+// 通过外部身份提供方（OIDC）单点登录，使用授权码模式和 PKCE。
+//
+// 配置项：
+//   - POLYCHAT_OIDC_ISSUER         身份提供方地址（issuer），未设置时不启用单点登录
+//   - POLYCHAT_OIDC_CLIENT_ID      在身份提供方注册的客户端ID
+//   - POLYCHAT_OIDC_CLIENT_SECRET  客户端密钥，公开客户端可以不填，此时只依靠 PKCE
+//   - POLYCHAT_OIDC_REDIRECT_URL   回调地址，默认 POLYCHAT_PUBLIC_URL + /api/v1/oidc/callback，需要在身份提供方登记
+//   - POLYCHAT_OIDC_SCOPES         申请的 scope，空格分隔，默认 "openid profile email"
+//   - POLYCHAT_OIDC_PROVIDER_NAME  身份提供方名称，保存在绑定关系中，默认 oidc；更换身份提供方时应使用新名称
+//   - POLYCHAT_OIDC_AUTO_REGISTER  外部账号未绑定时是否自动创建本地用户，默认 true
+//
+// issuer 可以是 http 地址，本地开发时可以使用任意兼容 OIDC discovery 的模拟服务器，
+// 例如 ghcr.io/navikt/mock-oauth2-server：
+//
+//	docker run -p 9090:8080 ghcr.io/navikt/mock-oauth2-server
+//	POLYCHAT_OIDC_ISSUER=http://localhost:9090/default POLYCHAT_OIDC_CLIENT_ID=polychat POLYCHAT_OIDC_CLIENT_SECRET=secret
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"polychat/internal/dao"
+	"polychat/internal/model"
+	"polychat/internal/validation"
+	"polychat/pkg/config"
+	"polychat/pkg/util"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+var (
+	oidcIssuer       = config.GetString("POLYCHAT_OIDC_ISSUER", "")
+	oidcClientID     = config.GetString("POLYCHAT_OIDC_CLIENT_ID", "")
+	oidcClientSecret = config.GetString("POLYCHAT_OIDC_CLIENT_SECRET", "")
+	oidcRedirectURL  = config.GetString("POLYCHAT_OIDC_REDIRECT_URL", publicURL+"/api/v1/oidc/callback")
+	oidcScopes       = strings.Fields(config.GetString("POLYCHAT_OIDC_SCOPES", oidc.ScopeOpenID+" profile email"))
+	oidcProviderName = config.GetString("POLYCHAT_OIDC_PROVIDER_NAME", "oidc")
+	oidcAutoRegister = config.GetBool("POLYCHAT_OIDC_AUTO_REGISTER", true)
+)
+
+// oidcHTTPClient 访问身份提供方（discovery、公钥、换取 token）使用的 HTTP 客户端
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+var (
+	ErrOIDCDisabled        = errors.New("未启用单点登录")
+	ErrOIDCState           = errors.New("登录请求无效或已过期，请重新登录")
+	ErrOIDCFailed          = errors.New("身份提供方验证失败，请重新登录")
+	ErrIdentityLinked      = errors.New("该外部账号已绑定其他用户")
+	ErrIdentityNotLinked   = errors.New("该外部账号未绑定用户，请先使用密码登录后在账号设置中绑定")
+	ErrIdentityEmailUsed   = errors.New("该邮箱已被其他用户使用，请先使用密码登录后在账号设置中绑定")
+	ErrIdentityNotFound    = errors.New("绑定关系不存在")
+	ErrLastLoginMethod     = errors.New("这是账号唯一的登录方式，请先通过邮件设置密码再解绑")
+	ErrIdentityUserDeleted = errors.New("该外部账号绑定的用户已被删除")
+)
+
+// OIDCEnabled 是否配置了单点登录
+func OIDCEnabled() bool {
+	return oidcIssuer != "" && oidcClientID != ""
+}
+
+// oidcClient 身份提供方的 discovery 结果和客户端配置
+type oidcClient struct {
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+var (
+	oidcMu     sync.Mutex
+	oidcCached *oidcClient
+)
+
+// getOIDCClient 第一次使用时访问身份提供方的 discovery 地址，成功后缓存；
+// 失败时不缓存，身份提供方暂时不可用不影响服务启动，恢复后自动可用。
+func getOIDCClient(ctx context.Context) (*oidcClient, error) {
+	if !OIDCEnabled() {
+		return nil, ErrOIDCDisabled
+	}
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	if oidcCached != nil {
+		return oidcCached, nil
+	}
+
+	// provider 会在后台按需获取身份提供方的公钥，不能使用请求的 context，否则请求结束后无法再获取
+	provider, err := oidc.NewProvider(oidcContext(context.WithoutCancel(ctx)), oidcIssuer)
+	if err != nil {
+		return nil, fmt.Errorf("获取身份提供方配置失败: %w", err)
+	}
+	oidcCached = &oidcClient{
+		oauth2: oauth2.Config{
+			ClientID:     oidcClientID,
+			ClientSecret: oidcClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  oidcRedirectURL,
+			Scopes:       oidcScopes,
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: oidcClientID}),
+	}
+	slog.InfoContext(ctx, "已加载身份提供方配置", "issuer", oidcIssuer)
+	return oidcCached, nil
+}
+
+// oidcContext 让 go-oidc 和 oauth2 使用带超时的 HTTP 客户端
+func oidcContext(ctx context.Context) context.Context {
+	return oidc.ClientContext(ctx, oidcHTTPClient)
+}
+
+// OIDCRedirect 发起外部登录的结果：浏览器需要跳转到 URL，StateToken 保存在 cookie 中，回调时原样带回
+type OIDCRedirect struct {
+	URL        string
+	StateToken string
+}
+
+// OIDCResult 外部登录回调的处理结果：登录时 Login 不为空，绑定外部账号时 Linked 为 true
+type OIDCResult struct {
+	Login  *LoginResult
+	Linked bool
+}
+
+// OIDCService 单点登录服务
+type OIDCService struct{}
+
+// BeginLogin 发起外部登录
+func (s *OIDCService) BeginLogin(ctx context.Context) (*OIDCRedirect, error) {
+	return beginOIDC(ctx, 0, 0)
+}
+
+// BeginLink 为已登录的用户发起绑定外部账号，需要校验当前密码，防止 token 泄露后被绑定他人的外部账号。
+// 没有设置密码的用户（通过单点登录注册）不需要密码。
+func (s *OIDCService) BeginLink(ctx context.Context, userID uint, password string) (*OIDCRedirect, error) {
+	user, err := dao.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Password != "" && !util.CheckPassword(password, user.Password) {
+		return nil, ErrWrongPassword
+	}
+	return beginOIDC(ctx, user.ID, user.TokenVersion)
+}
+
+// beginOIDC 生成 state、nonce 和 PKCE code_verifier，返回身份提供方的授权地址
+func beginOIDC(ctx context.Context, userID, tokenVersion uint) (*OIDCRedirect, error) {
+	client, err := getOIDCClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	state, nonce, verifier := rand.Text(), rand.Text(), oauth2.GenerateVerifier()
+	stateToken, err := util.GenerateOIDCStateToken(userID, tokenVersion, state, nonce, verifier)
+	if err != nil {
+		return nil, err
+	}
+	url := client.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	return &OIDCRedirect{URL: url, StateToken: stateToken}, nil
+}
+
+// oidcIdentityClaims ID Token 中用到的字段
+type oidcIdentityClaims struct {
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+}
+
+// Callback 处理身份提供方的回调：校验 state，用授权码和 code_verifier 换取 ID Token 并验证签名和 nonce，
+// 然后登录外部账号绑定的用户，或为发起绑定的用户绑定该外部账号。
+func (s *OIDCService) Callback(ctx context.Context, stateToken, state, code, ip string) (*OIDCResult, error) {
+	claims, err := util.ParseOIDCStateToken(stateToken)
+	if err != nil || subtle.ConstantTimeCompare([]byte(claims.State), []byte(state)) != 1 {
+		return nil, ErrOIDCState
+	}
+	client, err := getOIDCClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	oauthToken, err := client.oauth2.Exchange(oidcContext(ctx), code, oauth2.VerifierOption(claims.Verifier))
+	if err != nil {
+		slog.WarnContext(ctx, "换取身份提供方 token 失败", "err", err)
+		return nil, ErrOIDCFailed
+	}
+	rawIDToken, ok := oauthToken.Extra("id_token").(string)
+	if !ok {
+		slog.WarnContext(ctx, "身份提供方没有返回 id_token")
+		return nil, ErrOIDCFailed
+	}
+	idToken, err := client.verifier.Verify(oidcContext(ctx), rawIDToken)
+	if err != nil {
+		slog.WarnContext(ctx, "验证 id_token 失败", "err", err)
+		return nil, ErrOIDCFailed
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(claims.Nonce)) != 1 {
+		slog.WarnContext(ctx, "id_token 的 nonce 不匹配")
+		return nil, ErrOIDCFailed
+	}
+	var info oidcIdentityClaims
+	if err := idToken.Claims(&info); err != nil {
+		return nil, err
+	}
+
+	if claims.UserID != 0 {
+		if err := linkIdentity(ctx, claims.UserID, claims.TokenVersion, idToken.Subject, info, ip); err != nil {
+			return nil, err
+		}
+		return &OIDCResult{Linked: true}, nil
+	}
+	login, err := loginWithIdentity(ctx, idToken.Subject, info, ip)
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCResult{Login: login}, nil
+}
+
+// linkIdentity 为用户绑定外部账号，已绑定到同一用户时视为成功
+func linkIdentity(ctx context.Context, userID, tokenVersion uint, subject string, info oidcIdentityClaims, ip string) error {
+	user, err := dao.GetUserByID(ctx, userID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ErrOIDCState
+		}
+		return err
+	}
+	// 发起绑定之后修改了密码，之前的登录已失效，绑定请求也随之失效
+	if user.TokenVersion != tokenVersion {
+		return ErrOIDCState
+	}
+
+	existing, err := dao.GetUserIdentity(ctx, oidcProviderName, subject)
+	if err == nil {
+		if existing.UserID != userID {
+			return ErrIdentityLinked
+		}
+		return nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return err
+	}
+
+	now := time.Now()
+	identity := &model.UserIdentity{UserID: userID, Provider: oidcProviderName, Subject: subject, Email: info.Email, LastUsedAt: &now}
+	if err := dao.CreateUserIdentity(ctx, identity); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return ErrIdentityLinked
+		}
+		return err
+	}
+	recordAudit(ctx, &model.AuditLog{Action: model.AuditSSOLinked, UserID: userID, IP: ip, Detail: oidcProviderName})
+	return nil
+}
+
+// loginWithIdentity 登录外部账号绑定的用户，未绑定时按配置自动创建用户。
+// 开启了两步验证的用户同样需要提交验证码。
+func loginWithIdentity(ctx context.Context, subject string, info oidcIdentityClaims, ip string) (*LoginResult, error) {
+	identity, err := dao.GetUserIdentity(ctx, oidcProviderName, subject)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	var user *model.User
+	if identity == nil {
+		if user, err = registerIdentity(ctx, subject, info, ip); err != nil {
+			return nil, err
+		}
+	} else {
+		if user, err = dao.GetUserByID(ctx, identity.UserID); err != nil {
+			// 用户被软删除后绑定关系仍然保留
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrIdentityUserDeleted
+			}
+			return nil, err
+		}
+		if err := dao.TouchUserIdentity(ctx, identity.ID, info.Email, time.Now()); err != nil {
+			slog.ErrorContext(ctx, "更新外部账号登录时间失败", "err", err)
+		}
+	}
+	if err := checkSuspended(user, time.Now()); err != nil {
+		return nil, err
+	}
+	recordAudit(ctx, &model.AuditLog{Action: model.AuditSSOLogin, UserID: user.ID, IP: ip, Detail: oidcProviderName})
+
+	if user.TOTPEnabled {
+		challenge, err := util.GenerateChallengeToken(user.ID, user.TokenVersion)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{UserID: user.ID, Username: user.Username, TwoFactorRequired: true, ChallengeToken: challenge}, nil
+	}
+	return issueSession(ctx, user, ip, model.SessionSSO)
+}
+
+// registerIdentity 为未绑定的外部账号创建本地用户。用户名根据身份提供方返回的用户名或邮箱生成，
+// 已被使用时追加随机后缀；身份提供方确认过的邮箱直接视为已验证。
+// 邮箱已被本地用户验证时不自动创建也不自动绑定：同一个邮箱不代表同一个人，需要该用户登录后主动绑定。
+func registerIdentity(ctx context.Context, subject string, info oidcIdentityClaims, ip string) (*model.User, error) {
+	if !oidcAutoRegister {
+		return nil, ErrIdentityNotLinked
+	}
+
+	var email string
+	var verifiedAt *time.Time
+	if info.EmailVerified {
+		if addr, err := validation.Email(info.Email); err == nil {
+			email = addr
+			now := time.Now()
+			verifiedAt = &now
+		}
+	}
+	if email != "" {
+		_, err := dao.GetUserByVerifiedEmail(ctx, email)
+		if err == nil {
+			return nil, ErrIdentityEmailUsed
+		}
+		if err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
+	}
+
+	base := oidcUsername(info)
+	for attempt := 0; attempt < 5; attempt++ {
+		username := base
+		if attempt > 0 {
+			username = fmt.Sprintf("%s_%s", base, strings.ToLower(rand.Text()[:4]))
+		}
+		now := time.Now()
+		// 单点登录创建的用户没有密码，不能使用密码登录；需要时可以通过已验证的邮箱设置密码
+		user := &model.User{Username: username, Email: email, EmailVerifiedAt: verifiedAt}
+		identity := &model.UserIdentity{Provider: oidcProviderName, Subject: subject, Email: info.Email, LastUsedAt: &now}
+		err := dao.CreateUserWithIdentity(ctx, user, identity)
+		if err == nil {
+			recordAudit(ctx, &model.AuditLog{Action: model.AuditSSORegistered, UserID: user.ID, IP: ip, Detail: oidcProviderName})
+			return user, nil
+		}
+		if !errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, err
+		}
+		// 同一个外部账号并发回调时，另一个请求已经创建了用户
+		if existing, err := dao.GetUserIdentity(ctx, oidcProviderName, subject); err == nil {
+			return dao.GetUserByID(ctx, existing.UserID)
+		}
+	}
+	return nil, fmt.Errorf("为外部账号生成用户名失败: %s", base)
+}
+
+// oidcUsername 根据身份提供方返回的用户名、邮箱或姓名生成符合 validation.Username 规则的用户名，
+// 去掉不允许的字符，留出追加后缀的长度；都不可用时使用 user
+func oidcUsername(info oidcIdentityClaims) string {
+	local, _, _ := strings.Cut(info.Email, "@")
+	for _, candidate := range []string{info.PreferredUsername, local, info.Name} {
+		var b strings.Builder
+		n := 0
+		for _, r := range candidate {
+			if n == 15 {
+				break
+			}
+			if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+				b.WriteRune(r)
+				n++
+			}
+		}
+		name := strings.TrimLeftFunc(b.String(), func(r rune) bool { return !unicode.IsLetter(r) })
+		if validation.Username(name) == nil {
+			return name
+		}
+	}
+	return "user"
+}
+
+// Identities 查询用户绑定的外部账号
+func (s *OIDCService) Identities(ctx context.Context, userID uint) ([]model.UserIdentity, error) {
+	return dao.GetUserIdentities(ctx, userID)
+}
+
+// Unlink 解绑外部账号。没有密码的用户不能解绑最后一个外部账号，否则将无法登录。
+func (s *OIDCService) Unlink(ctx context.Context, userID, identityID uint, ip string) error {
+	user, err := dao.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	identities, err := dao.GetUserIdentities(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.Password == "" && len(identities) <= 1 {
+		for _, identity := range identities {
+			if identity.ID == identityID {
+				return ErrLastLoginMethod
+			}
+		}
+	}
+	deleted, err := dao.DeleteUserIdentity(ctx, userID, identityID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrIdentityNotFound
+	}
+	recordAudit(ctx, &model.AuditLog{Action: model.AuditSSOUnlinked, UserID: userID, IP: ip, Detail: oidcProviderName})
+	return nil
+}
@@ -0,0 +1,469 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"polychat/internal/dao"
+	"polychat/internal/model"
+	"polychat/pkg/util"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockIdP 测试用的 OIDC 身份提供方，提供 discovery、JWKS 和 token 接口。
+// 授权页面不经过浏览器，由 authorize 直接根据授权地址签发授权码。
+type mockIdP struct {
+	srv *httptest.Server
+	key *rsa.PrivateKey
+
+	mu        sync.Mutex
+	grants    map[string]mockGrant // 授权码 -> 授权请求
+	verifiers []string             // token 接口收到的 code_verifier
+}
+
+// mockGrant 一次授权请求：PKCE challenge、ID Token 中的 nonce、sub 和其他字段
+type mockGrant struct {
+	challenge string
+	nonce     string
+	subject   string
+	claims    map[string]any
+}
+
+const mockClientID = "polychat-test"
+
+// newMockIdP 启动模拟的身份提供方，并让 OIDCService 使用它。测试结束后恢复原来的配置。
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockIdP{key: key, grants: make(map[string]mockGrant)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /jwks", p.jwks)
+	mux.HandleFunc("POST /token", p.token)
+	p.srv = httptest.NewServer(mux)
+	t.Cleanup(p.srv.Close)
+
+	// state token 使用 HS256 签名
+	t.Setenv("POLYCHAT_JWT_SECRET", "0123456789abcdef0123456789abcdef")
+	t.Setenv("POLYCHAT_JWT_KEYS_DIR", "")
+	if err := util.InitKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	issuer, clientID, secret, redirect := oidcIssuer, oidcClientID, oidcClientSecret, oidcRedirectURL
+	provider, autoRegister := oidcProviderName, oidcAutoRegister
+	t.Cleanup(func() {
+		oidcIssuer, oidcClientID, oidcClientSecret, oidcRedirectURL = issuer, clientID, secret, redirect
+		oidcProviderName, oidcAutoRegister = provider, autoRegister
+		oidcCached = nil
+	})
+	oidcIssuer, oidcClientID, oidcClientSecret = p.srv.URL, mockClientID, "secret"
+	oidcRedirectURL = "http://polychat.test/api/v1/oidc/callback"
+	// 每个测试使用不同的身份提供方名称，测试库中之前的绑定关系不影响本次测试
+	oidcProviderName = fmt.Sprintf("test-%d", time.Now().UnixNano())
+	oidcAutoRegister = true
+	oidcCached = nil
+	return p
+}
+
+func (p *mockIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                p.srv.URL,
+		"authorization_endpoint":                p.srv.URL + "/authorize",
+		"token_endpoint":                        p.srv.URL + "/token",
+		"jwks_uri":                              p.srv.URL + "/jwks",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (p *mockIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA", "kid": "k1", "alg": "RS256", "use": "sig",
+		"n": base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+		"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+	}}})
+}
+
+// token 用授权码换取 ID Token，code_verifier 与授权时的 code_challenge 不匹配时返回 invalid_grant
+func (p *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	verifier := r.PostForm.Get("code_verifier")
+	p.mu.Lock()
+	grant, ok := p.grants[r.PostForm.Get("code")]
+	delete(p.grants, r.PostForm.Get("code"))
+	p.verifiers = append(p.verifiers, verifier)
+	p.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ok || s256(verifier) != grant.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   p.srv.URL,
+		"aud":   mockClientID,
+		"sub":   grant.subject,
+		"nonce": grant.nonce,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+	for k, v := range grant.claims {
+		claims[k] = v
+	}
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = "k1"
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"access_token": "access", "token_type": "Bearer", "id_token": signed})
+}
+
+// s256 计算 PKCE 的 S256 code_challenge
+func s256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// authorize 模拟用户在身份提供方完成登录：检查授权地址并签发授权码，返回授权码和回调中的 state。
+// nonce 不为空时 ID Token 使用该 nonce，而不是授权地址中的 nonce。
+func (p *mockIdP) authorize(t *testing.T, authURL, subject string, claims map[string]any, nonce string) (code, state string) {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("client_id") != mockClientID || q.Get("redirect_uri") != oidcRedirectURL || q.Get("response_type") != "code" {
+		t.Fatalf("授权地址参数错误: %s", authURL)
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("授权地址缺少 PKCE 参数: %s", authURL)
+	}
+	if nonce == "" {
+		nonce = q.Get("nonce")
+	}
+	code = rand.Text()
+	p.mu.Lock()
+	p.grants[code] = mockGrant{challenge: q.Get("code_challenge"), nonce: nonce, subject: subject, claims: claims}
+	p.mu.Unlock()
+	return code, q.Get("state")
+}
+
+// login 完成一次外部登录
+func (p *mockIdP) login(t *testing.T, subject string, claims map[string]any) (*OIDCResult, error) {
+	t.Helper()
+	s := &OIDCService{}
+	redirect, err := s.BeginLogin(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := p.authorize(t, redirect.URL, subject, claims, "")
+	return s.Callback(context.Background(), redirect.StateToken, state, code, "127.0.0.1")
+}
+
+func TestOIDCAuthorizeURL(t *testing.T) {
+	newMockIdP(t)
+	redirect, err := (&OIDCService{}).BeginLogin(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(redirect.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := util.ParseOIDCStateToken(redirect.StateToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := u.Query()
+	if q.Get("state") != claims.State || q.Get("nonce") != claims.Nonce {
+		t.Fatal("授权地址中的 state、nonce 与 state token 不一致")
+	}
+	// code_verifier 只保存在 state token 中，授权地址中只有它的 S256 摘要
+	if q.Get("code_challenge") != s256(claims.Verifier) || q.Get("code_challenge_method") != "S256" {
+		t.Fatal("code_challenge 不是 code_verifier 的 S256 摘要")
+	}
+	if strings.Contains(redirect.URL, claims.Verifier) {
+		t.Fatal("授权地址泄露了 code_verifier")
+	}
+	if !strings.Contains(" "+q.Get("scope")+" ", " openid ") {
+		t.Fatalf("scope = %q，缺少 openid", q.Get("scope"))
+	}
+	if claims.UserID != 0 {
+		t.Fatal("登录请求的 state token 不应包含用户")
+	}
+}
+
+func TestOIDCCallbackStateMismatch(t *testing.T) {
+	p := newMockIdP(t)
+	s := &OIDCService{}
+	redirect, err := s.BeginLogin(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := p.authorize(t, redirect.URL, "alice", nil, "")
+	session, _ := util.GenerateToken(1, 0, "user")
+
+	tests := []struct {
+		name       string
+		stateToken string
+		state      string
+	}{
+		{"state 不一致", redirect.StateToken, state + "x"},
+		{"缺少 state", redirect.StateToken, ""},
+		{"缺少 state cookie", "", state},
+		{"state cookie 被篡改", redirect.StateToken + "x", state},
+		{"会话 token 作为 state token", session, state},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.Callback(context.Background(), tt.stateToken, tt.state, code, "127.0.0.1"); !errors.Is(err, ErrOIDCState) {
+				t.Fatalf("err = %v，期望 ErrOIDCState", err)
+			}
+		})
+	}
+	// state 校验失败时不使用授权码
+	if len(p.verifiers) != 0 {
+		t.Fatal("state 校验失败后仍然请求了 token 接口")
+	}
+}
+
+func TestOIDCCallbackNonceMismatch(t *testing.T) {
+	p := newMockIdP(t)
+	s := &OIDCService{}
+	redirect, err := s.BeginLogin(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 身份提供方返回的 ID Token 属于另一次登录请求
+	code, state := p.authorize(t, redirect.URL, "alice", nil, "other-nonce")
+	if _, err := s.Callback(context.Background(), redirect.StateToken, state, code, "127.0.0.1"); !errors.Is(err, ErrOIDCFailed) {
+		t.Fatalf("err = %v，期望 ErrOIDCFailed", err)
+	}
+}
+
+func TestOIDCCallbackPKCE(t *testing.T) {
+	p := newMockIdP(t)
+	s := &OIDCService{}
+	redirect, err := s.BeginLogin(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := util.ParseOIDCStateToken(redirect.StateToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := p.authorize(t, redirect.URL, "alice", nil, "")
+
+	// 授权码被截获后，没有对应的 code_verifier 无法换取 token
+	forged, err := util.GenerateOIDCStateToken(0, 0, claims.State, claims.Nonce, "attacker-verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Callback(context.Background(), forged, state, code, "127.0.0.1"); !errors.Is(err, ErrOIDCFailed) {
+		t.Fatalf("err = %v，期望 ErrOIDCFailed", err)
+	}
+	// 客户端认证方式自动探测，token 接口可能收到不止一次请求
+	if len(p.verifiers) == 0 {
+		t.Fatal("没有请求 token 接口")
+	}
+	for _, v := range p.verifiers {
+		if v != "attacker-verifier" {
+			t.Fatalf("token 接口收到的 code_verifier = %v", p.verifiers)
+		}
+	}
+}
+
+func TestOIDCAutoRegister(t *testing.T) {
+	setupTestDB(t)
+	p := newMockIdP(t)
+	ctx := context.Background()
+
+	// 身份提供方返回的用户名已被本地用户使用
+	taken := createTestUser(t, "sso", nil)
+	email := fmt.Sprintf("%s@example.com", taken.Username)
+	claims := map[string]any{"preferred_username": taken.Username, "email": email, "email_verified": true}
+	result, err := p.login(t, "alice", claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Login == nil || result.Login.Token == "" {
+		t.Fatalf("result = %+v，期望签发会话 token", result)
+	}
+	user, err := dao.GetUserByID(ctx, result.Login.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID == taken.ID || !strings.HasPrefix(user.Username, taken.Username+"_") {
+		t.Fatalf("新用户 %d %q，期望在已占用的用户名 %q 后追加后缀", user.ID, user.Username, taken.Username)
+	}
+	// 身份提供方确认过的邮箱视为已验证，单点登录创建的用户没有密码
+	if user.VerifiedEmail() != email || user.Password != "" {
+		t.Fatalf("email=%q verified=%t password=%q", user.Email, user.EmailVerifiedAt != nil, user.Password)
+	}
+
+	// 再次登录同一个外部账号不会创建新用户
+	again, err := p.login(t, "alice", claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Login.UserID != user.ID {
+		t.Fatalf("再次登录的用户 %d，期望 %d", again.Login.UserID, user.ID)
+	}
+
+	// 另一个外部账号使用已被验证的邮箱时不自动创建用户
+	if _, err := p.login(t, "mallory", claims); !errors.Is(err, ErrIdentityEmailUsed) {
+		t.Fatalf("err = %v，期望 ErrIdentityEmailUsed", err)
+	}
+
+	// 关闭自动注册后，未绑定的外部账号不能登录
+	oidcAutoRegister = false
+	if _, err := p.login(t, "bob", nil); !errors.Is(err, ErrIdentityNotLinked) {
+		t.Fatalf("err = %v，期望 ErrIdentityNotLinked", err)
+	}
+}
+
+func TestOIDCLoginDeletedUser(t *testing.T) {
+	setupTestDB(t)
+	p := newMockIdP(t)
+	ctx := context.Background()
+
+	result, err := p.login(t, "alice", map[string]any{"preferred_username": "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dao.SoftDeleteUser(ctx, result.Login.UserID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.login(t, "alice", nil); !errors.Is(err, ErrIdentityUserDeleted) {
+		t.Fatalf("err = %v，期望 ErrIdentityUserDeleted", err)
+	}
+}
+
+func TestOIDCLink(t *testing.T) {
+	setupTestDB(t)
+	p := newMockIdP(t)
+	ctx := context.Background()
+	hash, err := util.HashPassword("correct horse 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := createTestUser(t, "link", func(u *model.User) { u.Password = hash })
+	s := &OIDCService{}
+
+	if _, err := s.BeginLink(ctx, user.ID, "wrong"); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("密码错误: err = %v", err)
+	}
+	link := func(subject string) error {
+		redirect, err := s.BeginLink(ctx, user.ID, "correct horse 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, state := p.authorize(t, redirect.URL, subject, nil, "")
+		result, err := s.Callback(ctx, redirect.StateToken, state, code, "127.0.0.1")
+		if err == nil && !result.Linked {
+			t.Fatalf("result = %+v，期望绑定成功", result)
+		}
+		return err
+	}
+	if err := link("alice"); err != nil {
+		t.Fatal(err)
+	}
+	// 绑定之后可以通过外部账号登录
+	result, err := p.login(t, "alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Login.UserID != user.ID {
+		t.Fatalf("登录的用户 %d，期望 %d", result.Login.UserID, user.ID)
+	}
+
+	// 已绑定其他用户的外部账号不能再绑定
+	other, err := p.login(t, "bob", map[string]any{"preferred_username": "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Login.UserID == user.ID {
+		t.Fatal("bob 应创建新用户")
+	}
+	if err := link("bob"); !errors.Is(err, ErrIdentityLinked) {
+		t.Fatalf("err = %v，期望 ErrIdentityLinked", err)
+	}
+}
+
+func TestOIDCUnlinkLastLoginMethod(t *testing.T) {
+	setupTestDB(t)
+	p := newMockIdP(t)
+	ctx := context.Background()
+	s := &OIDCService{}
+
+	// 单点登录创建的用户没有密码
+	result, err := p.login(t, "alice", map[string]any{"preferred_username": "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := result.Login.UserID
+	identities, err := s.Identities(ctx, userID)
+	if err != nil || len(identities) != 1 {
+		t.Fatalf("identities = %v, err = %v", identities, err)
+	}
+	first := identities[0]
+
+	if err := s.Unlink(ctx, userID, first.ID, "127.0.0.1"); !errors.Is(err, ErrLastLoginMethod) {
+		t.Fatalf("解绑唯一的登录方式: err = %v，期望 ErrLastLoginMethod", err)
+	}
+
+	// 绑定第二个外部账号后可以解绑其中一个，但不能再解绑剩下的一个
+	second := &model.UserIdentity{UserID: userID, Provider: oidcProviderName, Subject: "alice-2"}
+	if err := dao.CreateUserIdentity(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Unlink(ctx, userID, first.ID, "127.0.0.1"); err != nil {
+		t.Fatalf("解绑两个中的一个: %v", err)
+	}
+	if err := s.Unlink(ctx, userID, second.ID, "127.0.0.1"); !errors.Is(err, ErrLastLoginMethod) {
+		t.Fatalf("解绑剩下的一个: err = %v，期望 ErrLastLoginMethod", err)
+	}
+
+	// 不能解绑其他用户的外部账号
+	other := createTestUser(t, "unlink", func(u *model.User) { u.Password = "x" })
+	if err := s.Unlink(ctx, other.ID, second.ID, "127.0.0.1"); !errors.Is(err, ErrIdentityNotFound) {
+		t.Fatalf("解绑其他用户的外部账号: err = %v，期望 ErrIdentityNotFound", err)
+	}
+
+	// 有密码的用户可以解绑唯一的外部账号
+	mine := &model.UserIdentity{UserID: other.ID, Provider: oidcProviderName, Subject: "other"}
+	if err := dao.CreateUserIdentity(ctx, mine); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Unlink(ctx, other.ID, mine.ID, "127.0.0.1"); err != nil {
+		t.Fatalf("有密码的用户解绑: %v", err)
+	}
+}
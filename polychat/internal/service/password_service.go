@@ -0,0 +1,150 @@
+package service
+
+// 修改密码和通过邮件重置密码
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"polychat/internal/dao"
+	"polychat/internal/model"
+	"polychat/internal/validation"
+	"polychat/internal/ws"
+	"polychat/pkg/config"
+	"polychat/pkg/mailer"
+	"polychat/pkg/util"
+
+	"gorm.io/gorm"
+)
+
+// publicURL 用户访问 polychat 的地址，用于生成邮件中的链接
+var publicURL = strings.TrimRight(config.GetString("POLYCHAT_PUBLIC_URL", "http://localhost:8080"), "/")
+
+// mailTimeout 发送一封邮件的超时时间
+const mailTimeout = 30 * time.Second
+
+var (
+	ErrWrongPassword     = errors.New("当前密码错误")
+	ErrInvalidResetToken = errors.New("重置链接无效或已过期，请重新申请")
+)
+
+// PasswordService 密码管理服务
+type PasswordService struct{}
+
+// ChangePassword 校验当前密码后修改密码，新密码不符合要求时返回 validation.Errors。
+// 修改后用户之前的 token 全部失效、所有连接被断开，返回新的会话 token 供当前客户端继续使用。
+func (s *PasswordService) ChangePassword(ctx context.Context, userID uint, oldPassword, newPassword, ip string) (string, error) {
+	user, err := dao.GetUserByID(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if !util.CheckPassword(oldPassword, user.Password) {
+		return "", ErrWrongPassword
+	}
+	if err := validation.Password(newPassword, user.Username); err != nil {
+		return "", validation.Field("new_password", err.Error())
+	}
+	if err := setPassword(ctx, user, newPassword); err != nil {
+		return "", err
+	}
+	recordAudit(ctx, &model.AuditLog{Action: model.AuditPasswordChanged, UserID: userID, IP: ip})
+	recordSession(ctx, userID, user.TokenVersion+1, ip, model.SessionPasswordChange, time.Now())
+	return util.GenerateToken(userID, user.TokenVersion+1, model.NormalizeRole(user.Role))
+}
+
+// RequestReset 申请重置密码：向用户已验证的邮箱发送带重置 token 的链接。
+// 无论用户是否存在、是否绑定了邮箱都返回成功，邮件在后台发送，
+// 调用方无法通过返回值或响应时间判断用户名是否存在。
+func (s *PasswordService) RequestReset(ctx context.Context, username, ip string) error {
+	user, err := dao.GetUserByUsername(ctx, username)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			slog.InfoContext(ctx, "申请重置密码的用户不存在")
+			return nil
+		}
+		return err
+	}
+	// 只向验证过的邮箱发送，防止重置链接被发到用户随手填写的他人邮箱
+	if user.VerifiedEmail() == "" {
+		slog.InfoContext(ctx, "申请重置密码的用户未绑定已验证的邮箱", "user_id", user.ID)
+		return nil
+	}
+	if err := sendResetMail(ctx, user, ip); err != nil {
+		return err
+	}
+	recordAudit(ctx, &model.AuditLog{Action: model.AuditPasswordResetRequested, UserID: user.ID, IP: ip})
+	return nil
+}
+
+// sendResetMail 在后台向用户已验证的邮箱发送重置密码链接，调用方需要先确认用户有已验证的邮箱
+func sendResetMail(ctx context.Context, user *model.User, ip string) error {
+	token, err := util.GeneratePasswordResetToken(user.ID, user.TokenVersion)
+	if err != nil {
+		return err
+	}
+	msg := mailer.Message{
+		To:      user.VerifiedEmail(),
+		Subject: "重置 polychat 密码",
+		Body: fmt.Sprintf("%s，你好：\n\n我们收到了重置你的 polychat 密码的申请（来自 IP %s）。\n"+
+			"请在 30 分钟内打开下面的链接设置新密码，链接只能使用一次：\n\n%s/?reset_token=%s\n\n"+
+			"如果这不是你本人的操作，请忽略这封邮件，你的密码不会被修改。\n",
+			user.Username, ip, publicURL, url.QueryEscape(token)),
+	}
+	sendMailAsync(ctx, msg)
+	return nil
+}
+
+// ResetPassword 使用邮件中的重置 token 设置新密码，新密码不符合要求时返回 validation.Errors。
+// 重置后用户之前的 token（包括这个重置 token）全部失效、所有连接被断开，登录失败计数被清除。
+func (s *PasswordService) ResetPassword(ctx context.Context, token, newPassword, ip string) error {
+	claims, err := util.ParsePasswordResetToken(token)
+	if err != nil {
+		return ErrInvalidResetToken
+	}
+	user, err := dao.GetUserByID(ctx, claims.UserID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+	if user.TokenVersion != claims.TokenVersion {
+		return ErrInvalidResetToken
+	}
+	if err := validation.Password(newPassword, user.Username); err != nil {
+		return validation.Field("new_password", err.Error())
+	}
+	if err := setPassword(ctx, user, newPassword); err != nil {
+		if errors.Is(err, errTokenVersionChanged) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+	clearLoginFailures(ctx, user.Username)
+	recordAudit(ctx, &model.AuditLog{Action: model.AuditPasswordReset, UserID: user.ID, IP: ip})
+	return nil
+}
+
+// errTokenVersionChanged 修改密码时用户的 token 版本已被其他请求修改
+var errTokenVersionChanged = errors.New("密码已被修改，请重试")
+
+// setPassword 保存新密码、吊销之前签发的全部 token，并断开用户的所有连接
+func setPassword(ctx context.Context, user *model.User, newPassword string) error {
+	hash, err := util.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	updated, err := dao.UpdateUserPassword(ctx, user.ID, user.TokenVersion, hash)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return errTokenVersionChanged
+	}
+	ws.ClientMgr.Disconnect(user.ID, "credentials changed")
+	return nil
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"polychat/internal/dao"
+	"polychat/internal/model"
+	"polychat/internal/ws"
+)
+
+// PresenceService 在线状态服务
+// 用户上线/下线时向其在线好友推送 presence 事件，下线时记录最后在线时间。
+// 开启了“隐藏在线状态”的用户不会向好友推送任何事件。
+// presence 事件同时携带用户的自定义状态和免打扰标记。
+type PresenceService struct{}
+
+// OnPresenceChange 用户上线或下线时调用，注册为 ws.ClientMgr.OnPresenceChange
+func (s *PresenceService) OnPresenceChange(ctx context.Context, userID uint, online bool) {
+	now := time.Now()
+	if !online {
+		if err := dao.UpdateUserLastSeen(ctx, userID, now); err != nil {
+			slog.ErrorContext(ctx, "更新最后在线时间失败", "user_id", userID, "err", err)
+		}
+	}
+
+	user, err := dao.GetUserByID(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "查询用户失败", "user_id", userID, "err", err)
+		return
+	}
+
+	// 通知在线好友
+	if !user.HidePresence {
+		s.broadcast(ctx, user, online, now)
+	}
+
+	// 新上线的用户：推送当前在线好友的状态快照，客户端无需再轮询好友列表
+	if online {
+		friends, err := dao.GetRelation(ctx, userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "查询好友列表失败", "user_id", userID, "err", err)
+			return
+		}
+		friendIDs := make([]uint, 0, len(friends))
+		for _, r := range friends {
+			friendIDs = append(friendIDs, r.TargetID)
+		}
+		users, err := dao.GetUsersByIDs(ctx, friendIDs)
+		if err != nil {
+			slog.ErrorContext(ctx, "查询好友信息失败", "user_id", userID, "err", err)
+			return
+		}
+		for _, id := range friendIDs {
+			friend, ok := users[id]
+			if !ok || friend.HidePresence || !ws.ClientMgr.IsUserOnline(id) {
+				continue
+			}
+			ws.ClientMgr.SendMessageTo(userID, presenceMessage(friend, userID, true, now))
+		}
+	}
+}
+
+// LoadDoNotDisturb 查询用户是否开启了免打扰，注册为 ws.ClientMgr.LoadDoNotDisturb。
+// 查询失败时视为未开启，宁可多推送通知也不丢失
+func (s *PresenceService) LoadDoNotDisturb(ctx context.Context, userID uint) bool {
+	user, err := dao.GetUserByID(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "查询免打扰设置失败", "user_id", userID, "err", err)
+		return false
+	}
+	return user.DoNotDisturb
+}
+
+// SetHidePresence 设置是否隐藏在线状态。
+// 在线用户切换设置时，立即向好友推送相应的上线/下线事件。
+func (s *PresenceService) SetHidePresence(ctx context.Context, userID uint, hide bool) error {
+	user, err := dao.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.HidePresence == hide {
+		return nil
+	}
+	if err := dao.UpdateUserHidePresence(ctx, userID, hide); err != nil {
+		return err
+	}
+	if ws.ClientMgr.IsUserOnline(userID) {
+		s.broadcast(ctx, user, !hide, time.Now())
+	}
+	return nil
+}
+
+// NotifyStatusChange 用户修改自定义状态或免打扰后，向在线好友推送带最新状态的 presence 事件
+func (s *PresenceService) NotifyStatusChange(ctx context.Context, userID uint) {
+	if !ws.ClientMgr.IsUserOnline(userID) {
+		return
+	}
+	user, err := dao.GetUserByID(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "查询用户失败", "user_id", userID, "err", err)
+		return
+	}
+	if !user.HidePresence {
+		s.broadcast(ctx, user, true, time.Now())
+	}
+}
+
+// broadcast 向用户的所有在线好友推送 presence 事件
+func (s *PresenceService) broadcast(ctx context.Context, user *model.User, online bool, at time.Time) {
+	friends, err := dao.GetRelation(ctx, user.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "查询好友列表失败", "user_id", user.ID, "err", err)
+		return
+	}
+	for _, r := range friends {
+		if ws.ClientMgr.IsUserOnline(r.TargetID) {
+			ws.ClientMgr.SendMessageTo(r.TargetID, presenceMessage(user, r.TargetID, online, at))
+		}
+	}
+}
+
+// presenceMessage 构造 presence 事件，Timestamp 为状态变化的时间（下线时即最后在线时间）
+func presenceMessage(user *model.User, receiverID uint, online bool, at time.Time) ws.Message {
+	content := ws.PresenceOffline
+	if online {
+		content = ws.PresenceOnline
+	}
+	return ws.Message{
+		Type:       ws.TypePresence,
+		SenderID:   user.ID,
+		ReceiverID: receiverID,
+		Content:    content,
+		Timestamp:  at.Unix(),
+		Status:     StatusOf(user),
+	}
+}
@@ -0,0 +1,81 @@
+package service
+
+// 个人资料的查询与修改
+import (
+	"context"
+	"errors"
+
+	"polychat/internal/dao"
+	"polychat/internal/model"
+	"polychat/internal/validation"
+
+	"gorm.io/gorm"
+)
+
+// Profile 用户的个人资料
+type Profile struct {
+	UserID        uint   `json:"user_id"`
+	Username      string `json:"username"`
+	Role          string `json:"role"`
+	Avatar        string `json:"avatar"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// GetProfile 获取用户自己的个人资料
+func (s *UserService) GetProfile(ctx context.Context, userID uint) (*Profile, error) {
+	user, err := dao.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &Profile{
+		UserID:        user.ID,
+		Username:      user.Username,
+		Role:          model.NormalizeRole(user.Role),
+		Avatar:        user.Avatar,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerifiedAt != nil,
+	}, nil
+}
+
+// UpdateProfile 修改用户名和头像，参数为 nil 表示不修改该字段，头像为空字符串表示清除头像。
+// 新用户名使用与注册相同的规则校验，不符合要求或已被使用时返回 validation.Errors。
+// 用户名只用于登录和展示，会话 token 中只有用户ID，修改后已登录的客户端不受影响。
+func (s *UserService) UpdateProfile(ctx context.Context, userID uint, username, avatar *string) (*Profile, error) {
+	user, err := dao.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	newUsername, newAvatar := "", user.Avatar
+	errs := validation.Errors{}
+	// 用户名没有变化时不再校验，保留规则收紧之前注册的用户名不受影响
+	if username != nil && *username != user.Username {
+		newUsername = *username
+		errs.Check("username", validation.Username(newUsername))
+	}
+	if avatar != nil {
+		newAvatar = *avatar
+		errs.Check("avatar", validation.Avatar(newAvatar))
+	}
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+
+	if newUsername != "" {
+		existing, err := dao.GetUserByUsername(ctx, newUsername)
+		if err == nil && existing.ID != userID {
+			return nil, errUsernameTaken
+		}
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
+	}
+	if err := dao.UpdateUserProfile(ctx, userID, newUsername, newAvatar); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, errUsernameTaken
+		}
+		return nil, err
+	}
+	return s.GetProfile(ctx, userID)
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"polychat/internal/dao"
 	"polychat/internal/model"
@@ -10,21 +11,24 @@ import (
 
 type RelationService struct{}
 
+// ErrNotFriends 会话设置只能在互为好友的双方之间修改，被拉黑后不能再修改
+var ErrNotFriends = errors.New("对方不是你的好友")
+
 // AddFriend 发送好友请求（创建 relation_type=0 的待处理记录）
-func (s *RelationService) AddFriend(ownerID, targetID uint, note string) error {
+func (s *RelationService) AddFriend(ctx context.Context, ownerID, targetID uint, note string) error {
 	// 不能加自己为好友
 	if ownerID == targetID {
 		return errors.New("不能添加自己为好友")
 	}
 
 	// 检查目标用户是否存在
-	_, err := dao.GetUserByID(targetID)
+	_, err := dao.GetUserByID(ctx, targetID)
 	if err != nil {
 		return errors.New("目标用户不存在")
 	}
 
 	// 检查是否已经是好友（任一方向）
-	existing, err := dao.GetRelationByPair(ownerID, targetID)
+	existing, err := dao.GetRelationByPair(ctx, ownerID, targetID)
 	if err == nil && existing != nil {
 		if existing.RelationType == 1 {
 			return errors.New("已经是好友了")
@@ -35,7 +39,7 @@ func (s *RelationService) AddFriend(ownerID, targetID uint, note string) error {
 	}
 
 	// 检查对方是否已经向我发送过请求
-	reverse, err := dao.GetRelationByPair(targetID, ownerID)
+	reverse, err := dao.GetRelationByPair(ctx, targetID, ownerID)
 	if err == nil && reverse != nil {
 		if reverse.RelationType == 0 {
 			return errors.New("对方已向你发送好友请求，请在消息箱中处理")
@@ -52,15 +56,15 @@ func (s *RelationService) AddFriend(ownerID, targetID uint, note string) error {
 		RelationType: 0, // 待处理
 		Note:         note,
 	}
-	return dao.CreateRelation(relation)
+	return dao.CreateRelation(ctx, relation)
 }
 
 // AcceptFriendRequest 接受好友请求
 // requesterID 是发起请求的人（relation表中的owner_id）
 // currentUserID 是当前用户（relation表中的target_id）
-func (s *RelationService) AcceptFriendRequest(currentUserID, requesterID uint) error {
+func (s *RelationService) AcceptFriendRequest(ctx context.Context, currentUserID, requesterID uint) error {
 	// 验证确实存在一条待处理的好友请求
-	relation, err := dao.GetRelationByPair(requesterID, currentUserID)
+	relation, err := dao.GetRelationByPair(ctx, requesterID, currentUserID)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return errors.New("好友请求不存在")
@@ -72,7 +76,7 @@ func (s *RelationService) AcceptFriendRequest(currentUserID, requesterID uint) e
 	}
 
 	// 将原请求更新为已确认（relation_type = 1）
-	if err := dao.UpdateRelationType(requesterID, currentUserID, 1); err != nil {
+	if err := dao.UpdateRelationType(ctx, requesterID, currentUserID, 1); err != nil {
 		return err
 	}
 
@@ -83,13 +87,13 @@ func (s *RelationService) AcceptFriendRequest(currentUserID, requesterID uint) e
 		RelationType: 1,
 		Note:         "", // 被接受方可以后续修改备注
 	}
-	return dao.CreateRelation(reverseRelation)
+	return dao.CreateRelation(ctx, reverseRelation)
 }
 
 // RejectFriendRequest 拒绝好友请求
-func (s *RelationService) RejectFriendRequest(currentUserID, requesterID uint) error {
+func (s *RelationService) RejectFriendRequest(ctx context.Context, currentUserID, requesterID uint) error {
 	// 验证确实存在一条待处理的好友请求
-	relation, err := dao.GetRelationByPair(requesterID, currentUserID)
+	relation, err := dao.GetRelationByPair(ctx, requesterID, currentUserID)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return errors.New("好友请求不存在")
@@ -101,31 +105,46 @@ func (s *RelationService) RejectFriendRequest(currentUserID, requesterID uint) e
 	}
 
 	// 删除待处理的请求记录
-	return dao.DeleteRelation(requesterID, currentUserID)
+	return dao.DeleteRelation(ctx, requesterID, currentUserID)
 }
 
 // GetPendingRequests 获取当前用户收到的待处理好友请求
-func (s *RelationService) GetPendingRequests(userID uint) ([]model.Relation, error) {
-	return dao.GetPendingRequests(userID)
+func (s *RelationService) GetPendingRequests(ctx context.Context, userID uint) ([]model.Relation, error) {
+	return dao.GetPendingRequests(ctx, userID)
 }
 
 // DeleteFriend 删除好友（双向删除）
-func (s *RelationService) DeleteFriend(ownerID, targetID uint) error {
+func (s *RelationService) DeleteFriend(ctx context.Context, ownerID, targetID uint) error {
 	// 删除自己的记录
-	if err := dao.DeleteRelation(ownerID, targetID); err != nil {
+	if err := dao.DeleteRelation(ctx, ownerID, targetID); err != nil {
 		return err
 	}
 	// 删除对方的记录
-	_ = dao.DeleteRelation(targetID, ownerID)
+	_ = dao.DeleteRelation(ctx, targetID, ownerID)
 	return nil
 }
 
 // GetFriend 获取已确认的好友列表
-func (s *RelationService) GetFriend(ownerID uint) ([]model.Relation, error) {
-	return dao.GetRelation(ownerID)
+func (s *RelationService) GetFriend(ctx context.Context, ownerID uint) ([]model.Relation, error) {
+	return dao.GetRelation(ctx, ownerID)
 }
 
 // UpdateFriendNote 更新好友备注
-func (s *RelationService) UpdateFriendNote(ownerID, targetID uint, note string) error {
-	return dao.UpdateRelationNote(ownerID, targetID, note)
+func (s *RelationService) UpdateFriendNote(ctx context.Context, ownerID, targetID uint, note string) error {
+	return dao.UpdateRelationNote(ctx, ownerID, targetID, note)
+}
+
+// AreFriends 判断两个用户是否互为好友。
+// 要求双方的关系记录都为已确认（relation_type = 1），任一方拉黑（relation_type = 2）或
+// 关系不存在时返回 false。用于输入提示等只在好友之间可见的功能。
+func (s *RelationService) AreFriends(ctx context.Context, userID, targetID uint) bool {
+	forward, err := dao.GetRelationByPair(ctx, userID, targetID)
+	if err != nil || forward.RelationType != 1 {
+		return false
+	}
+	reverse, err := dao.GetRelationByPair(ctx, targetID, userID)
+	if err != nil || reverse.RelationType != 1 {
+		return false
+	}
+	return true
 }
@@ -0,0 +1,142 @@
+// Package service 提供业务逻辑层。
+// 本文件负责消息保留策略：
+//   - 全局策略：通过环境变量 POLYCHAT_MESSAGE_RETENTION 设置最长保留时长（如 "365d"），不设置则永久保留
+//   - 会话策略：会话中任意一方都可以开启“N 天后自动删除”，对会话双方同时生效
+//
+// 两种策略同时存在时，以更短的保留时长为准。
+// 删除由后台任务周期性执行（间隔由 POLYCHAT_RETENTION_INTERVAL 设置，默认 10 分钟），
+// 删除消息时会一并执行 dao.RegisterMessageCleanup 注册的关联数据清理。
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"polychat/internal/dao"
+	"polychat/internal/model"
+	"polychat/pkg/config"
+	"polychat/pkg/logger"
+	"polychat/pkg/telemetry"
+
+	"gorm.io/gorm"
+)
+
+// MaxRetentionDays 会话保留天数的上限
+const MaxRetentionDays = 3650
+
+var (
+	// globalRetention 全局消息保留时长，0 表示永久保留
+	globalRetention = config.GetDuration("POLYCHAT_MESSAGE_RETENTION", 0)
+	// retentionInterval 后台清理任务的执行间隔
+	retentionInterval = config.GetDuration("POLYCHAT_RETENTION_INTERVAL", 10*time.Minute)
+)
+
+// RetentionService 消息保留策略服务
+type RetentionService struct {
+	messageDAO      dao.MessageDAO
+	relationService RelationService
+}
+
+// StartRetentionJob 启动后台清理任务：启动时立即执行一次，之后按固定间隔执行，ctx 取消时停止。
+func (s *RetentionService) StartRetentionJob(ctx context.Context) {
+	if retentionInterval <= 0 {
+		slog.InfoContext(ctx, "消息保留清理任务已禁用")
+		return
+	}
+	ctx = logger.With(ctx, "job", "retention")
+	go func() {
+		ticker := time.NewTicker(retentionInterval)
+		defer ticker.Stop()
+		for {
+			s.EnforceRetention(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// EnforceRetention 执行一次保留策略，删除所有已超过保留期限的消息。
+func (s *RetentionService) EnforceRetention(ctx context.Context) {
+	ctx, span := telemetry.Tracer().Start(ctx, "job.retention")
+	defer span.End()
+	now := time.Now()
+
+	// 全局策略
+	if globalRetention > 0 {
+		deleted, err := s.messageDAO.DeleteMessagesBefore(ctx, now.Add(-globalRetention).Unix(), 0, 0)
+		if err != nil {
+			slog.ErrorContext(ctx, "全局消息保留清理失败", "err", err)
+		} else if deleted > 0 {
+			slog.InfoContext(ctx, "全局消息保留清理", "deleted", deleted)
+		}
+	}
+
+	// 会话策略
+	settings, err := dao.GetRetentionSettings(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "查询会话保留设置失败", "err", err)
+		return
+	}
+	for _, setting := range settings {
+		retention := time.Duration(setting.RetentionDays) * 24 * time.Hour
+		// 比全局策略更宽松的会话设置不需要单独处理
+		if globalRetention > 0 && retention >= globalRetention {
+			continue
+		}
+		deleted, err := s.messageDAO.DeleteMessagesBefore(ctx, now.Add(-retention).Unix(), setting.UserA, setting.UserB)
+		if err != nil {
+			slog.ErrorContext(ctx, "会话消息保留清理失败", "user_a", setting.UserA, "user_b", setting.UserB, "err", err)
+			continue
+		}
+		if deleted > 0 {
+			slog.InfoContext(ctx, "会话消息保留清理", "user_a", setting.UserA, "user_b", setting.UserB, "deleted", deleted)
+		}
+	}
+}
+
+// GetConversationRetention 获取会话的保留天数，0 表示未开启。
+func (s *RetentionService) GetConversationRetention(ctx context.Context, userID, targetID uint) (int, error) {
+	setting, err := dao.GetConversationSetting(ctx, userID, targetID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return setting.RetentionDays, nil
+}
+
+// SetConversationRetention 设置会话的保留天数，days 为 0 表示关闭自动删除。
+// 互为好友的会话双方都可以设置，设置对双方同时生效。
+func (s *RetentionService) SetConversationRetention(ctx context.Context, userID, targetID uint, days int) error {
+	if userID == targetID {
+		return errors.New("不能对自己设置会话保留策略")
+	}
+	if days < 0 || days > MaxRetentionDays {
+		return fmt.Errorf("保留天数必须在 0 到 %d 之间", MaxRetentionDays)
+	}
+	if _, err := dao.GetUserByID(ctx, targetID); err != nil {
+		return errors.New("目标用户不存在")
+	}
+	// 自动删除对双方生效，只有互为好友时才能设置，防止陌生人或被拉黑的用户清空对方的聊天记录
+	if !s.relationService.AreFriends(ctx, userID, targetID) {
+		return ErrNotFriends
+	}
+
+	setting, err := dao.GetConversationSetting(ctx, userID, targetID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		setting = &model.ConversationSetting{UserA: userID, UserB: targetID}
+	}
+	setting.RetentionDays = days
+	setting.UpdatedBy = userID
+	return dao.SaveConversationSetting(ctx, setting)
+}
@@ -0,0 +1,60 @@
+package service
+
+// 用户角色管理
+import (
+	"context"
+	"errors"
+
+	"polychat/internal/dao"
+	"polychat/internal/model"
+	"polychat/internal/ws"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidRole    = errors.New("角色不存在")
+	ErrChangeOwnRole  = errors.New("不能修改自己的角色")
+	ErrTargetNotFound = errors.New("用户不存在")
+)
+
+// RoleService 角色管理服务
+type RoleService struct{}
+
+// SetRole 修改用户角色，actorID 为执行操作的管理员，0 表示通过命令行工具执行。
+// 修改后用户之前的 token 全部失效、所有连接被断开，重新登录后获得带新角色的 token。
+// 管理员不能修改自己的角色，避免误操作后系统中没有管理员。
+func (s *RoleService) SetRole(ctx context.Context, actorID, userID uint, role, ip string) error {
+	if !model.ValidRole(role) {
+		return ErrInvalidRole
+	}
+	if actorID != 0 && actorID == userID {
+		return ErrChangeOwnRole
+	}
+	user, err := dao.GetUserByID(ctx, userID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ErrTargetNotFound
+		}
+		return err
+	}
+	oldRole := model.NormalizeRole(user.Role)
+	if oldRole == role {
+		return nil
+	}
+	if err := dao.UpdateUserRole(ctx, userID, role); err != nil {
+		return err
+	}
+	ws.ClientMgr.Disconnect(userID, "role changed")
+	recordAudit(ctx, &model.AuditLog{Action: model.AuditRoleChanged, UserID: userID, ActorID: actorID, IP: ip, Detail: roleChangeDetail(actorID, oldRole, role)})
+	return nil
+}
+
+// roleChangeDetail 审计日志中记录的角色变化，操作人记录在 ActorID 中，命令行工具执行时额外注明
+func roleChangeDetail(actorID uint, oldRole, newRole string) string {
+	detail := oldRole + " -> " + newRole
+	if actorID == 0 {
+		return detail + " (polychat-admin)"
+	}
+	return detail
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"polychat/internal/dao"
+	"polychat/internal/model"
+	"polychat/internal/ws"
+)
+
+// 自定义状态的长度限制（按字符计）
+const (
+	maxStatusTextLen  = 100
+	maxStatusEmojiLen = 8
+)
+
+// StatusService 自定义状态与免打扰服务
+type StatusService struct {
+	presenceService PresenceService
+}
+
+// StatusOf 返回用户当前对外展示的状态，没有有效的自定义状态且未开启免打扰时返回 nil
+func StatusOf(user *model.User) *ws.UserStatus {
+	text, emoji, expiresAt := user.ActiveStatus(time.Now())
+	if text == "" && emoji == "" && !user.DoNotDisturb {
+		return nil
+	}
+	status := &ws.UserStatus{
+		Text:         text,
+		Emoji:        emoji,
+		DoNotDisturb: user.DoNotDisturb,
+	}
+	if expiresAt != nil {
+		status.ExpiresAt = expiresAt.Unix()
+	}
+	return status
+}
+
+// GetStatus 获取用户自己的状态
+func (s *StatusService) GetStatus(ctx context.Context, userID uint) (*ws.UserStatus, error) {
+	user, err := dao.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if status := StatusOf(user); status != nil {
+		return status, nil
+	}
+	return &ws.UserStatus{}, nil
+}
+
+// SetStatus 设置自定义状态，text 和 emoji 都为空表示清除状态。
+// expiresIn 为状态的有效时长，0 表示不过期。设置后向在线好友推送带新状态的 presence 事件。
+func (s *StatusService) SetStatus(ctx context.Context, userID uint, text, emoji string, expiresIn time.Duration) error {
+	if utf8.RuneCountInString(text) > maxStatusTextLen {
+		return errors.New("状态文字不能超过 100 个字符")
+	}
+	if utf8.RuneCountInString(emoji) > maxStatusEmojiLen {
+		return errors.New("状态表情过长")
+	}
+	if expiresIn < 0 {
+		return errors.New("过期时间不能为负数")
+	}
+
+	var expiresAt *time.Time
+	if expiresIn > 0 && (text != "" || emoji != "") {
+		t := time.Now().Add(expiresIn)
+		expiresAt = &t
+	}
+	if err := dao.UpdateUserStatus(ctx, userID, text, emoji, expiresAt); err != nil {
+		return err
+	}
+	s.presenceService.NotifyStatusChange(ctx, userID)
+	return nil
+}
+
+// SetDoNotDisturb 开启或关闭免打扰，立即对当前在线连接生效
+func (s *StatusService) SetDoNotDisturb(ctx context.Context, userID uint, on bool) error {
+	if err := dao.UpdateUserDoNotDisturb(ctx, userID, on); err != nil {
+		return err
+	}
+	ws.ClientMgr.SetDoNotDisturb(userID, on)
+	s.presenceService.NotifyStatusChange(ctx, userID)
+	return nil
+}
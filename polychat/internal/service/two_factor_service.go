@@ -0,0 +1,242 @@
+package service
+
+// 两步验证（TOTP）：绑定、确认、关闭、恢复码，以及登录时的验证码校验
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"polychat/internal/dao"
+	"polychat/internal/model"
+	"polychat/pkg/config"
+	"polychat/pkg/totp"
+	"polychat/pkg/util"
+)
+
+// totpIssuer 验证器应用中显示的服务名称
+var totpIssuer = config.GetString("POLYCHAT_TOTP_ISSUER", "polychat")
+
+const (
+	// recoveryCodeCount 每次生成的恢复码数量
+	recoveryCodeCount = 10
+	// recoveryCodeLen 恢复码长度（不含分隔符）
+	recoveryCodeLen = 10
+	// recoveryCodeAlphabet 恢复码字符集：小写的 base32 字母表（26 个字母和数字 2-7，不含 0/1/8/9）。
+	// 恰好 32 个字符，按随机字节取模时每个字符的概率相同
+	recoveryCodeAlphabet = "abcdefghijklmnopqrstuvwxyz234567"
+)
+
+var (
+	ErrTwoFactorEnabled     = errors.New("两步验证已开启")
+	ErrTwoFactorNotSetup    = errors.New("请先获取两步验证密钥")
+	ErrTwoFactorNotEnabled  = errors.New("两步验证未开启")
+	ErrInvalidTwoFactorCode = errors.New("验证码错误")
+	ErrInvalidChallenge     = errors.New("验证已过期，请重新登录")
+)
+
+// TwoFactorSetup 开始绑定两步验证时返回给用户的信息
+type TwoFactorSetup struct {
+	Secret string `json:"secret"` // base32 密钥，用于手动输入
+	URI    string `json:"uri"`    // otpauth:// 地址，用于生成二维码
+}
+
+// TwoFactorStatus 两步验证状态
+type TwoFactorStatus struct {
+	Enabled                bool `json:"enabled"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+// TwoFactorService 两步验证服务
+type TwoFactorService struct{}
+
+// Setup 为用户生成新的 TOTP 密钥。用户需要用验证器应用添加后调用 Confirm 才会开启两步验证；
+// 重复调用会生成新的密钥，之前未确认的密钥作废。
+func (s *TwoFactorService) Setup(ctx context.Context, userID uint) (*TwoFactorSetup, error) {
+	user, err := dao.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := dao.UpdateUserTOTPSecret(ctx, userID, secret); err != nil {
+		return nil, err
+	}
+	return &TwoFactorSetup{Secret: secret, URI: totp.URI(totpIssuer, user.Username, secret)}, nil
+}
+
+// Confirm 校验验证码并开启两步验证，返回恢复码明文（只返回这一次）
+func (s *TwoFactorService) Confirm(ctx context.Context, userID uint, code string) ([]string, error) {
+	user, err := dao.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTwoFactorNotSetup
+	}
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := dao.EnableUserTOTP(ctx, userID, step, hashes); err != nil {
+		return nil, err
+	}
+	recordAudit(ctx, &model.AuditLog{Action: model.AuditTwoFactorEnabled, UserID: userID})
+	return codes, nil
+}
+
+// Disable 关闭两步验证，需要同时提供密码和验证码（或恢复码）
+func (s *TwoFactorService) Disable(ctx context.Context, userID uint, password, code string) error {
+	user, err := dao.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+	if !util.CheckPassword(password, user.Password) {
+		return ErrInvalidCredentials
+	}
+	if err := verifyTwoFactorCode(ctx, user, code, true); err != nil {
+		return err
+	}
+	if err := dao.DisableUserTOTP(ctx, userID); err != nil {
+		return err
+	}
+	recordAudit(ctx, &model.AuditLog{Action: model.AuditTwoFactorDisabled, UserID: userID})
+	return nil
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码，之前的恢复码全部作废。需要提供验证器应用中的验证码。
+func (s *TwoFactorService) RegenerateRecoveryCodes(ctx context.Context, userID uint, code string) ([]string, error) {
+	user, err := dao.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TOTPEnabled {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	if err := verifyTwoFactorCode(ctx, user, code, false); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := dao.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	recordAudit(ctx, &model.AuditLog{Action: model.AuditRecoveryCodesRegenerated, UserID: userID})
+	return codes, nil
+}
+
+// Status 查询两步验证状态和剩余的恢复码数量
+func (s *TwoFactorService) Status(ctx context.Context, userID uint) (*TwoFactorStatus, error) {
+	user, err := dao.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	status := &TwoFactorStatus{Enabled: user.TOTPEnabled}
+	if user.TOTPEnabled {
+		codes, err := dao.GetUnusedRecoveryCodes(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		status.RecoveryCodesRemaining = len(codes)
+	}
+	return status, nil
+}
+
+// verifyTwoFactorCode 校验验证码；allowRecovery 为 true 时也接受恢复码，使用后恢复码作废。
+// 校验失败返回 ErrInvalidTwoFactorCode。
+func verifyTwoFactorCode(ctx context.Context, user *model.User, code string, allowRecovery bool) error {
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits {
+		step, ok := totp.Validate(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+		if !ok {
+			return ErrInvalidTwoFactorCode
+		}
+		// 条件更新保证并发提交同一个验证码时只有一次成功
+		updated, err := dao.UpdateUserTOTPLastStep(ctx, user.ID, step)
+		if err != nil {
+			return err
+		}
+		if !updated {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	}
+	if !allowRecovery {
+		return ErrInvalidTwoFactorCode
+	}
+
+	normalized := normalizeRecoveryCode(code)
+	if len(normalized) != recoveryCodeLen {
+		return ErrInvalidTwoFactorCode
+	}
+	codes, err := dao.GetUnusedRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	for _, rc := range codes {
+		if !util.CheckPassword(normalized, rc.CodeHash) {
+			continue
+		}
+		used, err := dao.MarkRecoveryCodeUsed(ctx, rc.ID, time.Now())
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidTwoFactorCode
+		}
+		recordAudit(ctx, &model.AuditLog{
+			Action: model.AuditRecoveryCodeUsed,
+			UserID: user.ID,
+			Detail: fmt.Sprintf("剩余恢复码 %d 个", len(codes)-1),
+		})
+		return nil
+	}
+	return ErrInvalidTwoFactorCode
+}
+
+// generateRecoveryCodes 生成一组恢复码，返回展示给用户的明文（xxxxx-xxxxx 格式）和对应的哈希
+func generateRecoveryCodes() (codes, hashes []string, err error) {
+	for range recoveryCodeCount {
+		b := make([]byte, recoveryCodeLen)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		for i := range b {
+			b[i] = recoveryCodeAlphabet[int(b[i])%len(recoveryCodeAlphabet)]
+		}
+		code := string(b)
+		hash, err := util.HashPassword(code)
+		if err != nil {
+			return nil, nil, err
+		}
+		codes = append(codes, code[:recoveryCodeLen/2]+"-"+code[recoveryCodeLen/2:])
+		hashes = append(hashes, hash)
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode 去掉用户输入的恢复码中的分隔符和空白，并转为小写
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
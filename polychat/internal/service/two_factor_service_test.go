@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"polychat/internal/dao"
+	"polychat/internal/model"
+	"polychat/pkg/totp"
+)
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("生成了 %d 个恢复码、%d 个哈希，期望 %d", len(codes), len(hashes), recoveryCodeCount)
+	}
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		// xxxxx-xxxxx 格式
+		if len(code) != recoveryCodeLen+1 || code[recoveryCodeLen/2] != '-' {
+			t.Fatalf("恢复码 %q 格式错误", code)
+		}
+		normalized := normalizeRecoveryCode(code)
+		for _, c := range normalized {
+			if !strings.ContainsRune(recoveryCodeAlphabet, c) {
+				t.Fatalf("恢复码 %q 包含字符集之外的字符 %q", code, c)
+			}
+		}
+		if seen[normalized] {
+			t.Fatalf("恢复码 %q 重复", code)
+		}
+		seen[normalized] = true
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	for _, input := range []string{"abcde-fghij", "ABCDE-FGHIJ", " abcde fghij ", "abcdefghij"} {
+		if got := normalizeRecoveryCode(input); got != "abcdefghij" {
+			t.Errorf("normalizeRecoveryCode(%q) = %q", input, got)
+		}
+	}
+}
+
+// enableTestTwoFactor 创建开启了两步验证的用户，返回用户和恢复码明文
+func enableTestTwoFactor(t *testing.T) (*model.User, []string) {
+	t.Helper()
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := createTestUser(t, "tfa", func(u *model.User) { u.TOTPSecret = secret })
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 记录的时间步早于当前的验证窗口，窗口内的验证码都未使用过
+	if err := dao.EnableUserTOTP(context.Background(), user.ID, totp.Step(time.Now())-totp.Skew-1, hashes); err != nil {
+		t.Fatal(err)
+	}
+	return reloadUser(t, user.ID), codes
+}
+
+func reloadUser(t *testing.T, userID uint) *model.User {
+	t.Helper()
+	user, err := dao.GetUserByID(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestVerifyTwoFactorCodeReplay(t *testing.T) {
+	setupTestDB(t)
+	ctx := context.Background()
+	user, _ := enableTestTwoFactor(t)
+
+	code, err := totp.Code(user.TOTPSecret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyTwoFactorCode(ctx, user, code, false); err != nil {
+		t.Fatalf("首次使用验证码: %v", err)
+	}
+
+	// 并发提交：user 中的 TOTPLastStep 还是旧值，由条件更新拒绝
+	if err := verifyTwoFactorCode(ctx, user, code, false); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("并发重放验证码: err = %v，期望 ErrInvalidTwoFactorCode", err)
+	}
+	// 之后的请求读到已记录的时间步，由 totp.Validate 拒绝
+	if err := verifyTwoFactorCode(ctx, reloadUser(t, user.ID), code, false); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("重放验证码: err = %v，期望 ErrInvalidTwoFactorCode", err)
+	}
+}
+
+func TestVerifyTwoFactorRecoveryCode(t *testing.T) {
+	setupTestDB(t)
+	ctx := context.Background()
+	user, codes := enableTestTwoFactor(t)
+
+	// 不接受恢复码的场景（例如关闭两步验证）
+	if err := verifyTwoFactorCode(ctx, user, codes[0], false); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("allowRecovery=false 时使用恢复码: err = %v", err)
+	}
+
+	// 用户输入时可能省略分隔符或使用大写
+	if err := verifyTwoFactorCode(ctx, user, strings.ToUpper(strings.ReplaceAll(codes[0], "-", "")), true); err != nil {
+		t.Fatalf("首次使用恢复码: %v", err)
+	}
+	if err := verifyTwoFactorCode(ctx, user, codes[0], true); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("重复使用恢复码: err = %v，期望 ErrInvalidTwoFactorCode", err)
+	}
+	// 其他恢复码不受影响
+	if err := verifyTwoFactorCode(ctx, user, codes[1], true); err != nil {
+		t.Fatalf("使用另一个恢复码: %v", err)
+	}
+
+	remaining, err := dao.GetUnusedRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != recoveryCodeCount-2 {
+		t.Fatalf("剩余 %d 个恢复码，期望 %d", len(remaining), recoveryCodeCount-2)
+	}
+}
@@ -1,22 +1,39 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"log/slog"
 	"polychat/internal/dao"
 	"polychat/internal/model"
+	"polychat/internal/validation"
 	"polychat/pkg/util"
+	"time"
 
 	"gorm.io/gorm"
 )
 
 type UserService struct{}
 
-// 注册
-func (s *UserService) Register(username, password string) error {
+// 注册，email 可以为空；填写了邮箱时发送验证链接。
+// 用户名、密码或邮箱不符合要求时返回 validation.Errors，列出每个字段的错误原因
+func (s *UserService) Register(ctx context.Context, username, password, email string) error {
+	errs := validation.Errors{}
+	errs.Check("username", validation.Username(username))
+	errs.Check("password", validation.Password(password, username))
+	if email != "" {
+		var err error
+		email, err = validation.Email(email)
+		errs.Check("email", err)
+	}
+	if err := errs.Err(); err != nil {
+		return err
+	}
+
 	//检查用户名是否存在
-	_, err := dao.GetUserByUsername(username)
+	_, err := dao.GetUserByUsername(ctx, username)
 	if err == nil {
-		return errors.New("用户名已存在")
+		return errUsernameTaken
 	}
 	if err != nil && err != gorm.ErrRecordNotFound {
 		return err // 其他数据库错误
@@ -32,28 +49,139 @@ func (s *UserService) Register(username, password string) error {
 	user := &model.User{
 		Username: username,
 		Password: hashPassword,
+		Email:    email,
 	}
 
-	return dao.CreateUser(user)
+	if err := dao.CreateUser(ctx, user); err != nil {
+		// 并发注册同一个用户名时，上面的检查都能通过，由唯一索引拒绝后一个
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return errUsernameTaken
+		}
+		return err
+	}
+	if email != "" {
+		return sendVerification(ctx, user)
+	}
+	return nil
 }
 
-// 登录
-func (s *UserService) Login(username, password string) (string, uint, error) {
-	//先查询用户是否存在
-	user, err := dao.GetUserByUsername(username)
-	if err != nil {
-		return "", 0, errors.New("用户名不存在") //用户名不存在
+// errUsernameTaken 用户名已被其他用户使用
+var errUsernameTaken = validation.Field("username", "用户名已存在")
+
+// LoginResult 登录结果。
+// 开启了两步验证的用户密码校验通过后，TwoFactorRequired 为 true，只返回 ChallengeToken，
+// 客户端需要用 ChallengeToken 和验证码调用 VerifyTwoFactor 才能拿到会话 Token。
+type LoginResult struct {
+	Token             string
+	UserID            uint
+	Username          string
+	Role              string
+	TwoFactorRequired bool
+	ChallengeToken    string
+}
+
+// Login 校验用户名和密码，成功时返回会话 token 或两步验证的 challenge token。
+// 用户名不存在和密码错误都返回 ErrInvalidCredentials；
+// 同一用户名或同一IP连续失败过多时返回 *LoginThrottledError，此时不再校验密码。
+func (s *UserService) Login(ctx context.Context, username, password, ip string) (*LoginResult, error) {
+	now := time.Now()
+	subjects := loginSubjects(username, ip)
+	if err := checkLoginAllowed(ctx, subjects, now); err != nil {
+		return nil, err
 	}
 
-	//用户存在的前提下，校验密码
+	user, err := dao.GetUserByUsername(ctx, username)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	if user == nil {
+		// 用户名不存在时同样比对一次密码，使响应时间与密码错误时一致
+		util.CheckPassword(password, dummyPasswordHash())
+		recordLoginFailure(ctx, subjects, 0, ip, now)
+		return nil, ErrInvalidCredentials
+	}
 	if !util.CheckPassword(password, user.Password) {
-		return "", 0, errors.New("密码错误")
+		recordLoginFailure(ctx, subjects, user.ID, ip, now)
+		return nil, ErrInvalidCredentials
 	}
+	// 密码正确之后才检查账号状态，不向不知道密码的人透露账号被停用
+	if err := checkSuspended(user, now); err != nil {
+		return nil, err
+	}
+
+	// 开启了两步验证：失败计数保留到验证码校验通过为止
+	if user.TOTPEnabled {
+		challenge, err := util.GenerateChallengeToken(user.ID, user.TokenVersion)
+		if err != nil {
+			return nil, errors.New("token生成失败")
+		}
+		return &LoginResult{UserID: user.ID, TwoFactorRequired: true, ChallengeToken: challenge}, nil
+	}
+	clearLoginFailures(ctx, username)
+	return issueSession(ctx, user, ip, model.SessionPassword)
+}
 
-	//密码校验通过，返回token
-	token, err := util.GenerateToken(user.ID)
+// VerifyTwoFactor 登录的第二步：校验 challenge token 和验证码（或恢复码），通过后返回会话 token。
+// 验证码错误与密码错误一样计入登录失败次数。
+func (s *UserService) VerifyTwoFactor(ctx context.Context, challengeToken, code, ip string) (*LoginResult, error) {
+	claims, err := util.ParseChallengeToken(challengeToken)
 	if err != nil {
-		return "", 0, errors.New("token生成失败")
+		return nil, ErrInvalidChallenge
+	}
+	user, err := dao.GetUserByID(ctx, claims.UserID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrInvalidChallenge
+		}
+		return nil, err
+	}
+	if !user.TOTPEnabled || user.TokenVersion != claims.TokenVersion {
+		// 签发 challenge 之后关闭了两步验证或修改了密码，要求重新登录
+		return nil, ErrInvalidChallenge
+	}
+
+	now := time.Now()
+	subjects := loginSubjects(user.Username, ip)
+	if err := checkLoginAllowed(ctx, subjects, now); err != nil {
+		return nil, err
+	}
+	if err := verifyTwoFactorCode(ctx, user, code, true); err != nil {
+		if errors.Is(err, ErrInvalidTwoFactorCode) {
+			recordLoginFailure(ctx, subjects, user.ID, ip, now)
+		}
+		return nil, err
+	}
+	clearLoginFailures(ctx, user.Username)
+	return issueSession(ctx, user, ip, model.SessionPassword)
+}
+
+// issueSession 签发会话 token 并记录登录会话，method 为登录方式（见 model.Session* 常量）。
+// 账号被停用或封禁时返回 *AccountSuspendedError。记录会话失败不影响登录。
+func issueSession(ctx context.Context, user *model.User, ip, method string) (*LoginResult, error) {
+	now := time.Now()
+	if err := checkSuspended(user, now); err != nil {
+		return nil, err
+	}
+	role := model.NormalizeRole(user.Role)
+	token, err := util.GenerateToken(user.ID, user.TokenVersion, role)
+	if err != nil {
+		return nil, errors.New("token生成失败")
+	}
+	recordSession(ctx, user.ID, user.TokenVersion, ip, method, now)
+	return &LoginResult{Token: token, UserID: user.ID, Username: user.Username, Role: role}, nil
+}
+
+// recordSession 记录一次签发的会话 token
+func recordSession(ctx context.Context, userID, tokenVersion uint, ip, method string, now time.Time) {
+	session := &model.LoginSession{
+		UserID:       userID,
+		TokenVersion: tokenVersion,
+		Method:       method,
+		IP:           ip,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(util.SessionTTL),
+	}
+	if err := dao.CreateLoginSession(ctx, session); err != nil {
+		slog.ErrorContext(ctx, "记录登录会话失败", "err", err)
 	}
-	return token, user.ID, nil
 }
@@ -0,0 +1,73 @@
+package validation
+
+// 本地泄露密码列表
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"polychat/pkg/config"
+)
+
+// breachedList 泄露密码的 SHA-1（大写十六进制）集合，第一次校验密码时加载
+var breachedList = sync.OnceValue(loadBreachedList)
+
+// loadBreachedList 读取 POLYCHAT_BREACHED_PASSWORDS_FILE 指定的文件，每行一条，支持两种格式：
+//   - 明文密码，如 123456
+//   - SHA-1 哈希，可带出现次数，即 Have I Been Pwned 下载的格式，如 7C4A8D09CA3762AF61E59520943DC26494F8941B:37359195
+//
+// 空行和以 # 开头的行会被忽略。未配置或读取失败时返回空集合，不拒绝任何密码。
+func loadBreachedList() map[string]struct{} {
+	list := map[string]struct{}{}
+	path := config.GetString("POLYCHAT_BREACHED_PASSWORDS_FILE", "")
+	if path == "" {
+		return list
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		slog.Error("读取泄露密码列表失败，不检查泄露密码", "path", path, "err", err)
+		return list
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if hash, _, _ := strings.Cut(line, ":"); isSHA1Hex(hash) {
+			list[strings.ToUpper(hash)] = struct{}{}
+		} else {
+			list[sha1Hex(line)] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		slog.Error("读取泄露密码列表失败，列表可能不完整", "path", path, "err", err)
+	}
+	slog.Info("已加载泄露密码列表", "path", path, "count", len(list))
+	return list
+}
+
+// breached 密码是否出现在泄露密码列表中
+func breached(password string) bool {
+	_, ok := breachedList()[sha1Hex(password)]
+	return ok
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func isSHA1Hex(s string) bool {
+	if len(s) != sha1.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
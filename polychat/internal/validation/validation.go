@@ -0,0 +1,182 @@
+// Package validation 定义用户输入（用户名、密码、邮箱、头像等）的校验规则。
+// 注册、修改资料、修改和重置密码使用同一套规则，校验失败时返回 Errors，按字段给出原因。
+//
+// 配置项：
+//   - POLYCHAT_RESERVED_USERNAMES       额外的保留用户名，逗号分隔，不区分大小写
+//   - POLYCHAT_BREACHED_PASSWORDS_FILE  泄露密码列表文件，设置后拒绝列表中的密码，格式见 breached.go
+package validation
+
+import (
+	"errors"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"polychat/pkg/config"
+)
+
+// Errors 按字段记录的校验错误，键为请求参数的字段名（如 username），值为错误原因
+type Errors map[string]string
+
+func (e Errors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	parts := make([]string, 0, len(fields))
+	for _, field := range fields {
+		parts = append(parts, field+": "+e[field])
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Check 记录字段的校验结果，err 为 nil 时不做任何事；同一字段只保留第一个错误
+func (e Errors) Check(field string, err error) {
+	if err == nil {
+		return
+	}
+	if _, ok := e[field]; !ok {
+		e[field] = err.Error()
+	}
+}
+
+// Err 没有错误时返回 nil，否则返回 e 本身
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Field 返回只包含一个字段错误的 Errors
+func Field(field, msg string) Errors {
+	return Errors{field: msg}
+}
+
+const (
+	// 用户名长度（按字符计），上限与 users.username 列的 varchar(20) 一致
+	usernameMinLen = 3
+	usernameMaxLen = 20
+	// 密码长度，bcrypt 只使用前 72 个字节，更长的部分不参与校验
+	passwordMinLen   = 8
+	passwordMaxBytes = 72
+	// emailMaxLen 与 users.email 列的 varchar(100) 一致
+	emailMaxLen = 100
+	// avatarMaxLen 与 users.avatar 列的 varchar(255) 一致
+	avatarMaxLen = 255
+)
+
+// usernamePattern 用户名以字母（包括中文等各国文字）开头，只包含字母、数字和下划线
+var usernamePattern = regexp.MustCompile(`^\pL[\pL\pN_]*$`)
+
+// reservedUsernames 保留用户名，容易被用来冒充管理员或与路由、客户端的特殊值混淆
+var reservedUsernames = loadReservedUsernames()
+
+func loadReservedUsernames() map[string]bool {
+	names := map[string]bool{}
+	for _, n := range []string{
+		"admin", "administrator", "root", "system", "polychat", "support", "help",
+		"official", "moderator", "security", "service", "staff", "null", "undefined", "me",
+	} {
+		names[n] = true
+	}
+	for _, n := range strings.Split(config.GetString("POLYCHAT_RESERVED_USERNAMES", ""), ",") {
+		if n = strings.ToLower(strings.TrimSpace(n)); n != "" {
+			names[n] = true
+		}
+	}
+	return names
+}
+
+// Username 校验用户名：3~20 个字符，以字母开头，只包含字母、数字和下划线，不能是保留名
+func Username(username string) error {
+	n := utf8.RuneCountInString(username)
+	switch {
+	case username == "":
+		return errors.New("用户名不能为空")
+	case n < usernameMinLen || n > usernameMaxLen:
+		return errors.New("用户名长度应为 3~20 个字符")
+	case !usernamePattern.MatchString(username):
+		return errors.New("用户名必须以字母开头，只能包含字母、数字和下划线")
+	case reservedUsernames[strings.ToLower(username)]:
+		return errors.New("该用户名为系统保留，请换一个")
+	}
+	return nil
+}
+
+// Password 校验密码强度：至少 8 个字符、不超过 72 字节，至少包含字母、数字、符号中的两类，
+// 不能包含用户名，也不能出现在泄露密码列表中。username 为空时不检查是否包含用户名。
+func Password(password, username string) error {
+	if password == "" {
+		return errors.New("密码不能为空")
+	}
+	if utf8.RuneCountInString(password) < passwordMinLen {
+		return errors.New("密码至少需要 8 个字符")
+	}
+	if len(password) > passwordMaxBytes {
+		return errors.New("密码不能超过 72 个字节")
+	}
+
+	var letter, digit, other bool
+	for _, r := range password {
+		switch {
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < utf8.RuneSelf && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'):
+			letter = true
+		default:
+			other = true
+		}
+	}
+	classes := 0
+	for _, ok := range []bool{letter, digit, other} {
+		if ok {
+			classes++
+		}
+	}
+	if classes < 2 {
+		return errors.New("密码至少需要包含字母、数字、符号中的两类")
+	}
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return errors.New("密码不能包含用户名")
+	}
+	if breached(password) {
+		return errors.New("该密码出现在已泄露的密码列表中，请换一个")
+	}
+	return nil
+}
+
+// Email 校验邮箱格式，返回去掉首尾空白后的邮箱。只接受不带显示名的地址（如 alice@example.com）。
+func Email(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return "", errors.New("邮箱不能为空")
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", errors.New("邮箱格式错误")
+	}
+	if len(email) > emailMaxLen {
+		return "", errors.New("邮箱不能超过 100 个字符")
+	}
+	return email, nil
+}
+
+// Avatar 校验头像地址：为空表示不设置头像，否则必须是 http 或 https 的绝对地址
+func Avatar(avatar string) error {
+	if avatar == "" {
+		return nil
+	}
+	if len(avatar) > avatarMaxLen {
+		return errors.New("头像地址不能超过 255 个字符")
+	}
+	u, err := url.Parse(avatar)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("头像地址必须是 http 或 https 链接")
+	}
+	return nil
+}
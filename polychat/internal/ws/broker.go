@@ -0,0 +1,121 @@
+package ws
+
+// 节点间消息路由
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// 节点间投递的指令类型
+const (
+	EnvelopeMessage    = "message"    // 把 Message 推送给 UserID 在该节点上的连接
+	EnvelopeKick       = "kick"       // 关闭 UserID 在该节点上的连接（用户在其他节点重新登录）
+	EnvelopeDisconnect = "disconnect" // 关闭 UserID 在该节点上的连接并按下线处理（例如登录凭证被吊销）
+	// EnvelopeDoNotDisturb 更新 UserID 在该节点上的连接的免打扰状态
+	EnvelopeDoNotDisturb = "do_not_disturb"
+)
+
+// Envelope 节点之间投递的指令
+type Envelope struct {
+	Kind    string  `json:"kind"`
+	UserID  uint    `json:"user_id"`
+	Message Message `json:"message"`
+	Reason  string  `json:"reason,omitempty"` // disconnect 指令中发给客户端的关闭原因
+	// DoNotDisturb do_not_disturb 指令中的免打扰状态
+	DoNotDisturb bool `json:"do_not_disturb,omitempty"`
+}
+
+// Broker 在多个 polychat 节点之间路由消息并维护集群范围的在线状态。
+// 每个节点持有一部分用户的 WebSocket 连接，ClientManager 在目标用户不在本节点时，
+// 通过 Broker 查询其所在节点并把消息投递过去。
+type Broker interface {
+	// SetOnline 登记用户连接在 nodeID 上，返回之前登记的节点（不在线时为空字符串）
+	SetOnline(userID uint, nodeID string) (string, error)
+	// SetOffline 取消用户在 nodeID 上的登记；如果用户已经登记到其他节点则不做任何修改
+	SetOffline(userID uint, nodeID string) error
+	// Lookup 查询用户所在节点，不在线时返回空字符串
+	Lookup(userID uint) (string, error)
+	// Publish 把指令投递给 nodeID 节点
+	Publish(nodeID string, env Envelope) error
+	// Subscribe 开始接收投递给 nodeID 节点的指令，handler 可能在其他协程中被调用
+	Subscribe(nodeID string, handler func(Envelope)) error
+	// Ping 检查 Broker 是否可用，用于就绪检查
+	Ping(ctx context.Context) error
+	// Close 停止订阅并释放资源
+	Close() error
+}
+
+// MemoryBroker 单节点部署使用的进程内 Broker
+type MemoryBroker struct {
+	mu       sync.RWMutex
+	presence map[uint]string
+	handlers map[string]func(Envelope)
+}
+
+// NewMemoryBroker 创建进程内 Broker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		presence: make(map[uint]string),
+		handlers: make(map[string]func(Envelope)),
+	}
+}
+
+func (b *MemoryBroker) SetOnline(userID uint, nodeID string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	prev := b.presence[userID]
+	b.presence[userID] = nodeID
+	return prev, nil
+}
+
+func (b *MemoryBroker) SetOffline(userID uint, nodeID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.presence[userID] == nodeID {
+		delete(b.presence, userID)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Lookup(userID uint) (string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.presence[userID], nil
+}
+
+func (b *MemoryBroker) Publish(nodeID string, env Envelope) error {
+	b.mu.RLock()
+	handler, ok := b.handlers[nodeID]
+	b.mu.RUnlock()
+	if ok {
+		handler(env)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(nodeID string, handler func(Envelope)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[nodeID] = handler
+	return nil
+}
+
+func (b *MemoryBroker) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (b *MemoryBroker) Close() error {
+	return nil
+}
+
+// DefaultNodeID 生成默认的节点ID：主机名-进程号。
+// 可以通过环境变量 POLYCHAT_NODE_ID 显式指定。
+func DefaultNodeID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "polychat"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
@@ -0,0 +1,229 @@
+package ws
+
+// 基于 Redis 的节点间消息路由
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisPresencePrefix 在线状态键前缀，值为用户所在节点ID
+	redisPresencePrefix = "polychat:presence:"
+	// redisNodeChannelPrefix 节点订阅频道前缀
+	redisNodeChannelPrefix = "polychat:node:"
+	// redisPresenceTTL 在线状态键的过期时间。
+	// 节点定期刷新本节点用户的在线状态，节点崩溃后其用户在 TTL 之后自动视为离线。
+	redisPresenceTTL = 90 * time.Second
+	// redisRefreshInterval 在线状态刷新间隔
+	redisRefreshInterval = 30 * time.Second
+	// redisOpTimeout 单次 Redis 操作超时时间
+	redisOpTimeout = 3 * time.Second
+)
+
+// redisSetOfflineScript 仅当在线状态仍指向当前节点时才删除，防止误删用户在其他节点的新登记
+var redisSetOfflineScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// redisRefreshScript 刷新本节点用户的在线状态：KEYS 为在线状态键，ARGV[1] 为节点ID，ARGV[2] 为过期时间（毫秒）。
+// 键仍指向本节点时只延长过期时间，键不存在时重新登记；键已指向其他节点说明用户已在其他节点重新登录，
+// 不做修改，返回这些键的下标（从 1 开始）。
+var redisRefreshScript = redis.NewScript(`
+local moved = {}
+for i, key in ipairs(KEYS) do
+	local current = redis.call("GET", key)
+	if current == ARGV[1] then
+		redis.call("PEXPIRE", key, ARGV[2])
+	elseif not current then
+		redis.call("SET", key, ARGV[1], "PX", ARGV[2])
+	else
+		table.insert(moved, i)
+	end
+end
+return moved`)
+
+// redisRefreshBatch 每次刷新脚本处理的最大用户数，避免单个脚本长时间阻塞 Redis
+const redisRefreshBatch = 500
+
+// RedisBroker 基于 Redis Pub/Sub 的 Broker，用于多节点部署。
+//   - 在线状态：polychat:presence:<userID> = <nodeID>，带过期时间并定期刷新
+//   - 消息投递：每个节点订阅 polychat:node:<nodeID> 频道
+type RedisBroker struct {
+	client *redis.Client
+
+	mu    sync.Mutex
+	local map[uint]string // 本节点登记的用户，用于定期刷新在线状态
+	stop  chan struct{}
+	sub   *redis.PubSub
+}
+
+// NewRedisBroker 基于已建立的 Redis 连接创建 Broker
+func NewRedisBroker(client *redis.Client) *RedisBroker {
+	b := &RedisBroker{
+		client: client,
+		local:  make(map[uint]string),
+		stop:   make(chan struct{}),
+	}
+	go b.refreshLoop()
+	return b
+}
+
+func presenceKey(userID uint) string {
+	return redisPresencePrefix + strconv.FormatUint(uint64(userID), 10)
+}
+
+func (b *RedisBroker) SetOnline(userID uint, nodeID string) (string, error) {
+	b.mu.Lock()
+	b.local[userID] = nodeID
+	b.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+	prev, err := b.client.SetArgs(ctx, presenceKey(userID), nodeID, redis.SetArgs{
+		TTL: redisPresenceTTL,
+		Get: true,
+	}).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return prev, err
+}
+
+func (b *RedisBroker) SetOffline(userID uint, nodeID string) error {
+	b.mu.Lock()
+	if b.local[userID] == nodeID {
+		delete(b.local, userID)
+	}
+	b.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+	return redisSetOfflineScript.Run(ctx, b.client, []string{presenceKey(userID)}, nodeID).Err()
+}
+
+func (b *RedisBroker) Lookup(userID uint) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+	nodeID, err := b.client.Get(ctx, presenceKey(userID)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return nodeID, err
+}
+
+func (b *RedisBroker) Publish(nodeID string, env Envelope) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+	return b.client.Publish(ctx, redisNodeChannelPrefix+nodeID, data).Err()
+}
+
+func (b *RedisBroker) Subscribe(nodeID string, handler func(Envelope)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	sub := b.client.Subscribe(context.Background(), redisNodeChannelPrefix+nodeID)
+	// 等待订阅确认，确保返回后不会丢失投递
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return err
+	}
+
+	b.mu.Lock()
+	b.sub = sub
+	b.mu.Unlock()
+
+	go func() {
+		for msg := range sub.Channel() {
+			var env Envelope
+			if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
+				slog.Error("解析节点间消息失败", "err", err)
+				continue
+			}
+			handler(env)
+		}
+	}()
+	return nil
+}
+
+func (b *RedisBroker) Ping(ctx context.Context) error {
+	return b.client.Ping(ctx).Err()
+}
+
+func (b *RedisBroker) Close() error {
+	close(b.stop)
+	b.mu.Lock()
+	sub := b.sub
+	b.mu.Unlock()
+	if sub != nil {
+		return sub.Close()
+	}
+	return nil
+}
+
+// refreshLoop 定期刷新本节点用户的在线状态，防止在线状态键过期
+func (b *RedisBroker) refreshLoop() {
+	ticker := time.NewTicker(redisRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+		}
+		b.refresh()
+	}
+}
+
+// refresh 刷新本节点登记的全部用户的在线状态。
+// 已在其他节点重新登录的用户从本节点的登记中移除，不会覆盖其他节点的登记。
+func (b *RedisBroker) refresh() {
+	b.mu.Lock()
+	byNode := make(map[string][]uint)
+	for userID, nodeID := range b.local {
+		byNode[nodeID] = append(byNode[nodeID], userID)
+	}
+	b.mu.Unlock()
+
+	for nodeID, userIDs := range byNode {
+		for start := 0; start < len(userIDs); start += redisRefreshBatch {
+			end := min(start+redisRefreshBatch, len(userIDs))
+			b.refreshBatch(nodeID, userIDs[start:end])
+		}
+	}
+}
+
+func (b *RedisBroker) refreshBatch(nodeID string, userIDs []uint) {
+	keys := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		keys[i] = presenceKey(userID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+	moved, err := redisRefreshScript.Run(ctx, b.client, keys, nodeID, redisPresenceTTL.Milliseconds()).Int64Slice()
+	if err != nil {
+		slog.Error("刷新在线状态失败", "users", len(userIDs), "err", err)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, i := range moved {
+		userID := userIDs[i-1]
+		if b.local[userID] == nodeID {
+			delete(b.local, userID)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 以下测试需要本地 Redis，通过环境变量 POLYCHAT_TEST_REDIS 指定地址（例如 127.0.0.1:6379），未设置时跳过。
+// 测试只读写本测试生成的用户和节点对应的键。
+
+// newTestRedisClient 连接测试用的 Redis，未配置时跳过测试
+func newTestRedisClient(t *testing.T) *redis.Client {
+	t.Helper()
+	addr := os.Getenv("POLYCHAT_TEST_REDIS")
+	if addr == "" {
+		t.Skip("未设置 POLYCHAT_TEST_REDIS，跳过 Redis 测试")
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() { client.Close() })
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Fatalf("连接 Redis 失败: %v", err)
+	}
+	return client
+}
+
+func newTestRedisBroker(t *testing.T, client *redis.Client) *RedisBroker {
+	t.Helper()
+	b := NewRedisBroker(client)
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+// testUserID 生成本次测试使用的用户ID，并在测试结束后删除其在线状态键
+func testUserID(t *testing.T, client *redis.Client) uint {
+	t.Helper()
+	userID := uint(time.Now().UnixNano()%1_000_000_000) + 1_000_000_000
+	t.Cleanup(func() { client.Del(context.Background(), presenceKey(userID)) })
+	return userID
+}
+
+// testNodeID 生成本次测试使用的节点ID
+func testNodeID(name string) string {
+	return fmt.Sprintf("test-%s-%d", name, time.Now().UnixNano())
+}
+
+func TestRedisBrokerPresence(t *testing.T) {
+	client := newTestRedisClient(t)
+	b := newTestRedisBroker(t, client)
+	userID := testUserID(t, client)
+	nodeA, nodeB := testNodeID("a"), testNodeID("b")
+
+	prev, err := b.SetOnline(userID, nodeA)
+	if err != nil || prev != "" {
+		t.Fatalf("首次登记: prev=%q err=%v", prev, err)
+	}
+	prev, err = b.SetOnline(userID, nodeB)
+	if err != nil || prev != nodeA {
+		t.Fatalf("切换节点: prev=%q err=%v，期望 %s", prev, err, nodeA)
+	}
+	if ttl := client.PTTL(context.Background(), presenceKey(userID)).Val(); ttl <= 0 || ttl > redisPresenceTTL {
+		t.Fatalf("在线状态键的过期时间为 %v", ttl)
+	}
+
+	// 旧节点取消登记不能删除新节点的登记
+	if err := b.SetOffline(userID, nodeA); err != nil {
+		t.Fatal(err)
+	}
+	if node, err := b.Lookup(userID); err != nil || node != nodeB {
+		t.Fatalf("旧节点取消登记后 Lookup = %q, %v，期望 %s", node, err, nodeB)
+	}
+
+	if err := b.SetOffline(userID, nodeB); err != nil {
+		t.Fatal(err)
+	}
+	if node, err := b.Lookup(userID); err != nil || node != "" {
+		t.Fatalf("取消登记后 Lookup = %q, %v，期望为空", node, err)
+	}
+}
+
+func TestRedisBrokerPublish(t *testing.T) {
+	client := newTestRedisClient(t)
+	a := newTestRedisBroker(t, client)
+	b := newTestRedisBroker(t, client)
+	nodeA, nodeB := testNodeID("a"), testNodeID("b")
+
+	received := make(chan Envelope, 1)
+	if err := b.Subscribe(nodeB, func(env Envelope) { received <- env }); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Subscribe(nodeA, func(env Envelope) { t.Errorf("%s 收到了投递给 %s 的指令: %+v", nodeA, nodeB, env) }); err != nil {
+		t.Fatal(err)
+	}
+
+	env := Envelope{Kind: EnvelopeMessage, UserID: 42, Message: Message{Type: TypeChat, SenderID: 1, ReceiverID: 42, Content: "hi"}}
+	if err := a.Publish(nodeB, env); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-received:
+		if got.Kind != env.Kind || got.UserID != env.UserID || got.Message.Content != "hi" {
+			t.Fatalf("收到 %+v，期望 %+v", got, env)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("等待投递超时")
+	}
+}
+
+func TestRedisBrokerRefresh(t *testing.T) {
+	client := newTestRedisClient(t)
+	ctx := context.Background()
+	a := newTestRedisBroker(t, client)
+	b := newTestRedisBroker(t, client)
+	nodeA, nodeB := testNodeID("a"), testNodeID("b")
+	kept, moved, expired := testUserID(t, client), testUserID(t, client), testUserID(t, client)
+
+	for _, userID := range []uint{kept, moved, expired} {
+		if _, err := a.SetOnline(userID, nodeA); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// moved 已在节点 B 重新登录，expired 的键已过期
+	if _, err := b.SetOnline(moved, nodeB); err != nil {
+		t.Fatal(err)
+	}
+	client.Del(ctx, presenceKey(expired))
+	client.PExpire(ctx, presenceKey(kept), time.Second)
+
+	a.refresh()
+
+	if node, _ := a.Lookup(kept); node != nodeA {
+		t.Fatalf("kept 的登记为 %q，期望 %s", node, nodeA)
+	}
+	if ttl := client.PTTL(ctx, presenceKey(kept)).Val(); ttl <= time.Second {
+		t.Fatalf("刷新后 kept 的过期时间为 %v，没有延长", ttl)
+	}
+	if node, _ := a.Lookup(expired); node != nodeA {
+		t.Fatalf("过期的登记没有恢复: %q", node)
+	}
+	// 不能覆盖其他节点的登记，并且本节点不再刷新该用户
+	if node, _ := a.Lookup(moved); node != nodeB {
+		t.Fatalf("moved 的登记被改为 %q，期望 %s", node, nodeB)
+	}
+	a.mu.Lock()
+	_, stillLocal := a.local[moved]
+	a.mu.Unlock()
+	if stillLocal {
+		t.Fatal("已在其他节点登录的用户仍留在本节点的刷新列表中")
+	}
+}
+
+// TestRedisCrossNodeReconnect 用户从节点 A 切换到节点 B 后，A 上旧连接的注销和在线状态刷新都不能把用户登记回 A
+func TestRedisCrossNodeReconnect(t *testing.T) {
+	client := newTestRedisClient(t)
+	brokerA := newTestRedisBroker(t, client)
+	brokerB := newTestRedisBroker(t, client)
+	cmA := NewClientManager(testNodeID("a"), brokerA)
+	cmB := NewClientManager(testNodeID("b"), brokerB)
+	userID := testUserID(t, client)
+
+	connA, _ := newTestConn(t)
+	cmA.Register(context.Background(), userID, connA, "127.0.0.1")
+	connB, _ := newTestConn(t)
+	cmB.Register(context.Background(), userID, connB, "127.0.0.1")
+
+	waitFor(t, "节点 A 关闭旧连接", func() bool {
+		cmA.Lock.RLock()
+		defer cmA.Lock.RUnlock()
+		_, ok := cmA.Clients[userID]
+		return !ok
+	})
+	// 旧连接的读协程退出
+	cmA.UnRegister(userID, connA)
+	brokerA.refresh()
+
+	if node, err := brokerA.Lookup(userID); err != nil || node != cmB.NodeID {
+		t.Fatalf("Lookup = %q, %v，期望 %s", node, err, cmB.NodeID)
+	}
+	brokerA.mu.Lock()
+	_, stillLocal := brokerA.local[userID]
+	brokerA.mu.Unlock()
+	if stillLocal {
+		t.Fatal("节点 A 仍在刷新已切换到节点 B 的用户")
+	}
+}
@@ -0,0 +1,126 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestMemoryBrokerPresence(t *testing.T) {
+	b := NewMemoryBroker()
+
+	prev, err := b.SetOnline(1, "node-a")
+	if err != nil || prev != "" {
+		t.Fatalf("首次登记: prev=%q err=%v", prev, err)
+	}
+	prev, err = b.SetOnline(1, "node-b")
+	if err != nil || prev != "node-a" {
+		t.Fatalf("切换节点: prev=%q err=%v，期望 node-a", prev, err)
+	}
+
+	// 旧节点取消登记不能删除新节点的登记
+	if err := b.SetOffline(1, "node-a"); err != nil {
+		t.Fatal(err)
+	}
+	if node, _ := b.Lookup(1); node != "node-b" {
+		t.Fatalf("旧节点取消登记后 Lookup = %q，期望 node-b", node)
+	}
+
+	if err := b.SetOffline(1, "node-b"); err != nil {
+		t.Fatal(err)
+	}
+	if node, _ := b.Lookup(1); node != "" {
+		t.Fatalf("取消登记后 Lookup = %q，期望为空", node)
+	}
+}
+
+func TestMemoryBrokerPublish(t *testing.T) {
+	b := NewMemoryBroker()
+	var got []Envelope
+	if err := b.Subscribe("node-a", func(env Envelope) { got = append(got, env) }); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Publish("node-a", Envelope{Kind: EnvelopeKick, UserID: 7}); err != nil {
+		t.Fatal(err)
+	}
+	// 投递给没有订阅的节点时直接丢弃
+	if err := b.Publish("node-b", Envelope{Kind: EnvelopeKick, UserID: 8}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Kind != EnvelopeKick || got[0].UserID != 7 {
+		t.Fatalf("收到 %+v，期望只收到投递给 node-a 的指令", got)
+	}
+}
+
+func TestClientManagerLocalDelivery(t *testing.T) {
+	cm := NewClientManager("local", NewMemoryBroker())
+	var events []bool
+	cm.OnPresenceChange = func(ctx context.Context, userID uint, online bool) { events = append(events, online) }
+
+	server, client := newTestConn(t)
+	cm.Register(context.Background(), 1, server, "127.0.0.1")
+	if !cm.IsUserOnline(1) {
+		t.Fatal("注册后用户应在线")
+	}
+
+	cm.SendMessage(Message{Type: TypeChat, SenderID: 2, ReceiverID: 1, Content: "hi"})
+	var msg Message
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := client.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Content != "hi" {
+		t.Fatalf("收到 %+v", msg)
+	}
+
+	cm.UnRegister(1, server)
+	if cm.IsUserOnline(1) {
+		t.Fatal("注销后用户应离线")
+	}
+	if len(events) != 2 || !events[0] || events[1] {
+		t.Fatalf("上下线回调 %v，期望 [true false]", events)
+	}
+}
+
+// newTestConn 建立一个 WebSocket 连接，返回服务端和客户端的连接
+func newTestConn(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	serverConn := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		serverConn <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	server := <-serverConn
+	t.Cleanup(func() { server.Close() })
+	return server, client
+}
+
+// waitFor 等待 cond 成立，超时则测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待超时: %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,110 @@
+package ws
+
+// 单个连接的发送队列
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// outboxSize 每个连接发送队列的容量，队列满说明客户端消费过慢，连接会被关闭
+	outboxSize = 256
+	// writeWait 单次写入的超时时间
+	writeWait = 10 * time.Second
+)
+
+// Client 一个 WebSocket 连接及其发送队列。
+// gorilla/websocket 不允许并发写同一个连接，因此所有写操作都通过发送队列交给唯一的写协程完成。
+type Client struct {
+	UserID uint
+	Conn   *websocket.Conn
+	// ClientIP 客户端IP（经过可信代理时为代理转发的原始IP），ConnectedAt 连接建立的时间
+	ClientIP    string
+	ConnectedAt time.Time
+
+	// ctx 连接的 context，携带 conn_id、user_id 等日志字段，连接存续期间有效
+	ctx context.Context
+
+	mu        sync.Mutex
+	outbox    chan Message
+	closed    bool
+	closeCode int
+	closeText string
+	done      chan struct{} // 写协程退出后关闭
+}
+
+// newClient 创建连接并启动写协程，onWriteError 在写入失败时调用
+func newClient(ctx context.Context, userID uint, conn *websocket.Conn, clientIP string, onWriteError func(*Client, error)) *Client {
+	c := &Client{
+		UserID:      userID,
+		Conn:        conn,
+		ClientIP:    clientIP,
+		ConnectedAt: time.Now(),
+		ctx:         ctx,
+		outbox:      make(chan Message, outboxSize),
+		closeCode:   websocket.CloseNormalClosure,
+		done:        make(chan struct{}),
+	}
+	go c.writePump(onWriteError)
+	return c
+}
+
+// enqueue 把消息放入发送队列，连接已关闭或队列已满时返回 false
+func (c *Client) enqueue(msg Message) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	select {
+	case c.outbox <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close 关闭发送队列。写协程会先发送完队列中剩余的消息，
+// 再向客户端发送带 code 的 close 帧并关闭底层连接。重复调用无效。
+func (c *Client) Close(code int, text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.closeCode = code
+	c.closeText = text
+	close(c.outbox)
+}
+
+// Done 返回写协程退出（连接已完全关闭）时关闭的通道
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// writePump 写协程：依次写出发送队列中的消息，队列关闭后发送 close 帧
+func (c *Client) writePump(onWriteError func(*Client, error)) {
+	defer close(c.done)
+	defer c.Conn.Close()
+
+	for msg := range c.outbox {
+		c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := c.Conn.WriteJSON(msg); err != nil {
+			// 连接已断开：丢弃剩余消息，由回调清理连接
+			onWriteError(c, err)
+			c.Close(websocket.CloseAbnormalClosure, "")
+			for range c.outbox {
+			}
+			return
+		}
+	}
+
+	c.mu.Lock()
+	code, text := c.closeCode, c.closeText
+	c.mu.Unlock()
+	c.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(writeWait))
+}
@@ -2,82 +2,421 @@ package ws
 
 //管理所有连接
 import (
-	"fmt"
+	"context"
+	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"polychat/pkg/metrics"
 
 	"github.com/gorilla/websocket"
 )
 
 // ClientManager 客户端管理器
+// 每个节点只持有连接到本节点的用户；目标用户连接在其他节点时，通过 Broker 投递到对应节点。
 type ClientManager struct {
-	Clients map[uint]*websocket.Conn //存储所有的连接，key为UserID，value为连接
-	Lock    sync.RWMutex             // 读写锁，保护Clients map的并发访问
+	Clients map[uint]*Client //存储本节点的连接，key为UserID，value为连接
+	Lock    sync.RWMutex     // 读写锁，保护Clients map的并发访问
+
+	// OnPresenceChange 用户上线/下线时的回调（在锁外同步调用），为 nil 时不回调。
+	// 同一用户重新连接（替换旧连接，包括从其他节点切换过来）不会触发回调。
+	// ctx 携带该连接的 conn_id 等日志字段；下线回调中的 ctx 不会随连接断开而取消。
+	OnPresenceChange func(ctx context.Context, userID uint, online bool)
+
+	// DoNotDisturb 开启了免打扰的本节点在线用户，这些用户不会收到通知类消息（受 Lock 保护）。
+	// 只记录连接在本节点上的用户，连接注销时删除
+	DoNotDisturb map[uint]bool
+	// LoadDoNotDisturb 注册连接时查询用户是否开启了免打扰，为 nil 时视为未开启。
+	// 每次注册（包括重新连接和从其他节点切换过来）都会查询，免打扰状态跟随连接所在节点
+	LoadDoNotDisturb func(ctx context.Context, userID uint) bool
+
+	// NodeID 当前节点ID，在集群中唯一
+	NodeID string
+	// Broker 节点间消息路由和集群在线状态
+	Broker Broker
+
+	// draining 服务正在关闭，不再接受新连接
+	draining atomic.Bool
+}
+
+// 全局唯一的客户端管理器实例，默认使用单节点的进程内 Broker
+var ClientMgr = NewClientManager("local", NewMemoryBroker())
+
+// NewClientManager 创建客户端管理器并订阅投递给本节点的消息
+func NewClientManager(nodeID string, broker Broker) *ClientManager {
+	cm := &ClientManager{
+		Clients:      make(map[uint]*Client),
+		DoNotDisturb: make(map[uint]bool),
+	}
+	if err := cm.UseBroker(nodeID, broker); err != nil {
+		panic("初始化消息路由失败: " + err.Error())
+	}
+	return cm
 }
 
-// 全局唯一的客户端管理器实例
-var ClientMgr = &ClientManager{
-	Clients: make(map[uint]*websocket.Conn),
+// UseBroker 切换节点ID和 Broker，应在服务开始接受连接之前调用
+func (cm *ClientManager) UseBroker(nodeID string, broker Broker) error {
+	if err := broker.Subscribe(nodeID, cm.handleEnvelope); err != nil {
+		return err
+	}
+	cm.NodeID = nodeID
+	cm.Broker = broker
+	return nil
 }
 
-// Register 新连接注册方法
-// 如果用户已有旧连接（例如从另一个设备登录），先关闭旧连接再注册新连接
-func (cm *ClientManager) Register(userID uint, conn *websocket.Conn) {
+// SetDoNotDisturb 设置在线用户的免打扰状态。
+// 用户连接在其他节点上时，通知该节点更新；用户不在线时不做任何事，下次注册连接时由 LoadDoNotDisturb 恢复。
+func (cm *ClientManager) SetDoNotDisturb(userID uint, on bool) {
+	if cm.setDoNotDisturbLocal(userID, on) {
+		return
+	}
+	nodeID, err := cm.Broker.Lookup(userID)
+	if err != nil {
+		slog.Error("查询用户所在节点失败", "user_id", userID, "err", err)
+		return
+	}
+	if nodeID == "" || nodeID == cm.NodeID {
+		return
+	}
+	if err := cm.Broker.Publish(nodeID, Envelope{Kind: EnvelopeDoNotDisturb, UserID: userID, DoNotDisturb: on}); err != nil {
+		slog.Error("通知其他节点更新免打扰状态失败", "user_id", userID, "node_id", nodeID, "err", err)
+	}
+}
+
+// setDoNotDisturbLocal 设置本节点上用户连接的免打扰状态，返回用户是否连接在本节点
+func (cm *ClientManager) setDoNotDisturbLocal(userID uint, on bool) bool {
 	cm.Lock.Lock()
 	defer cm.Lock.Unlock()
+	if _, ok := cm.Clients[userID]; !ok {
+		return false
+	}
+	if on {
+		cm.DoNotDisturb[userID] = true
+	} else {
+		delete(cm.DoNotDisturb, userID)
+	}
+	return true
+}
+
+// Register 新连接注册方法
+// 如果用户已有旧连接（例如从另一个设备登录），先关闭旧连接再注册新连接；
+// 旧连接在其他节点上时，通知该节点关闭连接。
+// ctx 在连接的整个生命周期内使用，应携带 conn_id 等日志字段，不随 HTTP 请求结束而取消，
+// 并在连接断开时取消，以中止该连接上仍在执行的操作。clientIP 仅用于管理员查看连接信息。
+func (cm *ClientManager) Register(ctx context.Context, userID uint, conn *websocket.Conn, clientIP string) {
+	client := newClient(ctx, userID, conn, clientIP, cm.onWriteError)
+	if cm.Draining() {
+		client.Close(websocket.CloseGoingAway, "server shutting down")
+		return
+	}
 
+	cm.Lock.Lock()
 	// 如果已存在旧连接，先关闭它，防止产生僵尸连接导致在线状态误判
-	if oldConn, ok := cm.Clients[userID]; ok {
-		oldConn.Close()
-		fmt.Printf("用户 %d 旧连接已关闭（重新连接）\n", userID)
+	oldClient, reconnect := cm.Clients[userID]
+	if reconnect {
+		oldClient.Close(websocket.CloseNormalClosure, "replaced by new connection")
+	}
+
+	cm.Clients[userID] = client
+	cm.Lock.Unlock()
+	if reconnect {
+		slog.InfoContext(oldClient.ctx, "旧连接已被新连接替换")
 	}
+	slog.InfoContext(ctx, "连接已注册", "reconnect", reconnect)
 
-	cm.Clients[userID] = conn
-	fmt.Printf("用户已经上线 %d\n", userID)
+	metrics.WSRegistrations.Inc()
+	if !reconnect {
+		metrics.WSConnections.Inc()
+	}
+
+	// 在集群中登记用户所在节点
+	prevNode, err := cm.Broker.SetOnline(userID, cm.NodeID)
+	if err != nil {
+		slog.ErrorContext(ctx, "登记在线状态失败", "err", err)
+	}
+	if prevNode != "" && prevNode != cm.NodeID {
+		reconnect = true
+		if err := cm.Broker.Publish(prevNode, Envelope{Kind: EnvelopeKick, UserID: userID}); err != nil {
+			slog.ErrorContext(ctx, "通知其他节点关闭旧连接失败", "node_id", prevNode, "err", err)
+		}
+	}
+	// 登记之后再查询免打扰设置：之后修改免打扰的请求都能查到本节点，由 SetDoNotDisturb 转发过来
+	if cm.LoadDoNotDisturb != nil {
+		cm.setDoNotDisturbLocal(userID, cm.LoadDoNotDisturb(ctx, userID))
+	}
+
+	if !reconnect {
+		cm.notifyPresence(ctx, userID, true)
+	}
 }
 
 // UnRegister 连接注销方法
-func (cm *ClientManager) UnRegister(userID uint) {
-	cm.Lock.Lock()
-	defer cm.Lock.Unlock()
-
-	if conn, ok := cm.Clients[userID]; ok {
+// 只有 conn 仍是该用户当前的连接时才注销，避免旧连接的读协程退出时把重新连接后的新连接注销掉
+func (cm *ClientManager) UnRegister(userID uint, conn *websocket.Conn) {
+	if !cm.removeConn(userID, conn) {
+		// 已被替换或清理的连接，确保底层连接关闭
 		conn.Close()
+	}
+}
+
+// onWriteError 写协程写入失败时的回调
+func (cm *ClientManager) onWriteError(c *Client, err error) {
+	slog.WarnContext(c.ctx, "写入消息失败，清理断开的连接", "err", err)
+	cm.removeConn(c.UserID, c.Conn)
+}
+
+// removeConn 从本节点移除连接并取消集群登记，用户在其他节点没有连接时触发下线回调。
+// 只有 conn 仍是该用户当前的连接时才移除，返回是否移除。
+func (cm *ClientManager) removeConn(userID uint, conn *websocket.Conn) bool {
+	cm.Lock.Lock()
+	current, ok := cm.Clients[userID]
+	removed := ok && current.Conn == conn
+	if removed {
 		delete(cm.Clients, userID)
-		fmt.Printf("用户已经下线 %d\n", userID)
+		delete(cm.DoNotDisturb, userID)
+	}
+	cm.Lock.Unlock()
+
+	if !removed {
+		return false
+	}
+	ctx := current.ctx
+	slog.InfoContext(ctx, "连接已注销")
+	metrics.WSConnections.Dec()
+	current.Close(websocket.CloseNormalClosure, "")
+	// 服务关闭时不再广播下线，集群登记由 Shutdown 统一清理
+	if cm.Draining() {
+		return true
+	}
+	if err := cm.Broker.SetOffline(userID, cm.NodeID); err != nil {
+		slog.ErrorContext(ctx, "取消在线登记失败", "err", err)
+	}
+	// 用户已经在其他节点重新连接时不算下线
+	if nodeID, err := cm.Broker.Lookup(userID); err == nil && nodeID != "" {
+		return true
+	}
+	// 下线处理发生在连接断开之后，此时连接的 context 可能已被取消，只保留其中的日志字段和链路
+	cm.notifyPresence(context.WithoutCancel(ctx), userID, false)
+	return true
+}
+
+// notifyPresence 触发上线/下线回调
+func (cm *ClientManager) notifyPresence(ctx context.Context, userID uint, online bool) {
+	if cm.OnPresenceChange != nil {
+		cm.OnPresenceChange(ctx, userID, online)
 	}
 }
 
-// IsUserOnline 检查用户是否在线
+// IsUserOnline 检查用户是否在线（集群范围）
 func (cm *ClientManager) IsUserOnline(userID uint) bool {
 	cm.Lock.RLock()
-	defer cm.Lock.RUnlock()
 	_, ok := cm.Clients[userID]
-	return ok
+	cm.Lock.RUnlock()
+	if ok {
+		return true
+	}
+
+	nodeID, err := cm.Broker.Lookup(userID)
+	if err != nil {
+		slog.Error("查询在线状态失败", "user_id", userID, "err", err)
+		return false
+	}
+	return nodeID != ""
 }
 
 // SendMessage 发送消息给指定用户
 // 如果发送失败（连接已断开），自动清理该连接
 func (cm *ClientManager) SendMessage(msg Message) {
+	cm.SendMessageTo(msg.ReceiverID, msg)
+}
+
+// SendMessageTo 把消息发送给 userID 的连接，而不是 msg.ReceiverID。
+// 用于把同一条消息同时推送给会话双方，例如系统通知和撤回通知。
+// 用户连接在其他节点时通过 Broker 投递。
+func (cm *ClientManager) SendMessageTo(userID uint, msg Message) {
+	cm.Lock.RLock()
+	_, local := cm.Clients[userID]
+	cm.Lock.RUnlock()
+	if local {
+		cm.deliverLocal(userID, msg)
+		return
+	}
+
+	nodeID, err := cm.Broker.Lookup(userID)
+	if err != nil {
+		slog.Error("查询用户所在节点失败", "user_id", userID, "err", err)
+		return
+	}
+	if nodeID == "" || nodeID == cm.NodeID {
+		slog.Debug("用户不在线，消息未投递", "user_id", userID, "type", msg.Type)
+		return
+	}
+	if err := cm.Broker.Publish(nodeID, Envelope{Kind: EnvelopeMessage, UserID: userID, Message: msg}); err != nil {
+		slog.Error("投递消息到其他节点失败", "user_id", userID, "node_id", nodeID, "err", err)
+		metrics.CountMessage(msg.Type, metrics.MessageFailed)
+	}
+}
+
+// deliverLocal 把消息放入本节点上 userID 连接的发送队列
+func (cm *ClientManager) deliverLocal(userID uint, msg Message) {
 	cm.Lock.RLock()
-	conn, ok := cm.Clients[msg.ReceiverID]
+	client, ok := cm.Clients[userID]
+	muted := cm.DoNotDisturb[userID] && IsNotification(msg.Type)
 	cm.Lock.RUnlock()
 
+	if muted {
+		return
+	}
+	if !ok {
+		slog.Debug("用户不在线，消息未投递", "user_id", userID, "type", msg.Type)
+		return
+	}
+	if !client.enqueue(msg) {
+		// 发送队列已满说明客户端长时间不读取，关闭连接防止拖慢其他用户
+		slog.WarnContext(client.ctx, "发送队列已满，关闭连接")
+		metrics.CountMessage(msg.Type, metrics.MessageFailed)
+		cm.removeConn(userID, client.Conn)
+		return
+	}
+	metrics.CountMessage(msg.Type, metrics.MessageForwarded)
+}
+
+// ConnectionInfo 用户的连接信息
+type ConnectionInfo struct {
+	Online bool   `json:"online"`
+	NodeID string `json:"node_id,omitempty"` // 连接所在的节点
+	// 以下字段只有连接在当前节点上时才有，其他节点上的连接只能查到所在节点
+	ClientIP    string     `json:"client_ip,omitempty"`
+	ConnectedAt *time.Time `json:"connected_at,omitempty"`
+}
+
+// Connection 查询用户在集群中的连接
+func (cm *ClientManager) Connection(userID uint) (ConnectionInfo, error) {
+	cm.Lock.RLock()
+	client, ok := cm.Clients[userID]
+	cm.Lock.RUnlock()
 	if ok {
-		err := conn.WriteJSON(msg)
-		if err != nil {
-			fmt.Printf("发送消息给用户 %d 失败: %v，清理断开的连接\n", msg.ReceiverID, err)
-			// 写入失败说明连接已断开，清理该连接防止在线状态误报
-			cm.Lock.Lock()
-			// 再次检查是否是同一个连接（防止期间有新连接注册）
-			if currentConn, exists := cm.Clients[msg.ReceiverID]; exists && currentConn == conn {
-				conn.Close()
-				delete(cm.Clients, msg.ReceiverID)
-				fmt.Printf("用户 %d 的断开连接已清理\n", msg.ReceiverID)
-			}
-			cm.Lock.Unlock()
-			return
+		connectedAt := client.ConnectedAt
+		return ConnectionInfo{Online: true, NodeID: cm.NodeID, ClientIP: client.ClientIP, ConnectedAt: &connectedAt}, nil
+	}
+	nodeID, err := cm.Broker.Lookup(userID)
+	if err != nil {
+		return ConnectionInfo{}, err
+	}
+	return ConnectionInfo{Online: nodeID != "", NodeID: nodeID}, nil
+}
+
+// Disconnect 关闭用户在集群中的连接，并按正常下线处理（通知好友、记录最后在线时间）。
+// 用于登录凭证被吊销等需要强制下线的场景，客户端收到 CloseRevoked 后不应自动重连。
+func (cm *ClientManager) Disconnect(userID uint, reason string) {
+	if cm.disconnectLocal(userID, reason) {
+		return
+	}
+	nodeID, err := cm.Broker.Lookup(userID)
+	if err != nil {
+		slog.Error("查询用户所在节点失败", "user_id", userID, "err", err)
+		return
+	}
+	if nodeID == "" || nodeID == cm.NodeID {
+		return
+	}
+	if err := cm.Broker.Publish(nodeID, Envelope{Kind: EnvelopeDisconnect, UserID: userID, Reason: reason}); err != nil {
+		slog.Error("通知其他节点断开连接失败", "user_id", userID, "node_id", nodeID, "err", err)
+	}
+}
+
+// disconnectLocal 以 CloseRevoked 关闭用户在本节点上的连接，返回用户是否连接在本节点
+func (cm *ClientManager) disconnectLocal(userID uint, reason string) bool {
+	cm.Lock.RLock()
+	client, ok := cm.Clients[userID]
+	cm.Lock.RUnlock()
+	if !ok {
+		return false
+	}
+	// 先以 CloseRevoked 关闭，removeConn 中的再次关闭不会覆盖 close code
+	client.Close(CloseRevoked, reason)
+	slog.InfoContext(client.ctx, "连接被强制断开", "reason", reason)
+	cm.removeConn(userID, client.Conn)
+	return true
+}
+
+// handleEnvelope 处理其他节点投递过来的指令
+func (cm *ClientManager) handleEnvelope(env Envelope) {
+	switch env.Kind {
+	case EnvelopeMessage:
+		cm.deliverLocal(env.UserID, env.Message)
+	case EnvelopeKick:
+		// 用户已在其他节点登录：关闭本节点的旧连接，不触发下线回调。
+		// 旧连接之后的 UnRegister 找不到该连接，这里取消本节点的登记，避免本节点继续刷新在线状态；
+		// SetOffline 只在在线状态仍指向本节点时才删除，不会影响新节点的登记。
+		if err := cm.Broker.SetOffline(env.UserID, cm.NodeID); err != nil {
+			slog.Error("取消在线登记失败", "user_id", env.UserID, "err", err)
+		}
+		cm.Lock.Lock()
+		client, ok := cm.Clients[env.UserID]
+		if ok {
+			delete(cm.Clients, env.UserID)
+			delete(cm.DoNotDisturb, env.UserID)
+		}
+		cm.Lock.Unlock()
+		if ok {
+			metrics.WSConnections.Dec()
+			client.Close(websocket.CloseNormalClosure, "replaced by new connection")
+			slog.InfoContext(client.ctx, "用户已在其他节点登录，本节点旧连接已关闭")
+		}
+	case EnvelopeDisconnect:
+		cm.disconnectLocal(env.UserID, env.Reason)
+	case EnvelopeDoNotDisturb:
+		cm.setDoNotDisturbLocal(env.UserID, env.DoNotDisturb)
+	default:
+		slog.Warn("未知的节点间指令", "kind", env.Kind)
+	}
+}
+
+// Draining 服务是否正在关闭
+func (cm *ClientManager) Draining() bool {
+	return cm.draining.Load()
+}
+
+// StartDraining 标记服务正在关闭，之后新的连接会被立即关闭
+func (cm *ClientManager) StartDraining() {
+	cm.draining.Store(true)
+}
+
+// Shutdown 关闭本节点的全部连接：
+//  1. 不再接受新连接
+//  2. 每个连接先发送完发送队列中剩余的消息，再发送 1001 (going away) close 帧
+//  3. 取消这些用户在集群中的登记
+//
+// 等待所有连接关闭或 ctx 到期后返回。
+func (cm *ClientManager) Shutdown(ctx context.Context) error {
+	cm.StartDraining()
+
+	cm.Lock.Lock()
+	clients := make([]*Client, 0, len(cm.Clients))
+	for userID, client := range cm.Clients {
+		clients = append(clients, client)
+		delete(cm.Clients, userID)
+	}
+	cm.DoNotDisturb = make(map[uint]bool)
+	cm.Lock.Unlock()
+	metrics.WSConnections.Sub(float64(len(clients)))
+
+	for _, client := range clients {
+		client.Close(websocket.CloseGoingAway, "server shutting down")
+		if err := cm.Broker.SetOffline(client.UserID, cm.NodeID); err != nil {
+			slog.ErrorContext(client.ctx, "取消在线登记失败", "err", err)
+		}
+	}
+
+	for _, client := range clients {
+		select {
+		case <-client.Done():
+		case <-ctx.Done():
+			return ctx.Err()
 		}
-	} else {
-		fmt.Printf("用户 %d 不在线\n", msg.ReceiverID)
 	}
+	slog.Info("已关闭全部 WebSocket 连接", "count", len(clients))
+	return nil
 }
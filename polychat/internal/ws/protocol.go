@@ -1,6 +1,7 @@
 package ws
 
 // 定义前后端通信的消息结构体
+import "polychat/pkg/metrics"
 
 // 消息类型
 const (
@@ -8,12 +9,83 @@ const (
 	TypeHeartbeat     = "heartbeat"      // 心跳消息
 	TypeFriendRequest = "friend_request" // 好友请求通知
 	TypeFriendAccept  = "friend_accept"  // 好友接受通知
+	TypeSystem        = "system"         // 会话内的系统通知（例如开启/关闭阅后即焚）
+	TypeRead          = "read"           // 客户端上报已读，ID 为已读消息的ID
+	TypeAck           = "ack"            // 服务器确认收到消息，告知发送方服务器分配的消息ID
+	TypeRecall        = "recall"         // 服务器通知客户端删除消息，ID 为被删除消息的ID
+	TypeTypingStart   = "typing_start"   // 正在输入（仅转发，不持久化）
+	TypeTypingStop    = "typing_stop"    // 停止输入（仅转发，不持久化）
+	TypePresence      = "presence"       // 好友上线/下线通知，Content 为 online 或 offline
+	TypeError         = "error"          // 服务器拒绝了客户端发来的消息，详情见 Error
 )
 
+func init() {
+	metrics.SetMessageTypes(TypeChat, TypeHeartbeat, TypeFriendRequest, TypeFriendAccept, TypeSystem, TypeRead,
+		TypeAck, TypeRecall, TypeTypingStart, TypeTypingStop, TypePresence, TypeError)
+}
+
+// CloseRevoked 服务器主动关闭连接时使用的 close code：登录凭证已失效（例如修改了密码），
+// 客户端收到后应回到登录页，而不是自动重连
+const CloseRevoked = 4001
+
+// 错误通知的错误码
+const (
+	ErrorRateLimited     = "rate_limited"     // 发送过于频繁
+	ErrorUnsupportedType = "unsupported_type" // 客户端不能发送该类型的消息
+	ErrorSaveFailed      = "save_failed"      // 消息保存失败，没有发送给对方
+)
+
+// ErrorInfo 错误通知的详情
+type ErrorInfo struct {
+	Code       string `json:"code"`                  // 错误码，见 Error* 常量
+	Type       string `json:"type"`                  // 被拒绝的消息类型
+	RetryAfter int64  `json:"retry_after,omitempty"` // 建议多少毫秒后重试
+}
+
+// 在线状态通知的 Content 取值
+const (
+	PresenceOnline  = "online"
+	PresenceOffline = "offline"
+)
+
+// UserStatus 用户的自定义状态，随 presence 事件下发
+type UserStatus struct {
+	Text         string `json:"text,omitempty"`
+	Emoji        string `json:"emoji,omitempty"`
+	ExpiresAt    int64  `json:"expires_at,omitempty"` // 过期时间（Unix 秒），0 表示不过期
+	DoNotDisturb bool   `json:"do_not_disturb"`
+}
+
+// ClientFrameAllowed 判断客户端是否可以发送该类型的消息。
+// heartbeat 只用于保持连接，服务器收到后不做任何处理。其他类型（system、recall、presence、ack、error 等）只能由服务器下发，
+// 否则客户端可以伪造系统通知或让对方删除任意消息。
+func ClientFrameAllowed(msgType string) bool {
+	switch msgType {
+	case TypeChat, TypeHeartbeat, TypeRead, TypeTypingStart, TypeTypingStop:
+		return true
+	}
+	return false
+}
+
+// IsNotification 判断消息类型是否为通知类消息。
+// 开启免打扰的用户不会收到通知类消息，聊天消息、输入提示和会话内系统通知不受影响。
+func IsNotification(msgType string) bool {
+	switch msgType {
+	case TypeFriendRequest, TypeFriendAccept, TypePresence:
+		return true
+	}
+	return false
+}
+
 type Message struct {
-	Type       string `json:"type"`        //消息类型
-	SenderID   uint   `json:"sender_id"`   //发送者ID
-	ReceiverID uint   `json:"receiver_id"` //接收者ID
-	Content    string `json:"content"`     //消息内容
-	Timestamp  int64  `json:"timestamp"`   //消息时间戳
+	ID         string `json:"id,omitempty"`         //消息ID，由服务器分配
+	Type       string `json:"type"`                 //消息类型
+	SenderID   uint   `json:"sender_id"`            //发送者ID
+	ReceiverID uint   `json:"receiver_id"`          //接收者ID
+	Content    string `json:"content"`              //消息内容
+	Timestamp  int64  `json:"timestamp"`            //消息时间戳
+	ExpiresIn  int64  `json:"expires_in,omitempty"` //阅后即焚：对方阅读后多少秒删除，0 表示不删除
+
+	Status *UserStatus `json:"status,omitempty"` //presence 事件携带的用户状态
+	Error  *ErrorInfo  `json:"error,omitempty"`  //error 通知携带的错误详情
 }
@@ -0,0 +1,137 @@
+package ws
+
+// 正在输入状态的转发
+import (
+	"context"
+	"sync"
+	"time"
+
+	"polychat/pkg/config"
+)
+
+// typingKey 标识一个“谁正在给谁输入”的状态
+type typingKey struct {
+	SenderID   uint
+	ReceiverID uint
+}
+
+// TypingTracker 管理正在输入状态。
+//   - typing_start / typing_stop 只转发给接收方，不做持久化
+//   - 收到 start 后如果在 TTL 内没有收到 stop 或新的 start，服务器自动向接收方发送 stop
+//   - 同一发送方的 start 转发有最小间隔限制；状态已激活时重复的 start 只刷新过期时间，不再转发
+type TypingTracker struct {
+	mu        sync.Mutex
+	active    map[typingKey]*time.Timer // 处于激活状态的输入提示及其过期定时器
+	lastRelay map[uint]time.Time        // 每个发送方最近一次转发 start 的时间
+
+	// TTL 输入状态的自动过期时间
+	TTL time.Duration
+	// MinInterval 同一发送方两次转发 start 之间的最小间隔
+	MinInterval time.Duration
+	// CanRelay 判断发送方能否向接收方发送输入提示（好友关系、黑名单等），为 nil 时不做限制
+	CanRelay func(ctx context.Context, senderID, receiverID uint) bool
+}
+
+// Typing 全局唯一的输入状态管理器
+var Typing = &TypingTracker{
+	active:      make(map[typingKey]*time.Timer),
+	lastRelay:   make(map[uint]time.Time),
+	TTL:         config.GetDuration("POLYCHAT_TYPING_TTL", 6*time.Second),
+	MinInterval: config.GetDuration("POLYCHAT_TYPING_MIN_INTERVAL", 500*time.Millisecond),
+}
+
+// Start 处理 typing_start 帧，ctx 为发送方连接的 context
+func (t *TypingTracker) Start(ctx context.Context, msg Message) {
+	key := typingKey{SenderID: msg.SenderID, ReceiverID: msg.ReceiverID}
+
+	t.mu.Lock()
+	// 已经处于输入状态：只刷新过期时间
+	if timer, ok := t.active[key]; ok {
+		timer.Reset(t.TTL)
+		t.mu.Unlock()
+		return
+	}
+	// 发送方转发过于频繁：直接丢弃
+	now := time.Now()
+	if last, ok := t.lastRelay[msg.SenderID]; ok && now.Sub(last) < t.MinInterval {
+		t.mu.Unlock()
+		return
+	}
+	t.mu.Unlock()
+
+	// 权限检查可能访问数据库，放在锁外执行
+	if t.CanRelay != nil && !t.CanRelay(ctx, msg.SenderID, msg.ReceiverID) {
+		return
+	}
+
+	t.mu.Lock()
+	if _, ok := t.active[key]; ok {
+		// 检查期间已被并发的 start 激活
+		t.mu.Unlock()
+		return
+	}
+	t.lastRelay[msg.SenderID] = now
+	t.active[key] = time.AfterFunc(t.TTL, func() { t.expire(key) })
+	t.mu.Unlock()
+
+	ClientMgr.SendMessage(msg)
+}
+
+// Stop 处理 typing_stop 帧，只有处于输入状态时才转发
+func (t *TypingTracker) Stop(msg Message) {
+	key := typingKey{SenderID: msg.SenderID, ReceiverID: msg.ReceiverID}
+
+	t.mu.Lock()
+	timer, ok := t.active[key]
+	if ok {
+		timer.Stop()
+		delete(t.active, key)
+	}
+	t.mu.Unlock()
+
+	if ok {
+		ClientMgr.SendMessage(msg)
+	}
+}
+
+// Clear 清除发送方的全部输入状态，并通知各接收方停止输入。
+// 在连接断开时调用，防止对方一直显示“正在输入”。
+func (t *TypingTracker) Clear(senderID uint) {
+	t.mu.Lock()
+	var receivers []uint
+	for key, timer := range t.active {
+		if key.SenderID == senderID {
+			timer.Stop()
+			delete(t.active, key)
+			receivers = append(receivers, key.ReceiverID)
+		}
+	}
+	delete(t.lastRelay, senderID)
+	t.mu.Unlock()
+
+	for _, receiverID := range receivers {
+		ClientMgr.SendMessage(typingStop(senderID, receiverID))
+	}
+}
+
+// expire 输入状态超时，自动向接收方发送 typing_stop
+func (t *TypingTracker) expire(key typingKey) {
+	t.mu.Lock()
+	_, ok := t.active[key]
+	delete(t.active, key)
+	t.mu.Unlock()
+
+	if ok {
+		ClientMgr.SendMessage(typingStop(key.SenderID, key.ReceiverID))
+	}
+}
+
+// typingStop 构造服务器生成的 typing_stop 帧
+func typingStop(senderID, receiverID uint) Message {
+	return Message{
+		Type:       TypeTypingStop,
+		SenderID:   senderID,
+		ReceiverID: receiverID,
+		Timestamp:  time.Now().Unix(),
+	}
+}
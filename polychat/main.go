@@ -1,27 +1,125 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
 	"polychat/internal/api"
 	"polychat/internal/middleware"
+	"polychat/internal/model"
+	"polychat/internal/service"
+	"polychat/internal/ws"
+	"polychat/pkg/config"
 	"polychat/pkg/database"
+	"polychat/pkg/logger"
+	"polychat/pkg/mailer"
+	"polychat/pkg/ratelimit"
+	"polychat/pkg/telemetry"
+	"polychat/pkg/util"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 // messageHandle 消息历史记录处理器实例
 var messageHandle = api.MessageHandle{}
 
+// messageService 消息业务服务实例，用于启动阅后即焚删除任务
+var messageService = service.MessageService{}
+
+// relationService 好友关系服务实例，用于输入提示的好友关系校验
+var relationService = service.RelationService{}
+
+// presenceService 在线状态服务实例
+var presenceService = service.PresenceService{}
+
+// retentionService 消息保留策略服务实例
+var retentionService = service.RetentionService{}
+
+// redisBroker 集群模式下的 Redis 消息路由，单节点部署时为 nil
+var redisBroker *ws.RedisBroker
+
+// shutdownTimeout 优雅关闭的最长等待时间，超时后强制退出
+var shutdownTimeout = config.GetDuration("POLYCHAT_SHUTDOWN_TIMEOUT", 15*time.Second)
+
 func main() {
+	// 0. 监听 SIGINT / SIGTERM，收到信号后开始优雅关闭
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// 0.1 初始化结构化日志（POLYCHAT_LOG_LEVEL / POLYCHAT_LOG_FORMAT）
+	logger.Init()
+	// 0.2 初始化链路追踪（POLYCHAT_TRACING_EXPORTER），需在数据库连接之前完成
+	nodeID := config.GetString("POLYCHAT_NODE_ID", ws.DefaultNodeID())
+	shutdownTracing, err := telemetry.Init(ctx, nodeID)
+	if err != nil {
+		panic("初始化链路追踪失败: " + err.Error())
+	}
+
+	// 0.3 邮件发送方式（POLYCHAT_MAILER）
+	mailer.Init()
+	// 0.4 JWT 签名密钥（POLYCHAT_JWT_KEYS_DIR），收到 SIGHUP 时重新加载以轮换密钥
+	if err := util.InitKeys(); err != nil {
+		panic("加载 JWT 密钥失败: " + err.Error())
+	}
+	go reloadKeysOnSIGHUP(ctx)
+
 	// 1. 初始化数据库连接
 	database.InitDB()
 	// 1.1 初始化 MongoDB 连接（用于存储聊天历史记录）
 	database.InitMongoDB()
-	defer database.CloseMongoDB()
+	// 1.2 启动消息保留策略的后台清理任务
+	retentionService.StartRetentionJob(ctx)
+	// 1.3 启动阅后即焚消息的后台删除任务
+	messageService.StartExpiryJob(ctx)
+	// 1.4 输入提示只在互为好友的用户之间转发
+	ws.Typing.CanRelay = relationService.AreFriends
+	// 1.5 用户上线/下线时向好友推送在线状态
+	ws.ClientMgr.OnPresenceChange = presenceService.OnPresenceChange
+	ws.ClientMgr.LoadDoNotDisturb = presenceService.LoadDoNotDisturb
+	// 1.6 多节点部署时通过 Redis 在节点之间路由消息（POLYCHAT_BROKER=redis）
+	if config.GetString("POLYCHAT_BROKER", "memory") == "redis" {
+		database.InitRedis()
+		redisBroker = ws.NewRedisBroker(database.RedisClient)
+		if err := ws.ClientMgr.UseBroker(nodeID, redisBroker); err != nil {
+			panic("初始化 Redis 消息路由失败: " + err.Error())
+		}
+		slog.Info("集群模式已启用", "node_id", nodeID)
+	}
+	// 1.7 限流计数默认与消息路由使用相同的存储：集群模式下各节点通过 Redis 共享令牌桶
+	// （POLYCHAT_RATELIMIT_BACKEND=memory|redis）
+	if config.GetString("POLYCHAT_RATELIMIT_BACKEND", config.GetString("POLYCHAT_BROKER", "memory")) == "redis" {
+		if database.RedisClient == nil {
+			database.InitRedis()
+		}
+		ratelimit.Default = ratelimit.NewRedisLimiter(database.RedisClient)
+	}
 
 	gin.SetMode(gin.ReleaseMode)
 	// 2.初始化gin引擎
-	r := gin.Default()
+	r := gin.New()
+	// 只信任 POLYCHAT_TRUSTED_PROXIES（逗号分隔的IP或CIDR）转发的 X-Forwarded-For，
+	// 默认不信任任何代理，防止客户端伪造IP绕过按IP的限流
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		panic("可信代理配置错误: " + err.Error())
+	}
+	// 链路追踪放在最前面，之后的中间件和日志都能拿到 trace_id；探针和监控抓取不生成 span
+	r.Use(otelgin.Middleware(telemetry.ServiceName, otelgin.WithGinFilter(func(c *gin.Context) bool {
+		switch c.FullPath() {
+		case "/healthz", "/readyz", "/metrics":
+			return false
+		}
+		return true
+	})))
+	r.Use(middleware.RequestIDMiddleware(), middleware.AccessLogMiddleware(), gin.Recovery())
+	r.Use(middleware.MetricsMiddleware())
 
 	// 静态文件服务
 	r.Static("/static", "./static")
@@ -30,32 +128,84 @@ func main() {
 		c.File("./static/index.html")
 	})
 
+	// 健康检查和构建信息，供编排系统探测，不需要Token验证
+	r.GET("/healthz", api.Healthz)
+	r.GET("/readyz", api.Readyz)
+	r.GET("/version", api.Version)
+	// Prometheus 监控指标
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	// 验证 token 的公钥，供其他服务使用
+	r.GET("/.well-known/jwks.json", api.JWKS)
+
 	//3.注册路由
 	userHandle := api.UserHandle{}
 	RelationHandle := api.RelationHandler{}
+	passwordHandle := api.PasswordHandle{}
+	oidcHandle := api.OIDCHandle{}
+	adminHandle := api.AdminHandle{}
 	//公开接口，不需要Token验证
 	v1 := r.Group("/api/v1")
 	{
-		v1.POST("/register", userHandle.Register)
-		v1.POST("/login", userHandle.Login)
+		v1.POST("/register", middleware.RateLimitMiddleware("register", ratelimit.Every(5, time.Hour), middleware.ByIP), userHandle.Register)
+		v1.POST("/login", middleware.RateLimitMiddleware("login", ratelimit.Every(10, time.Minute), middleware.ByIP), userHandle.Login)
+		v1.POST("/password/forgot", middleware.RateLimitMiddleware("password_forgot", ratelimit.Every(5, time.Hour), middleware.ByIP), passwordHandle.ForgotPassword)
+		v1.POST("/password/reset", middleware.RateLimitMiddleware("password_reset", ratelimit.Every(10, time.Hour), middleware.ByIP), passwordHandle.ResetPassword)
+		v1.POST("/email/verify", middleware.RateLimitMiddleware("email_verify", ratelimit.Every(20, time.Hour), middleware.ByIP), userHandle.VerifyEmail)
+		v1.POST("/login/2fa", middleware.RateLimitMiddleware("login_2fa", ratelimit.Every(10, time.Minute), middleware.ByIP), userHandle.LoginTwoFactor)
+		// 单点登录（POLYCHAT_OIDC_ISSUER），由浏览器直接跳转访问
+		v1.GET("/oidc/login", middleware.RateLimitMiddleware("oidc", ratelimit.Every(20, time.Minute), middleware.ByIP), oidcHandle.Login)
+		v1.GET("/oidc/callback", middleware.RateLimitMiddleware("oidc", ratelimit.Every(20, time.Minute), middleware.ByIP), oidcHandle.Callback)
 	}
 
 	//受保护的接口，需要验证Token
 	authorized := v1.Group("/")
 	authorized.Use(middleware.JWTAuthMiddleware())
+	// 登录用户的所有接口共享一个宽松的总额度，个别接口另有更严格的限制
+	authorized.Use(middleware.RateLimitMiddleware("api", ratelimit.Limit{Rate: 10, Burst: 60}, middleware.ByUser))
 	{
 		authorized.GET("/chat", api.ConnectWSWithHistory)
 
+		// 用户设置模块
+		userGroup := authorized.Group("/user")
+		{
+			userGroup.POST("/privacy", userHandle.UpdatePrivacy)
+			userGroup.GET("/status", userHandle.GetStatus)
+			userGroup.POST("/status", userHandle.UpdateStatus)
+			userGroup.POST("/dnd", userHandle.UpdateDoNotDisturb)
+			userGroup.GET("/profile", userHandle.GetProfile)
+			userGroup.POST("/profile", userHandle.UpdateProfile)
+			userGroup.POST("/password", passwordHandle.ChangePassword)
+			// 邮箱绑定，两个接口共享发送邮件的额度
+			userGroup.POST("/email", middleware.RateLimitMiddleware("email_send", ratelimit.Every(5, time.Hour), middleware.ByUser), userHandle.UpdateEmail)
+			userGroup.POST("/email/resend", middleware.RateLimitMiddleware("email_send", ratelimit.Every(5, time.Hour), middleware.ByUser), userHandle.ResendEmailVerification)
+			// 外部账号绑定
+			userGroup.GET("/oidc", oidcHandle.ListIdentities)
+			userGroup.POST("/oidc/link", oidcHandle.LinkIdentity)
+			userGroup.POST("/oidc/unlink", oidcHandle.UnlinkIdentity)
+			// 两步验证
+			userGroup.GET("/2fa", userHandle.GetTwoFactor)
+			userGroup.POST("/2fa/setup", userHandle.SetupTwoFactor)
+			userGroup.POST("/2fa/confirm", userHandle.ConfirmTwoFactor)
+			userGroup.POST("/2fa/disable", userHandle.DisableTwoFactor)
+			userGroup.POST("/2fa/recovery_codes", userHandle.RegenerateRecoveryCodes)
+		}
+
 		// 消息历史记录模块
 		messageGroup := authorized.Group("/message")
 		{
 			messageGroup.GET("/history", messageHandle.GetHistory)
+			messageGroup.GET("/search", messageHandle.SearchMessages)
+			messageGroup.GET("/export", messageHandle.ExportHistory)
+			messageGroup.GET("/retention", messageHandle.GetRetention)
+			messageGroup.POST("/retention", messageHandle.SetRetention)
+			messageGroup.GET("/disappearing", messageHandle.GetDisappearing)
+			messageGroup.POST("/disappearing", messageHandle.SetDisappearing)
 		}
 
 		// 好友关系模块
 		relationGroup := authorized.Group("/relation")
 		{
-			relationGroup.POST("/add", RelationHandle.AddFriend)
+			relationGroup.POST("/add", middleware.RateLimitMiddleware("relation_add", ratelimit.Every(30, time.Hour), middleware.ByUser), RelationHandle.AddFriend)
 			relationGroup.POST("/delete", RelationHandle.DeleteFriend)
 			relationGroup.GET("/list", RelationHandle.GetFriend)
 			relationGroup.POST("/update_note", RelationHandle.UpdateFriendNote)
@@ -63,9 +213,95 @@ func main() {
 			relationGroup.POST("/accept", RelationHandle.AcceptFriend)
 			relationGroup.POST("/reject", RelationHandle.RejectFriend)
 		}
+
+		// 管理接口，每个接口按需要的权限校验角色
+		adminGroup := authorized.Group("/admin")
+		{
+			adminGroup.GET("/users", middleware.RequirePermission(model.PermViewUsers), adminHandle.SearchUsers)
+			adminGroup.GET("/users/sessions", middleware.RequirePermission(model.PermViewUsers), adminHandle.GetUserSessions)
+			adminGroup.POST("/users/disconnect", middleware.RequirePermission(model.PermManageSessions), adminHandle.DisconnectUser)
+			adminGroup.POST("/users/suspend", middleware.RequirePermission(model.PermManageUsers), adminHandle.SuspendUser)
+			adminGroup.POST("/users/unsuspend", middleware.RequirePermission(model.PermManageUsers), adminHandle.UnsuspendUser)
+			adminGroup.POST("/users/delete", middleware.RequirePermission(model.PermManageUsers), adminHandle.DeleteUser)
+			adminGroup.POST("/users/restore", middleware.RequirePermission(model.PermManageUsers), adminHandle.RestoreUser)
+			adminGroup.POST("/users/reset_password", middleware.RequirePermission(model.PermManageUsers), adminHandle.ResetUserPassword)
+			adminGroup.POST("/users/role", middleware.RequirePermission(model.PermManageRoles), adminHandle.SetUserRole)
+			adminGroup.GET("/audit_logs", middleware.RequirePermission(model.PermViewAuditLog), adminHandle.ListAuditLogs)
+		}
+	}
+	srv := &http.Server{Addr: ":8080", Handler: r}
+	go func() {
+		slog.Info("服务器开始监听", "addr", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			panic("运行失败" + err.Error())
+		}
+	}()
+
+	<-ctx.Done()
+	// 恢复默认信号处理：关闭过程中再次收到信号时立即退出
+	stop()
+	slog.Info("收到退出信号，开始优雅关闭", "timeout", shutdownTimeout)
+	shutdown(srv, shutdownTracing)
+}
+
+// reloadKeysOnSIGHUP 每次收到 SIGHUP 时重新加载 JWT 密钥，加载失败时继续使用之前的密钥
+func reloadKeysOnSIGHUP(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := util.InitKeys(); err != nil {
+				slog.Error("重新加载 JWT 密钥失败，继续使用之前的密钥", "err", err)
+			}
+		}
+	}
+}
+
+// trustedProxies 读取可信代理列表，未配置时返回 nil（不信任任何代理）
+func trustedProxies() []string {
+	var proxies []string
+	for _, p := range strings.Split(config.GetString("POLYCHAT_TRUSTED_PROXIES", ""), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return proxies
+}
+
+// shutdown 按顺序优雅关闭服务，整体耗时不超过 shutdownTimeout：
+//  1. 停止接受新的 WebSocket 连接
+//  2. 停止 HTTP 监听，等待进行中的普通请求完成
+//  3. 发送完每个连接发送队列中的消息后，发送 1001 (going away) close 帧并关闭连接
+//  4. 等待尚未完成的 MongoDB 消息写入
+//  5. 关闭 Redis、MySQL 和 MongoDB 连接
+//  6. 导出剩余的链路追踪数据
+func shutdown(srv *http.Server, shutdownTracing func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	ws.ClientMgr.StartDraining()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("HTTP 服务关闭失败", "err", err)
+	}
+	if err := ws.ClientMgr.Shutdown(ctx); err != nil {
+		slog.Error("WebSocket 连接关闭超时", "err", err)
+	}
+	if err := service.WaitPendingWrites(ctx); err != nil {
+		slog.Error("等待消息持久化超时，部分消息可能未保存", "err", err)
+	}
+
+	if redisBroker != nil {
+		redisBroker.Close()
 	}
-	fmt.Println("服务器运行在8080端口")
-	if err := r.Run(":8080"); err != nil {
-		panic("运行失败" + err.Error())
+	database.CloseRedis()
+	database.CloseDB()
+	database.CloseMongoDB()
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("导出链路追踪数据失败", "err", err)
 	}
+	slog.Info("服务已关闭")
 }
@@ -0,0 +1,77 @@
+// Package config 提供从环境变量读取可选配置项的辅助函数。
+// 所有配置项都有默认值，未设置或格式错误时使用默认值，保证开箱即用。
+package config
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GetString 读取字符串配置，未设置时返回 def。
+func GetString(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+// GetInt 读取整数配置，未设置或格式错误时返回 def。
+func GetInt(key string, def int) int {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		slog.Warn("配置项格式错误，使用默认值", "key", key, "value", v, "default", def)
+		return def
+	}
+	return n
+}
+
+// GetBool 读取布尔配置（1/true/yes/on 为真），未设置时返回 def。
+func GetBool(key string, def bool) bool {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "true", "yes", "on":
+		return true
+	case "0", "false", "no", "off", "":
+		return false
+	}
+	slog.Warn("配置项格式错误，使用默认值", "key", key, "value", v, "default", def)
+	return def
+}
+
+// GetDuration 读取时长配置，未设置或格式错误时返回 def。
+// 除 time.ParseDuration 支持的格式（如 "90s"、"1h30m"）外，还支持以天为单位的 "7d"。
+func GetDuration(key string, def time.Duration) time.Duration {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	d, err := ParseDuration(v)
+	if err != nil {
+		slog.Warn("配置项格式错误，使用默认值", "key", key, "value", v, "default", def.String())
+		return def
+	}
+	return d
+}
+
+// ParseDuration 解析时长字符串，在 time.ParseDuration 的基础上支持 "7d" 形式的天数。
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
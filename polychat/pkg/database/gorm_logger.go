@@ -0,0 +1,62 @@
+// 本文件把 GORM 的日志接入 slog，SQL 日志会带上 context 中的 request_id 等字段。
+package database
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"polychat/pkg/config"
+
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// slowQueryThreshold 慢查询阈值，超过时以 warn 级别记录 SQL（POLYCHAT_SLOW_QUERY，默认 200ms）
+var slowQueryThreshold = config.GetDuration("POLYCHAT_SLOW_QUERY", 200*time.Millisecond)
+
+// slogGormLogger 实现 gorm logger.Interface：
+//   - 执行出错（记录不存在除外）：error
+//   - 慢查询：warn
+//   - 其他 SQL：debug
+//
+// 同时实现 gorm.ParamsFilter，日志中的 SQL 只保留 ? 占位符，不包含参数值，
+// 避免密码哈希、两步验证密钥、邮箱等写入日志（与 gorm_tracing.go 记录的 SQL 一致）。
+type slogGormLogger struct{}
+
+// ParamsFilter 丢弃 SQL 参数，Trace 中 fc() 返回的是带占位符的 SQL
+func (l slogGormLogger) ParamsFilter(_ context.Context, sql string, _ ...interface{}) (string, []interface{}) {
+	return sql, nil
+}
+
+func (l slogGormLogger) LogMode(gormlogger.LogLevel) gormlogger.Interface {
+	return l
+}
+
+func (l slogGormLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	slog.InfoContext(ctx, msg, "args", args)
+}
+
+func (l slogGormLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	slog.WarnContext(ctx, msg, "args", args)
+}
+
+func (l slogGormLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	slog.ErrorContext(ctx, msg, "args", args)
+}
+
+func (l slogGormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	elapsed := time.Since(begin)
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		sql, rows := fc()
+		slog.ErrorContext(ctx, "SQL 执行失败", "sql", sql, "rows", rows, "elapsed", elapsed, "err", err)
+	case slowQueryThreshold > 0 && elapsed > slowQueryThreshold:
+		sql, rows := fc()
+		slog.WarnContext(ctx, "慢查询", "sql", sql, "rows", rows, "elapsed", elapsed)
+	case slog.Default().Enabled(ctx, slog.LevelDebug):
+		sql, rows := fc()
+		slog.DebugContext(ctx, "SQL", "sql", sql, "rows", rows, "elapsed", elapsed)
+	}
+}
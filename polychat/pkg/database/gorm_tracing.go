@@ -0,0 +1,81 @@
+// 本文件通过 GORM 回调为每条 SQL 生成 OpenTelemetry span。
+package database
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// gormSpanKey 保存当前 SQL span 的 gorm 实例键
+const gormSpanKey = "polychat:otel_span"
+
+// gormTracing 实现 gorm.Plugin。
+// span 挂在 db.WithContext(ctx) 传入的 context 之下，只记录带占位符的 SQL，不记录参数值，
+// 避免密码哈希等敏感数据进入链路。
+type gormTracing struct{}
+
+func (gormTracing) Name() string { return "polychat:otel_tracing" }
+
+func (p gormTracing) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	hooks := []struct {
+		name   string
+		before func(string, func(*gorm.DB)) error
+		after  func(string, func(*gorm.DB)) error
+	}{
+		{"create", cb.Create().Before("gorm:create").Register, cb.Create().After("gorm:create").Register},
+		{"query", cb.Query().Before("gorm:query").Register, cb.Query().After("gorm:query").Register},
+		{"update", cb.Update().Before("gorm:update").Register, cb.Update().After("gorm:update").Register},
+		{"delete", cb.Delete().Before("gorm:delete").Register, cb.Delete().After("gorm:delete").Register},
+		{"row", cb.Row().Before("gorm:row").Register, cb.Row().After("gorm:row").Register},
+		{"raw", cb.Raw().Before("gorm:raw").Register, cb.Raw().After("gorm:raw").Register},
+	}
+	for _, h := range hooks {
+		if err := h.before(p.Name()+":before_"+h.name, p.before(h.name)); err != nil {
+			return err
+		}
+		if err := h.after(p.Name()+":after_"+h.name, p.after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// before 开始 span，并把带 span 的 context 交给后续回调
+func (gormTracing) before(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx, span := otel.Tracer("polychat/gorm").Start(db.Statement.Context, "mysql."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemNameMySQL, semconv.DBOperationName(operation)))
+		db.Statement.Context = ctx
+		db.InstanceSet(gormSpanKey, span)
+	}
+}
+
+// after 记录 SQL、影响行数和错误，结束 span
+func (gormTracing) after(db *gorm.DB) {
+	v, ok := db.InstanceGet(gormSpanKey)
+	if !ok {
+		return
+	}
+	span := v.(trace.Span)
+	defer span.End()
+
+	if db.Statement.Table != "" {
+		span.SetAttributes(semconv.DBCollectionName(db.Statement.Table))
+	}
+	span.SetAttributes(
+		semconv.DBQueryText(db.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", db.Statement.RowsAffected),
+	)
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		span.RecordError(db.Error)
+		span.SetStatus(codes.Error, db.Error.Error())
+	}
+}
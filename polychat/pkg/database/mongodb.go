@@ -5,11 +5,13 @@ package database
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
 )
 
 // MongoDB 全局变量，供其他包引用
@@ -49,7 +51,8 @@ func InitMongoDB() {
 	defer cancel()
 
 	// 配置客户端选项
-	clientOpts := options.Client().ApplyURI(uri)
+	// 通过命令监听器为每条 MongoDB 命令生成 span
+	clientOpts := options.Client().ApplyURI(uri).SetMonitor(otelmongo.NewMonitor())
 
 	// 建立 MongoDB 连接
 	client, err := mongo.Connect(ctx, clientOpts)
@@ -70,7 +73,7 @@ func InitMongoDB() {
 	// 创建索引以优化查询性能
 	createMessageIndexes()
 
-	fmt.Println("MongoDB 连接成功")
+	slog.Info("MongoDB 连接成功")
 }
 
 // createMessageIndexes 为 messages 集合创建必要的索引。
@@ -79,6 +82,13 @@ func InitMongoDB() {
 //     用于加速两个用户之间的聊天记录查询，按时间倒序排列。
 //  2. 单字段索引 {timestamp: -1}
 //     用于按时间排序的全局查询。
+//  3. 全文索引 {content: "text"}
+//     用于聊天记录关键词搜索。default_language 设为 none，
+//     不做词干提取和停用词过滤，避免中英文混排时关键词被误删。
+//     全文索引按空格和标点分词，无法切分中文，包含中日韩文字的关键词改用正则匹配，
+//     见 dao.MessageDAO.SearchMessages。
+//  4. 稀疏索引 {expire_at: 1}
+//     用于阅后即焚消息的到期扫描，只包含设置了 expire_at 的消息。
 func createMessageIndexes() {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -98,6 +108,16 @@ func createMessageIndexes() {
 				{Key: "timestamp", Value: -1},
 			},
 		},
+		{
+			// 全文索引：支持按消息内容进行关键词搜索
+			Keys:    bson.D{{Key: "content", Value: "text"}},
+			Options: options.Index().SetName("content_text").SetDefaultLanguage("none"),
+		},
+		{
+			// 稀疏索引：优化阅后即焚消息的到期扫描
+			Keys:    bson.D{{Key: "expire_at", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		},
 	}
 
 	_, err := MongoMessageColl.Indexes().CreateMany(ctx, indexes)
@@ -105,7 +125,7 @@ func createMessageIndexes() {
 		panic("MongoDB 创建索引失败: " + err.Error())
 	}
 
-	fmt.Println("MongoDB 消息索引创建成功")
+	slog.Info("MongoDB 消息索引创建成功")
 }
 
 // CloseMongoDB 优雅关闭 MongoDB 连接。
@@ -115,9 +135,9 @@ func CloseMongoDB() {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		if err := MongoClient.Disconnect(ctx); err != nil {
-			fmt.Printf("MongoDB 断开连接失败: %v\n", err)
+			slog.Error("MongoDB 断开连接失败", "err", err)
 		} else {
-			fmt.Println("MongoDB 连接已关闭")
+			slog.Info("MongoDB 连接已关闭")
 		}
 	}
 }
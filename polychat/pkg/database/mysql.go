@@ -1,7 +1,7 @@
 package database
 
 import (
-	"fmt"
+	"log/slog"
 	"polychat/internal/model"
 
 	"gorm.io/driver/mysql"
@@ -16,19 +16,50 @@ func InitDB() {
 	dsn := "admin:YY010303@tcp(47.110.94.115:3306)/polychat_db?charset=utf8mb4&parseTime=True&loc=Local&timeout=10s&readTimeout=30s&writeTimeout=30s&allowNativePasswords=true&tls=false"
 
 	var err error
-	DB, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	// TranslateError 把违反唯一索引等驱动错误转换为 gorm.ErrDuplicatedKey 等通用错误
+	DB, err = gorm.Open(mysql.Open(dsn), &gorm.Config{Logger: slogGormLogger{}, TranslateError: true})
 
 	if err != nil {
 		//在err不为空的时候，说明连接失败，抛出异常且终止流程
 		panic("数据库连接失败" + err.Error())
 	}
+	// 每条 SQL 生成一个 span，挂在调用方 context 中的 span 之下
+	if err := DB.Use(gormTracing{}); err != nil {
+		panic("注册 MySQL 链路追踪插件失败" + err.Error())
+	}
 
-	//通过model包中的User结构体，自动创建数据库中的user表
-	err = DB.AutoMigrate(&model.User{})
-	err = DB.AutoMigrate(&model.Relation{})
-	if err != nil {
+	//通过model包中的结构体，自动创建数据库中的表
+	if err := Migrate(DB); err != nil {
 		//在err不为空的时候，说明创建表失败，抛出异常且终止流程
 		panic("数据库创建表失败" + err.Error())
 	}
-	fmt.Println("数据库连接成功")
+	slog.Info("MySQL 连接成功")
+}
+
+// Migrate 创建或更新全部表。所有表在一次调用中迁移，任何一张表失败都返回错误
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(
+		&model.User{},
+		&model.Relation{},
+		&model.ConversationSetting{},
+		&model.LoginFailure{}, &model.AuditLog{}, &model.RecoveryCode{},
+		&model.UserIdentity{}, &model.LoginSession{},
+	)
+}
+
+// CloseDB 关闭 MySQL 连接池，应在服务关闭时调用
+func CloseDB() {
+	if DB == nil {
+		return
+	}
+	sqlDB, err := DB.DB()
+	if err != nil {
+		slog.Error("获取 MySQL 连接池失败", "err", err)
+		return
+	}
+	if err := sqlDB.Close(); err != nil {
+		slog.Error("MySQL 断开连接失败", "err", err)
+	} else {
+		slog.Info("MySQL 连接已关闭")
+	}
 }
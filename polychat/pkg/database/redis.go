@@ -0,0 +1,48 @@
+// 本文件负责 Redis 的连接初始化。
+// Redis 是可选依赖：仅在多节点部署时用于节点间消息路由、集群范围的在线状态和共享的限流计数。
+package database
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"polychat/pkg/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisClient 是 Redis 客户端实例，未启用 Redis 时为 nil
+var RedisClient *redis.Client
+
+// InitRedis 初始化 Redis 连接。
+// 连接地址通过环境变量 POLYCHAT_REDIS_ADDR（默认 127.0.0.1:6379）、
+// POLYCHAT_REDIS_PASSWORD 和 POLYCHAT_REDIS_DB 配置。
+// 如果连接失败，程序将 panic 终止。
+func InitRedis() {
+	client := redis.NewClient(&redis.Options{
+		Addr:     config.GetString("POLYCHAT_REDIS_ADDR", "127.0.0.1:6379"),
+		Password: config.GetString("POLYCHAT_REDIS_PASSWORD", ""),
+		DB:       config.GetInt("POLYCHAT_REDIS_DB", 0),
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		panic("Redis 连接失败: " + err.Error())
+	}
+
+	RedisClient = client
+	slog.Info("Redis 连接成功", "addr", client.Options().Addr)
+}
+
+// CloseRedis 关闭 Redis 连接
+func CloseRedis() {
+	if RedisClient != nil {
+		if err := RedisClient.Close(); err != nil {
+			slog.Error("Redis 断开连接失败", "err", err)
+		} else {
+			slog.Info("Redis 连接已关闭")
+		}
+	}
+}
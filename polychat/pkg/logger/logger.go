@@ -0,0 +1,123 @@
+// Package logger 基于 log/slog 提供结构化、分级的日志。
+//
+// 日志字段（request_id、conn_id、user_id 等）随 context 传递：
+// 中间件和 WebSocket 入口通过 With 把字段放入 context，之后的 service / DAO 调用
+// 只需使用 slog.InfoContext(ctx, ...) 等带 Context 的函数，字段会自动附加到日志中。
+// context 中有 OpenTelemetry span 时，还会附加 trace_id 和 span_id，便于从日志跳转到链路。
+//
+// 配置项：
+//   - POLYCHAT_LOG_LEVEL   日志级别 debug / info / warn / error，默认 info
+//   - POLYCHAT_LOG_FORMAT  输出格式 json / text，默认 json
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"strings"
+
+	"polychat/pkg/config"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Init 按环境变量配置全局默认 logger（slog.Default），应在服务启动时最先调用。
+func Init() {
+	level := ParseLevel(config.GetString("POLYCHAT_LOG_LEVEL", "info"))
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if strings.EqualFold(config.GetString("POLYCHAT_LOG_FORMAT", "json"), "text") {
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	} else {
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	}
+	slog.SetDefault(slog.New(&contextHandler{Handler: handler}))
+}
+
+// ParseLevel 解析日志级别，无法识别时返回 info
+func ParseLevel(s string) slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+// ctxKey context 中日志字段的键
+type ctxKey struct{}
+
+// With 返回附加了日志字段的 context，args 的格式与 slog.Logger.With 相同（键值对或 slog.Attr）。
+// 同名字段不会覆盖，而是都输出，因此每个字段只应在一处设置。
+func With(ctx context.Context, args ...any) context.Context {
+	attrs := attrsFromArgs(args)
+	if len(attrs) == 0 {
+		return ctx
+	}
+	prev, _ := ctx.Value(ctxKey{}).([]slog.Attr)
+	merged := make([]slog.Attr, 0, len(prev)+len(attrs))
+	merged = append(merged, prev...)
+	merged = append(merged, attrs...)
+	return context.WithValue(ctx, ctxKey{}, merged)
+}
+
+// Attrs 返回 context 中携带的日志字段
+func Attrs(ctx context.Context) []slog.Attr {
+	attrs, _ := ctx.Value(ctxKey{}).([]slog.Attr)
+	return attrs
+}
+
+// NewID 生成随机ID，用于 request_id 和 conn_id
+func NewID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// attrsFromArgs 把 slog 风格的键值对参数转换为 Attr 列表
+func attrsFromArgs(args []any) []slog.Attr {
+	var attrs []slog.Attr
+	for len(args) > 0 {
+		switch x := args[0].(type) {
+		case slog.Attr:
+			attrs = append(attrs, x)
+			args = args[1:]
+		case string:
+			if len(args) < 2 {
+				attrs = append(attrs, slog.String("!BADKEY", x))
+				return attrs
+			}
+			attrs = append(attrs, slog.Any(x, args[1]))
+			args = args[2:]
+		default:
+			attrs = append(attrs, slog.Any("!BADKEY", x))
+			args = args[1:]
+		}
+	}
+	return attrs
+}
+
+// contextHandler 在每条日志中附加 context 携带的字段
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs := Attrs(ctx); len(attrs) > 0 {
+		r.AddAttrs(attrs...)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,75 @@
+// Package mailer 发送通知邮件（重置密码等）。
+//
+// 配置项：
+//   - POLYCHAT_MAILER         发送方式：log（默认，只把邮件内容写入日志，用于开发环境）、smtp
+//   - POLYCHAT_SMTP_ADDR      SMTP 服务器地址（host:port），默认 localhost:1025，
+//     可直接使用 MailHog、Mailpit 等本地测试服务器
+//   - POLYCHAT_SMTP_USERNAME / POLYCHAT_SMTP_PASSWORD  SMTP 认证信息，为空时不认证
+//   - POLYCHAT_MAIL_FROM      发件人地址，默认 polychat@localhost
+package mailer
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"polychat/pkg/config"
+)
+
+// Message 一封纯文本邮件
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// validate 检查收件人和标题中没有换行，防止邮件头注入
+func (m Message) validate() error {
+	if m.To == "" {
+		return errors.New("收件人不能为空")
+	}
+	if strings.ContainsAny(m.To, "\r\n") || strings.ContainsAny(m.Subject, "\r\n") {
+		return errors.New("收件人或标题包含非法字符")
+	}
+	return nil
+}
+
+// Mailer 邮件发送方式
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Default 全局使用的邮件发送方式，由 Init 按配置设置
+var Default Mailer = LogMailer{}
+
+// Init 按环境变量选择邮件发送方式
+func Init() {
+	switch kind := strings.ToLower(config.GetString("POLYCHAT_MAILER", "log")); kind {
+	case "smtp":
+		m := &SMTPMailer{
+			Addr:     config.GetString("POLYCHAT_SMTP_ADDR", "localhost:1025"),
+			Username: config.GetString("POLYCHAT_SMTP_USERNAME", ""),
+			Password: config.GetString("POLYCHAT_SMTP_PASSWORD", ""),
+			From:     config.GetString("POLYCHAT_MAIL_FROM", "polychat@localhost"),
+		}
+		Default = m
+		slog.Info("邮件通过 SMTP 发送", "addr", m.Addr)
+	case "log", "":
+		Default = LogMailer{}
+	default:
+		slog.Warn("未知的邮件发送方式，邮件只写入日志", "mailer", kind)
+		Default = LogMailer{}
+	}
+}
+
+// LogMailer 不真正发送邮件，只把邮件内容写入日志，用于开发和测试环境
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "邮件（未发送，仅记录日志）", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
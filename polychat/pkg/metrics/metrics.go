@@ -0,0 +1,104 @@
+// Package metrics 定义 Prometheus 监控指标，通过 /metrics 接口暴露。
+//
+// 指标列表：
+//   - polychat_ws_connections                     当前本节点的 WebSocket 连接数
+//   - polychat_ws_registrations_total             WebSocket 连接注册总数
+//   - polychat_messages_total{type,event}         消息计数，event 取值见 Message* 常量，未登记的 type 计入 other
+//   - polychat_db_query_duration_seconds{db,method}  每个 DAO 方法的耗时
+//   - polychat_http_request_duration_seconds{method,route,status}  HTTP 请求耗时
+//   - polychat_rate_limited_total{rule}          被限流拒绝的 HTTP 请求和 WebSocket 消息数
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// 消息计数的 event 标签
+const (
+	MessageReceived  = "received"  // 从客户端收到
+	MessageForwarded = "forwarded" // 放入接收方连接的发送队列或投递到其他节点
+	MessagePersisted = "persisted" // 写入 MongoDB 成功
+	MessageFailed    = "failed"    // 持久化失败或投递失败（发送队列已满、节点间投递失败）
+)
+
+// 数据库查询耗时的 db 标签
+const (
+	MySQL   = "mysql"
+	MongoDB = "mongodb"
+)
+
+var (
+	// WSConnections 当前本节点的 WebSocket 连接数
+	WSConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "polychat_ws_connections",
+		Help: "Number of active WebSocket connections on this node.",
+	})
+
+	// WSRegistrations WebSocket 连接注册总数（包括重连）
+	WSRegistrations = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "polychat_ws_registrations_total",
+		Help: "Total number of WebSocket connections registered on this node.",
+	})
+
+	// Messages 消息计数
+	Messages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "polychat_messages_total",
+		Help: "Messages handled by this node, by message type and event (received, forwarded, persisted, failed).",
+	}, []string{"type", "event"})
+
+	// DBQueryDuration 数据库查询耗时
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "polychat_db_query_duration_seconds",
+		Help:    "Latency of DAO methods, by database and method.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"db", "method"})
+
+	// HTTPRequestDuration HTTP 请求耗时
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "polychat_http_request_duration_seconds",
+		Help:    "Latency of HTTP requests, by method, route template and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// RateLimited 被限流拒绝的次数，rule 为限流规则名
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "polychat_rate_limited_total",
+		Help: "Requests and WebSocket frames rejected by rate limiting, by rule.",
+	}, []string{"rule"})
+)
+
+// otherMessageType 未登记的消息类型统一使用的 type 标签
+const otherMessageType = "other"
+
+// messageTypes 可以作为 type 标签的消息类型，由 SetMessageTypes 登记
+var messageTypes map[string]bool
+
+// SetMessageTypes 登记消息类型，应在包初始化时调用（见 ws 包）。
+// 未登记的类型计入 "other"，防止客户端用任意类型名制造无限多的时间序列。
+func SetMessageTypes(types ...string) {
+	messageTypes = make(map[string]bool, len(types))
+	for _, t := range types {
+		messageTypes[t] = true
+	}
+}
+
+// CountMessage 消息计数加一
+func CountMessage(msgType, event string) {
+	if !messageTypes[msgType] {
+		msgType = otherMessageType
+	}
+	Messages.WithLabelValues(msgType, event).Inc()
+}
+
+// ObserveQuery 开始记录一次数据库查询的耗时，返回的函数在查询结束时调用：
+//
+//	defer metrics.ObserveQuery(metrics.MySQL, "GetUserByID")()
+func ObserveQuery(db, method string) func() {
+	start := time.Now()
+	return func() {
+		DBQueryDuration.WithLabelValues(db, method).Observe(time.Since(start).Seconds())
+	}
+}
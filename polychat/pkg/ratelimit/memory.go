@@ -0,0 +1,85 @@
+package ratelimit
+
+// 进程内的令牌桶存储
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memorySweepInterval 清理闲置令牌桶的间隔
+const memorySweepInterval = time.Minute
+
+// bucket 一个令牌桶，tokens 为 last 时刻的令牌数
+type bucket struct {
+	tokens float64
+	last   time.Time
+	rate   float64
+	burst  int
+}
+
+// refill 按经过的时间补充令牌
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = min(float64(b.burst), b.tokens+elapsed*b.rate)
+	}
+	b.last = now
+}
+
+// MemoryLimiter 进程内的限流器。
+// 令牌桶补满后等同于不存在，会被定期清理，内存占用只与近期活跃的 key 数量有关。
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time // 当前时间，测试中可以替换
+}
+
+// NewMemoryLimiter 创建进程内限流器
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+func (l *MemoryLimiter) Allow(_ context.Context, key string, limit Limit) (Result, error) {
+	if !limit.Enabled() {
+		return Result{Allowed: true}, nil
+	}
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	// 规则变更后按新的参数计算
+	b.rate, b.burst = limit.Rate, limit.Burst
+	b.refill(now)
+
+	if b.tokens < 1 {
+		return Result{RetryAfter: retryAfter(b.tokens, b.rate)}, nil
+	}
+	b.tokens--
+	return Result{Allowed: true, Remaining: int(b.tokens)}, nil
+}
+
+// sweep 删除已经补满的令牌桶，调用方需持有锁
+func (l *MemoryLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < memorySweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fakeClock 测试用的时钟，只在调用 advance 时前进
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestMemoryLimiter() (*MemoryLimiter, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	l := NewMemoryLimiter()
+	l.now = clock.now
+	l.lastSweep = clock.t
+	return l, clock
+}
+
+// allow 调用 Allow，出错时测试失败
+func allow(t *testing.T, l Limiter, key string, limit Limit) Result {
+	t.Helper()
+	res, err := l.Allow(context.Background(), key, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestMemoryLimiterBurst(t *testing.T) {
+	l, _ := newTestMemoryLimiter()
+	limit := Limit{Rate: 1, Burst: 3}
+
+	for i := range limit.Burst {
+		res := allow(t, l, "k", limit)
+		if !res.Allowed || res.Remaining != limit.Burst-1-i {
+			t.Fatalf("第 %d 次: %+v", i+1, res)
+		}
+	}
+	res := allow(t, l, "k", limit)
+	if res.Allowed || res.RetryAfter != time.Second {
+		t.Fatalf("超出瞬时上限: %+v，期望拒绝并在 1s 后重试", res)
+	}
+	// 不同 key 互不影响
+	if res := allow(t, l, "other", limit); !res.Allowed {
+		t.Fatal("其他 key 被限流")
+	}
+}
+
+func TestMemoryLimiterRefill(t *testing.T) {
+	l, clock := newTestMemoryLimiter()
+	limit := Limit{Rate: 2, Burst: 2}
+	allow(t, l, "k", limit)
+	allow(t, l, "k", limit)
+
+	clock.advance(250 * time.Millisecond)
+	if res := allow(t, l, "k", limit); res.Allowed || res.RetryAfter != 250*time.Millisecond {
+		t.Fatalf("补充半个令牌后: %+v", res)
+	}
+	clock.advance(250 * time.Millisecond)
+	if res := allow(t, l, "k", limit); !res.Allowed {
+		t.Fatalf("补充一个令牌后: %+v", res)
+	}
+
+	// 补充的令牌不超过桶的容量
+	clock.advance(time.Hour)
+	for range limit.Burst {
+		if res := allow(t, l, "k", limit); !res.Allowed {
+			t.Fatal("补满后被限流")
+		}
+	}
+	if res := allow(t, l, "k", limit); res.Allowed {
+		t.Fatal("令牌数超过了桶的容量")
+	}
+}
+
+func TestMemoryLimiterDisabled(t *testing.T) {
+	l, _ := newTestMemoryLimiter()
+	for range 100 {
+		if res := allow(t, l, "k", Limit{}); !res.Allowed {
+			t.Fatal("未启用的限制拒绝了请求")
+		}
+	}
+	if len(l.buckets) != 0 {
+		t.Fatal("未启用的限制不应创建令牌桶")
+	}
+}
+
+func TestMemoryLimiterSweep(t *testing.T) {
+	l, clock := newTestMemoryLimiter()
+	slow := Limit{Rate: 1.0 / 3600, Burst: 1}
+	fast := Limit{Rate: 10, Burst: 1}
+	allow(t, l, "slow", slow)
+	allow(t, l, "fast", fast)
+
+	// 未到清理间隔时不清理
+	clock.advance(memorySweepInterval / 2)
+	allow(t, l, "other", fast)
+	if len(l.buckets) != 3 {
+		t.Fatalf("未到清理间隔时有 %d 个令牌桶，期望 3", len(l.buckets))
+	}
+
+	// 清理补满的令牌桶，保留仍在限流中的令牌桶
+	clock.advance(memorySweepInterval)
+	allow(t, l, "new", fast)
+	if _, ok := l.buckets["fast"]; ok {
+		t.Error("已补满的令牌桶没有被清理")
+	}
+	if _, ok := l.buckets["slow"]; !ok {
+		t.Fatal("仍在限流中的令牌桶被清理")
+	}
+	// 清理不影响限流结果
+	if res := allow(t, l, "slow", slow); res.Allowed {
+		t.Fatal("清理后 slow 的限流状态丢失")
+	}
+}
@@ -0,0 +1,139 @@
+// Package ratelimit 提供基于令牌桶的限流。
+//
+// 每个限流对象（如某个IP调用登录接口、某个用户发送聊天消息）对应一个以 key 区分的令牌桶：
+// 桶的容量为 Burst，每秒补充 Rate 个令牌，每次请求消耗一个令牌，桶空时拒绝请求。
+//
+// 提供两种存储后端：
+//   - MemoryLimiter  进程内，单节点部署使用
+//   - RedisLimiter   基于 Redis，多节点部署时各节点共享同一个令牌桶
+//
+// 各条限流规则的参数可通过环境变量 POLYCHAT_RATELIMIT_<规则名> 覆盖，格式见 ParseLimit；
+// POLYCHAT_RATELIMIT_ENABLED=false 关闭全部限流。
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"polychat/pkg/config"
+)
+
+// Limit 令牌桶参数，Rate 或 Burst 不大于 0 时表示不限流
+type Limit struct {
+	Rate  float64 // 每秒补充的令牌数
+	Burst int     // 桶的容量，即允许的瞬时请求数
+}
+
+// Every 返回每 period 允许 n 次请求、瞬时最多 n 次的限制
+func Every(n int, period time.Duration) Limit {
+	return Limit{Rate: float64(n) / period.Seconds(), Burst: n}
+}
+
+// Enabled 是否需要限流
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// String 以 ParseLimit 可解析的格式输出
+func (l Limit) String() string {
+	if !l.Enabled() {
+		return "off"
+	}
+	burst := ":" + strconv.Itoa(l.Burst)
+	if l.Rate == math.Trunc(l.Rate) {
+		return strconv.FormatFloat(l.Rate, 'f', -1, 64) + "/s" + burst
+	}
+	// 次数只能是整数，每秒不足整数次时输出为每个周期 1 次，例如 0.5/s 输出为 1/2s
+	return "1/" + time.Duration(float64(time.Second)/l.Rate).String() + burst
+}
+
+// ParseLimit 解析限流配置：
+//   - "10/m"     每分钟 10 次，瞬时最多 10 次
+//   - "5/s:20"   每秒 5 次，瞬时最多 20 次
+//   - "3/10m"    每 10 分钟 3 次，周期可以是任意时长（支持 "7d" 形式的天数）
+//   - "off" 或 "0"  不限流
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "off" || s == "0" {
+		return Limit{}, nil
+	}
+	spec, burstStr, hasBurst := strings.Cut(s, ":")
+	countStr, periodStr, ok := strings.Cut(spec, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("限流配置 %q 缺少周期，格式应为 次数/周期[:瞬时上限]", s)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(countStr))
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("限流配置 %q 的次数无效", s)
+	}
+	periodStr = strings.TrimSpace(periodStr)
+	// 省略数字的周期（如 "m"）表示 1 个单位
+	if periodStr != "" && (periodStr[0] < '0' || periodStr[0] > '9') {
+		periodStr = "1" + periodStr
+	}
+	period, err := config.ParseDuration(periodStr)
+	if err != nil || period <= 0 {
+		return Limit{}, fmt.Errorf("限流配置 %q 的周期无效", s)
+	}
+	limit := Every(n, period)
+	if hasBurst {
+		limit.Burst, err = strconv.Atoi(strings.TrimSpace(burstStr))
+		if err != nil || limit.Burst <= 0 {
+			return Limit{}, fmt.Errorf("限流配置 %q 的瞬时上限无效", s)
+		}
+	}
+	return limit, nil
+}
+
+// enabled 是否启用限流
+var enabled = config.GetBool("POLYCHAT_RATELIMIT_ENABLED", true)
+
+// Rule 返回名为 name 的限流规则：读取环境变量 POLYCHAT_RATELIMIT_<NAME>，
+// 未设置或格式错误时返回 def。限流整体关闭时返回不限流。
+func Rule(name string, def Limit) Limit {
+	if !enabled {
+		return Limit{}
+	}
+	key := "POLYCHAT_RATELIMIT_" + strings.ToUpper(name)
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	limit, err := ParseLimit(v)
+	if err != nil {
+		slog.Warn("配置项格式错误，使用默认值", "key", key, "value", v, "default", def.String(), "err", err)
+		return def
+	}
+	return limit
+}
+
+// Result 一次限流判断的结果
+type Result struct {
+	Allowed    bool          // 是否放行
+	Remaining  int           // 桶中剩余的令牌数
+	RetryAfter time.Duration // 被拒绝时，距离下一个令牌可用的时间
+}
+
+// Limiter 限流器，key 相同的请求共享同一个令牌桶
+type Limiter interface {
+	// Allow 尝试从 key 对应的令牌桶中取一个令牌。
+	// limit 未启用时总是放行；后端出错时返回错误，由调用方决定是否放行。
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// Default 全局限流器，默认使用进程内存储，多节点部署时在启动时替换为 RedisLimiter
+var Default Limiter = NewMemoryLimiter()
+
+// retryAfter 计算令牌数从 tokens 补充到 1 所需的时间
+func retryAfter(tokens, rate float64) time.Duration {
+	if tokens >= 1 {
+		return 0
+	}
+	return time.Duration(math.Ceil((1 - tokens) / rate * float64(time.Second)))
+}
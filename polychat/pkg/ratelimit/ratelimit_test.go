@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Limit
+	}{
+		{"10/m", Limit{Rate: 10.0 / 60, Burst: 10}},
+		{"5/s:20", Limit{Rate: 5, Burst: 20}},
+		{"3/10m", Limit{Rate: 3.0 / 600, Burst: 3}},
+		{"2/7d", Limit{Rate: 2.0 / (7 * 24 * 3600), Burst: 2}},
+		{"1/h", Limit{Rate: 1.0 / 3600, Burst: 1}},
+		{" 5 / s : 20 ", Limit{Rate: 5, Burst: 20}},
+		{"off", Limit{}},
+		{"0", Limit{}},
+	}
+	for _, tt := range tests {
+		got, err := ParseLimit(tt.in)
+		if err != nil {
+			t.Errorf("ParseLimit(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseLimit(%q) = %+v，期望 %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseLimitInvalid(t *testing.T) {
+	for _, in := range []string{"", "7d", "10", "x/m", "0/m", "-1/m", "10/", "10/0s", "10/-1m", "10/xyz", "5/s:", "5/s:0", "5/s:x"} {
+		if got, err := ParseLimit(in); err == nil {
+			t.Errorf("ParseLimit(%q) = %+v，期望返回错误", in, got)
+		}
+	}
+}
+
+func TestLimitString(t *testing.T) {
+	tests := []struct {
+		limit Limit
+		want  string
+	}{
+		{Limit{Rate: 5, Burst: 20}, "5/s:20"},
+		{Limit{Rate: 0.5, Burst: 3}, "1/2s:3"},
+		{Every(10, time.Minute), "1/6s:10"},
+		{Every(3, 10*time.Minute), "1/3m20s:3"},
+		{Limit{}, "off"},
+	}
+	for _, tt := range tests {
+		got := tt.limit.String()
+		if got != tt.want {
+			t.Errorf("%+v.String() = %q，期望 %q", tt.limit, got, tt.want)
+		}
+		// 输出可以被 ParseLimit 解析回原来的限制
+		parsed, err := ParseLimit(got)
+		if err != nil {
+			t.Fatalf("ParseLimit(%q): %v", got, err)
+		}
+		if parsed.Burst != tt.limit.Burst || math.Abs(parsed.Rate-tt.limit.Rate) > 1e-9 {
+			t.Errorf("ParseLimit(%q) = %+v，期望 %+v", got, parsed, tt.limit)
+		}
+	}
+}
+
+func TestRule(t *testing.T) {
+	if !enabled {
+		t.Skip("POLYCHAT_RATELIMIT_ENABLED=false")
+	}
+	def := Limit{Rate: 1, Burst: 2}
+	t.Setenv("POLYCHAT_RATELIMIT_TEST_RULE", "5/s:20")
+	if got := Rule("test_rule", def); got != (Limit{Rate: 5, Burst: 20}) {
+		t.Errorf("Rule = %+v", got)
+	}
+	t.Setenv("POLYCHAT_RATELIMIT_TEST_RULE", "bad")
+	if got := Rule("test_rule", def); got != def {
+		t.Errorf("格式错误时 Rule = %+v，期望默认值", got)
+	}
+	if got := Rule("test_unset", def); got != def {
+		t.Errorf("未设置时 Rule = %+v，期望默认值", got)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if d := retryAfter(1, 1); d != 0 {
+		t.Errorf("有令牌时 retryAfter = %v", d)
+	}
+	if d := retryAfter(0.5, 2); d != 250*time.Millisecond {
+		t.Errorf("retryAfter(0.5, 2) = %v，期望 250ms", d)
+	}
+}
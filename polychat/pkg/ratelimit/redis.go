@@ -0,0 +1,82 @@
+package ratelimit
+
+// 基于 Redis 的令牌桶存储，多节点共享
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisKeyPrefix 令牌桶键前缀
+const redisKeyPrefix = "polychat:ratelimit:"
+
+// redisTokenBucketScript 原子地补充令牌并尝试取一个令牌。
+// 令牌桶保存为哈希 {tokens, ts}，ts 为上次计算的时间（毫秒）；
+// 过期时间为补满所需的时间，补满的桶等同于不存在，过期后自动删除。
+//
+//	KEYS[1] 令牌桶键
+//	ARGV    rate（每秒）, burst, now（毫秒）
+//
+// 返回 {是否放行, 剩余令牌数（字符串）}
+var redisTokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+if now > ts then
+	tokens = math.min(burst, tokens + (now - ts) / 1000 * rate)
+	ts = now
+end
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", ts)
+redis.call("PEXPIRE", KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}`)
+
+// RedisLimiter 基于 Redis 的限流器，同一个 key 在所有节点上共享令牌桶。
+// 当前时间由调用节点提供，各节点的时钟应保持同步（如启用 NTP）。
+type RedisLimiter struct {
+	client *redis.Client
+	now    func() time.Time // 当前时间，测试中可以替换
+}
+
+// NewRedisLimiter 基于已建立的 Redis 连接创建限流器
+func NewRedisLimiter(client *redis.Client) *RedisLimiter {
+	return &RedisLimiter{client: client, now: time.Now}
+}
+
+func (l *RedisLimiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	if !limit.Enabled() {
+		return Result{Allowed: true}, nil
+	}
+	now := l.now().UnixMilli()
+	res, err := redisTokenBucketScript.Run(ctx, l.client, []string{redisKeyPrefix + key},
+		limit.Rate, limit.Burst, now).Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	allowed, _ := res[0].(int64)
+	tokensStr, _ := res[1].(string)
+	tokens, err := strconv.ParseFloat(tokensStr, 64)
+	if err != nil {
+		return Result{}, err
+	}
+	if allowed == 0 {
+		return Result{RetryAfter: retryAfter(tokens, limit.Rate)}, nil
+	}
+	return Result{Allowed: true, Remaining: int(math.Floor(tokens))}, nil
+}
@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestRedisLimiter 连接本地 Redis 创建限流器，未设置环境变量 POLYCHAT_TEST_REDIS（例如 127.0.0.1:6379）时跳过。
+// 返回本次测试使用的 key，测试结束后删除。
+func newTestRedisLimiter(t *testing.T) (*RedisLimiter, *fakeClock, string) {
+	t.Helper()
+	addr := os.Getenv("POLYCHAT_TEST_REDIS")
+	if addr == "" {
+		t.Skip("未设置 POLYCHAT_TEST_REDIS，跳过 Redis 测试")
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() { client.Close() })
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Fatalf("连接 Redis 失败: %v", err)
+	}
+
+	key := fmt.Sprintf("test:%s:%d", t.Name(), time.Now().UnixNano())
+	t.Cleanup(func() { client.Del(context.Background(), redisKeyPrefix+key) })
+
+	clock := &fakeClock{t: time.Now()}
+	l := NewRedisLimiter(client)
+	l.now = clock.now
+	return l, clock, key
+}
+
+func TestRedisLimiterBurst(t *testing.T) {
+	l, _, key := newTestRedisLimiter(t)
+	limit := Limit{Rate: 1, Burst: 3}
+
+	for i := range limit.Burst {
+		res := allow(t, l, key, limit)
+		if !res.Allowed || res.Remaining != limit.Burst-1-i {
+			t.Fatalf("第 %d 次: %+v", i+1, res)
+		}
+	}
+	res := allow(t, l, key, limit)
+	if res.Allowed || res.RetryAfter != time.Second {
+		t.Fatalf("超出瞬时上限: %+v，期望拒绝并在 1s 后重试", res)
+	}
+}
+
+func TestRedisLimiterRefill(t *testing.T) {
+	l, clock, key := newTestRedisLimiter(t)
+	limit := Limit{Rate: 2, Burst: 2}
+	allow(t, l, key, limit)
+	allow(t, l, key, limit)
+
+	clock.advance(250 * time.Millisecond)
+	if res := allow(t, l, key, limit); res.Allowed || res.RetryAfter != 250*time.Millisecond {
+		t.Fatalf("补充半个令牌后: %+v", res)
+	}
+	clock.advance(250 * time.Millisecond)
+	if res := allow(t, l, key, limit); !res.Allowed {
+		t.Fatalf("补充一个令牌后: %+v", res)
+	}
+
+	// 补充的令牌不超过桶的容量
+	clock.advance(time.Hour)
+	for range limit.Burst {
+		if res := allow(t, l, key, limit); !res.Allowed {
+			t.Fatal("补满后被限流")
+		}
+	}
+	if res := allow(t, l, key, limit); res.Allowed {
+		t.Fatal("令牌数超过了桶的容量")
+	}
+}
+
+func TestRedisLimiterExpire(t *testing.T) {
+	l, _, key := newTestRedisLimiter(t)
+	allow(t, l, key, Limit{Rate: 1, Burst: 2})
+
+	// 过期时间为补满所需的时间加 1 秒
+	ttl := l.client.PTTL(context.Background(), redisKeyPrefix+key).Val()
+	if ttl <= time.Second || ttl > 2*time.Second {
+		t.Fatalf("令牌桶的过期时间为 %v，期望 (1s, 2s]", ttl)
+	}
+}
@@ -0,0 +1,109 @@
+// Package telemetry 初始化 OpenTelemetry 链路追踪。
+//
+// 配置项：
+//   - POLYCHAT_TRACING_EXPORTER  导出方式：none（默认，不采集）、otlp、stdout（本地调试，输出到标准输出）
+//   - POLYCHAT_OTLP_ENDPOINT     OTLP/HTTP 接收端地址（host:port），默认 localhost:4318；
+//     也可以使用标准的 OTEL_EXPORTER_OTLP_* 环境变量
+//   - POLYCHAT_OTLP_INSECURE     是否使用明文 HTTP 连接接收端，默认 true
+//   - POLYCHAT_TRACING_SAMPLE_RATIO  根 span 的采样比例（0~1），默认 1；已有上游采样决定时沿用上游
+//
+// HTTP 路由、WebSocket 消息、MySQL 和 MongoDB 调用的 span 都通过 context 串联，
+// 日志中的 trace_id / span_id 由 logger 包从同一个 context 中读取。
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"polychat/pkg/config"
+	"polychat/pkg/version"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName 上报的服务名
+const ServiceName = "polychat"
+
+// tracerName 本项目手动创建的 span 使用的 tracer 名称
+const tracerName = "polychat"
+
+// Tracer 返回本项目使用的 tracer。未启用追踪时返回的 tracer 不做任何事情。
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Init 按配置初始化全局 TracerProvider 和传播器，返回用于刷新并关闭导出器的函数。
+// 未启用追踪时返回的关闭函数什么也不做。
+func Init(ctx context.Context, nodeID string) (func(context.Context) error, error) {
+	// 无论是否启用导出，都解析上游传入的 traceparent，保证 trace ID 能在日志中串联
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch kind := strings.ToLower(config.GetString("POLYCHAT_TRACING_EXPORTER", "none")); kind {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		opts := []otlptracehttp.Option{
+			otlptracehttp.WithEndpoint(config.GetString("POLYCHAT_OTLP_ENDPOINT", "localhost:4318")),
+		}
+		if config.GetBool("POLYCHAT_OTLP_INSECURE", true) {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("未知的追踪导出方式 %q，可选值: none, otlp, stdout", kind)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("创建追踪导出器失败: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(ServiceName),
+		semconv.ServiceVersion(version.Get().Version),
+		semconv.ServiceInstanceID(nodeID),
+		attribute.String("vcs.revision", version.Get().Commit),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("创建追踪资源失败: %w", err)
+	}
+
+	ratio := sampleRatio()
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Warn("链路追踪导出失败", "err", err)
+	}))
+	slog.Info("链路追踪已启用", "sample_ratio", ratio)
+	return tp.Shutdown, nil
+}
+
+// sampleRatio 读取采样比例，超出 [0, 1] 时使用默认值 1
+func sampleRatio() float64 {
+	s := config.GetString("POLYCHAT_TRACING_SAMPLE_RATIO", "1")
+	ratio, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || ratio < 0 || ratio > 1 {
+		slog.Warn("配置项格式错误，使用默认值", "key", "POLYCHAT_TRACING_SAMPLE_RATIO", "value", s, "default", 1)
+		return 1
+	}
+	return ratio
+}
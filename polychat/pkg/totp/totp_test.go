@@ -0,0 +1,139 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret RFC 6238 附录 B 中 SHA1 测试使用的密钥 "12345678901234567890"
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+// RFC 6238 附录 B 的 SHA1 测试向量。RFC 给出的是 8 位验证码，6 位验证码是其后 6 位。
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "94287082"},
+	{1111111109, "07081804"},
+	{1111111111, "14050471"},
+	{1234567890, "89005924"},
+	{2000000000, "69279037"},
+	{20000000000, "65353130"},
+}
+
+func TestCodeRFC6238(t *testing.T) {
+	for _, tt := range rfcVectors {
+		want := tt.code[len(tt.code)-Digits:]
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("T=%d: %v", tt.unix, err)
+		}
+		if got != want {
+			t.Errorf("T=%d: Code = %s，期望 %s", tt.unix, got, want)
+		}
+	}
+}
+
+func TestCodeSecretFormat(t *testing.T) {
+	// 用户手动输入的密钥可能是小写
+	lower, err := Code(strings.ToLower(rfcSecret), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upper, _ := Code(rfcSecret, 1)
+	if lower != upper {
+		t.Fatalf("小写密钥的验证码 %s 与大写 %s 不一致", lower, upper)
+	}
+
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Fatal("非法密钥应返回错误")
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+	codeAt := func(step int64) string {
+		code, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"当前时间步", codeAt(current), 0, current, true},
+		{"上一个时间步", codeAt(current - 1), 0, current - 1, true},
+		{"下一个时间步", codeAt(current + 1), 0, current + 1, true},
+		{"超出窗口（早）", codeAt(current - 2), 0, 0, false},
+		{"超出窗口（晚）", codeAt(current + 2), 0, 0, false},
+		{"前后带空白", " " + codeAt(current) + "\n", 0, current, true},
+		{"位数错误", codeAt(current)[:Digits-1], 0, 0, false},
+		{"错误的验证码", wrongCode(codeAt(current)), 0, 0, false},
+		// 已使用的时间步不能再次使用
+		{"重放当前时间步", codeAt(current), current, 0, false},
+		{"重放更早的时间步", codeAt(current - 1), current, 0, false},
+		{"上次使用之后的时间步", codeAt(current + 1), current, current + 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, now, tt.lastStep)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Fatalf("Validate = (%d, %t)，期望 (%d, %t)", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+// wrongCode 把验证码的最后一位加一，得到一个不同的验证码
+func wrongCode(code string) string {
+	last := code[len(code)-1]
+	return code[:len(code)-1] + string('0'+(last-'0'+1)%10)
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := GenerateSecret()
+	if a == b {
+		t.Fatal("两次生成的密钥相同")
+	}
+	key, err := encoding.DecodeString(a)
+	if err != nil {
+		t.Fatalf("密钥不是合法的 base32: %v", err)
+	}
+	if len(key) != secretSize {
+		t.Fatalf("密钥长度 %d 字节，期望 %d", len(key), secretSize)
+	}
+	if strings.Contains(a, "=") {
+		t.Fatal("密钥不应带填充")
+	}
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(URI("polychat", "alice", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/polychat:alice" {
+		t.Fatalf("URI = %s", u)
+	}
+	q := u.Query()
+	want := map[string]string{"secret": rfcSecret, "issuer": "polychat", "algorithm": "SHA1", "digits": "6", "period": "30"}
+	for k, v := range want {
+		if q.Get(k) != v {
+			t.Errorf("%s = %q，期望 %q", k, q.Get(k), v)
+		}
+	}
+}
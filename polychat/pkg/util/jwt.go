@@ -6,51 +6,160 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// jwtSecret 密钥
-var jwtSecret = []byte("polychat_secret_key_2026")
-
 // Claims 自定义载荷结构体
 type Claims struct {
 	UserID uint `json:"user_id"`
+	// TokenVersion 签发时用户的 token 版本。用户修改或重置密码后版本递增，之前签发的 token 全部失效。
+	TokenVersion uint `json:"ver"`
+	// Purpose 非会话用途的 token 填写用途（如 PurposeTwoFactor），会话 token 为空。
+	// ParseToken 只接受会话 token，防止其他用途的 token 被当作登录凭证使用。
+	Purpose string `json:"purpose,omitempty"`
+	// Role 会话 token 签发时用户的角色，供客户端和其他服务使用。
+	// 修改角色时 token 版本递增，因此有效 token 中的角色总是用户当前的角色
+	Role string `json:"role,omitempty"`
+	// Email 邮箱验证 token 对应的邮箱，用户修改邮箱后旧的验证链接失效
+	Email string `json:"email,omitempty"`
+	// State、Nonce、Verifier 外部登录（OIDC）发起时生成的 state、nonce 和 PKCE code_verifier，
+	// 回调时用于校验；UserID 不为 0 表示为该用户绑定外部账号
+	State    string `json:"state,omitempty"`
+	Nonce    string `json:"nonce,omitempty"`
+	Verifier string `json:"verifier,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// GenerateToken 生成 Token，有效期 24 小时
-func GenerateToken(userID uint) (string, error) {
+// 会话 token 的 aud。其他用途的 token 的 aud 为 "polychat/<用途>"，与会话 token 不同。
+// 通过 JWKS 验证 polychat token 的其他服务除了签名、exp 和 iss 之外，必须校验 aud 等于 SessionAudience，
+// 否则两步验证 challenge token、重置密码 token 等会被当作登录凭证接受。
+const SessionAudience = "polychat"
+
+// tokenIssuer token 的签发人
+const tokenIssuer = "polychat"
+
+// 非会话 token 的用途
+const (
+	PurposeTwoFactor     = "2fa"            // 两步验证 challenge token：密码校验已通过，等待提交验证码
+	PurposePasswordReset = "password_reset" // 重置密码 token，随邮件发送
+	PurposeEmailVerify   = "email_verify"   // 邮箱验证 token，随邮件发送
+	PurposeOIDCState     = "oidc_state"     // 外部登录的状态 token，保存在 cookie 中，回调时取回
+)
+
+const (
+	// SessionTTL 会话 token 的有效期
+	SessionTTL = 24 * time.Hour
+	// challengeTTL 两步验证 challenge token 的有效期
+	challengeTTL = 5 * time.Minute
+	// passwordResetTTL 重置密码 token 的有效期
+	passwordResetTTL = 30 * time.Minute
+	// emailVerifyTTL 邮箱验证 token 的有效期
+	emailVerifyTTL = 24 * time.Hour
+	// oidcStateTTL 外部登录状态 token 的有效期，用户需要在这段时间内完成身份提供方的登录
+	oidcStateTTL = 10 * time.Minute
+)
+
+// GenerateToken 生成会话 Token，有效期 24 小时
+func GenerateToken(userID, tokenVersion uint, role string) (string, error) {
+	return signToken(Claims{UserID: userID, TokenVersion: tokenVersion, Role: role}, SessionTTL)
+}
+
+// GenerateChallengeToken 生成两步验证的 challenge token，有效期 5 分钟，不能用于访问接口
+func GenerateChallengeToken(userID, tokenVersion uint) (string, error) {
+	return signToken(Claims{UserID: userID, TokenVersion: tokenVersion, Purpose: PurposeTwoFactor}, challengeTTL)
+}
+
+// GeneratePasswordResetToken 生成重置密码 token，有效期 30 分钟。
+// 重置成功后用户的 token 版本递增，同一个重置 token 不能再次使用。
+func GeneratePasswordResetToken(userID, tokenVersion uint) (string, error) {
+	return signToken(Claims{UserID: userID, TokenVersion: tokenVersion, Purpose: PurposePasswordReset}, passwordResetTTL)
+}
+
+// GenerateEmailVerifyToken 生成邮箱验证 token，有效期 24 小时
+func GenerateEmailVerifyToken(userID uint, email string) (string, error) {
+	return signToken(Claims{UserID: userID, Purpose: PurposeEmailVerify, Email: email}, emailVerifyTTL)
+}
+
+// GenerateOIDCStateToken 生成外部登录的状态 token，有效期 10 分钟。
+// userID 为 0 表示登录；不为 0 表示为该用户绑定外部账号，此时 tokenVersion 为用户当前的 token 版本。
+func GenerateOIDCStateToken(userID, tokenVersion uint, state, nonce, verifier string) (string, error) {
+	return signToken(Claims{
+		UserID:       userID,
+		TokenVersion: tokenVersion,
+		Purpose:      PurposeOIDCState,
+		State:        state,
+		Nonce:        nonce,
+		Verifier:     verifier,
+	}, oidcStateTTL)
+}
+
+// tokenAudience 返回该用途的 token 的 aud，会话 token（purpose 为空）为 SessionAudience
+func tokenAudience(purpose string) string {
+	if purpose == "" {
+		return SessionAudience
+	}
+	return SessionAudience + "/" + purpose
+}
+
+// signToken 填写签发时间、过期时间、签发人和 aud 后签名
+func signToken(claims Claims, ttl time.Duration) (string, error) {
 	now := time.Now()
-	claims := Claims{
-		UserID: userID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(now.Add(24 * time.Hour)), // 过期时间 24 小时
-			IssuedAt:  jwt.NewNumericDate(now),                     // 签发时间
-			Issuer:    "polychat",                                  // 签发人
-		},
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),                // 过期时间
+		IssuedAt:  jwt.NewNumericDate(now),                         // 签发时间
+		Issuer:    tokenIssuer,                                     // 签发人
+		Audience:  jwt.ClaimStrings{tokenAudience(claims.Purpose)}, // 用途
 	}
 
-	// 使用 HS256 签名算法
-	tokenClaims := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	// 使用当前的签名密钥，非对称密钥在头部写入 kid，验证方据此选择公钥
+	method, kid, key, err := signingMaterial()
+	if err != nil {
+		return "", err
+	}
+	tokenClaims := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		tokenClaims.Header["kid"] = kid
+	}
 
 	// 生成签名后的 Token 字符串
-	return tokenClaims.SignedString(jwtSecret)
+	return tokenClaims.SignedString(key)
 }
 
-// ParseToken 解析 Token
+// ParseToken 解析会话 Token，challenge token 等其他用途的 token 会被拒绝
 func ParseToken(tokenString string) (*Claims, error) {
+	return parseToken(tokenString, "")
+}
+
+// ParseChallengeToken 解析两步验证的 challenge token
+func ParseChallengeToken(tokenString string) (*Claims, error) {
+	return parseToken(tokenString, PurposeTwoFactor)
+}
+
+// ParsePasswordResetToken 解析重置密码 token
+func ParsePasswordResetToken(tokenString string) (*Claims, error) {
+	return parseToken(tokenString, PurposePasswordReset)
+}
+
+// ParseEmailVerifyToken 解析邮箱验证 token
+func ParseEmailVerifyToken(tokenString string) (*Claims, error) {
+	return parseToken(tokenString, PurposeEmailVerify)
+}
+
+// ParseOIDCStateToken 解析外部登录的状态 token
+func ParseOIDCStateToken(tokenString string) (*Claims, error) {
+	return parseToken(tokenString, PurposeOIDCState)
+}
+
+// parseToken 解析 Token 并校验签发人、aud 和用途
+func parseToken(tokenString, purpose string) (*Claims, error) {
 	// 解析 Token
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		// 验证签名方法是否为 HMAC
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, jwt.ErrSignatureInvalid
-		}
-		return jwtSecret, nil
-	})
+	// 按头部的 alg 和 kid 选择密钥，见 verificationKey
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, verificationKey,
+		jwt.WithIssuer(tokenIssuer), jwt.WithAudience(tokenAudience(purpose)))
 
 	if err != nil {
 		return nil, err
 	}
 
 	// 验证 Token 是否有效并提取 Claims
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+	if claims, ok := token.Claims.(*Claims); ok && token.Valid && claims.Purpose == purpose {
 		return claims, nil
 	}
 
@@ -0,0 +1,266 @@
+package util
+
+// JWT 签名密钥的加载、轮换和公开（JWKS）。
+//
+// 配置项：
+//   - POLYCHAT_JWT_KEYS_DIR     密钥目录，每个 <kid>.pem 文件是一个密钥，文件名即 JWT 头部的 kid。
+//     支持 RSA（至少 2048 位，RS256）和 Ed25519（EdDSA）私钥，PEM 类型为 PRIVATE KEY（PKCS#8）
+//     或 RSA PRIVATE KEY（PKCS#1）；PUBLIC KEY 类型的文件只用于验证，不能签名。
+//     未设置时使用 HS256 和 POLYCHAT_JWT_SECRET 签名，JWKS 为空；两者都未设置时拒绝启动。
+//   - POLYCHAT_JWT_SIGNING_KEY  签发新 token 使用的 kid，默认为目录中文件名按字典序最大的私钥，
+//     因此建议用日期命名密钥（如 2026-10-19.pem）
+//   - POLYCHAT_JWT_SECRET       HS256 密钥，没有默认值，建议至少 32 字节的随机字符串
+//   - POLYCHAT_JWT_ACCEPT_HMAC  配置了密钥目录后是否仍接受 HS256 签名的 token（默认 false），
+//     从 HS256 迁移时可以临时开启（需要同时设置 POLYCHAT_JWT_SECRET），避免已登录的用户掉线；
+//     迁移超过会话有效期（24 小时）后应关闭
+//
+// 轮换密钥：把新密钥放入所有节点的密钥目录并重新加载（发送 SIGHUP 或重启），此时新旧密钥都能验证；
+// 确认所有节点都已加载后再把 POLYCHAT_JWT_SIGNING_KEY 指向新密钥（或新密钥的 kid 本来就最大）；
+// 旧密钥签发的 token 全部过期后再删除旧密钥。其他服务通过 /.well-known/jwks.json 获取公钥验证 token。
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"polychat/pkg/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minHMACSecretLen 建议的 HS256 密钥最小长度，短于该长度时只记录警告
+const minHMACSecretLen = 32
+
+// errNoSigningKey 没有可用的签名密钥（InitKeys 之前，或配置缺失）
+var errNoSigningKey = errors.New("未配置 JWT 签名密钥")
+
+// minRSABits RSA 密钥的最小长度
+const minRSABits = 2048
+
+// jwtKey 一个非对称密钥，private 为空表示只用于验证
+type jwtKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// keyring 当前生效的全部密钥，重新加载时整体替换
+type keyring struct {
+	signing    *jwtKey // 签发新 token 使用的密钥，为空时使用 HS256
+	keys       map[string]*jwtKey
+	hmacSecret []byte // 为空时不签发也不接受 HS256 token
+	acceptHMAC bool
+}
+
+// currentKeys 当前生效的密钥，InitKeys 之前没有任何密钥，签发和验证都会失败
+var currentKeys atomic.Pointer[keyring]
+
+func init() {
+	currentKeys.Store(&keyring{keys: map[string]*jwtKey{}})
+}
+
+// InitKeys 按配置加载签名密钥，可重复调用以重新加载（轮换密钥）。
+// 加载失败或没有配置任何密钥时返回错误，之前生效的密钥保持不变。
+func InitKeys() error {
+	ring := &keyring{
+		keys:       map[string]*jwtKey{},
+		hmacSecret: []byte(config.GetString("POLYCHAT_JWT_SECRET", "")),
+	}
+	if len(ring.hmacSecret) > 0 && len(ring.hmacSecret) < minHMACSecretLen {
+		slog.Warn("POLYCHAT_JWT_SECRET 过短，建议使用至少 32 字节的随机字符串", "len", len(ring.hmacSecret))
+	}
+
+	dir := config.GetString("POLYCHAT_JWT_KEYS_DIR", "")
+	if dir == "" {
+		if len(ring.hmacSecret) == 0 {
+			return fmt.Errorf("%w：请设置 POLYCHAT_JWT_KEYS_DIR 或 POLYCHAT_JWT_SECRET", errNoSigningKey)
+		}
+		ring.acceptHMAC = true
+		currentKeys.Store(ring)
+		slog.Info("JWT 使用 HS256 签名")
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		key, err := loadJWTKey(path)
+		if err != nil {
+			return fmt.Errorf("加载 JWT 密钥 %s 失败: %w", path, err)
+		}
+		ring.keys[key.kid] = key
+	}
+
+	signingKID := config.GetString("POLYCHAT_JWT_SIGNING_KEY", "")
+	if signingKID == "" {
+		kids := make([]string, 0, len(ring.keys))
+		for kid, key := range ring.keys {
+			if key.private != nil {
+				kids = append(kids, kid)
+			}
+		}
+		if len(kids) == 0 {
+			return fmt.Errorf("JWT 密钥目录 %s 中没有可用于签名的私钥", dir)
+		}
+		sort.Strings(kids)
+		signingKID = kids[len(kids)-1]
+	}
+	ring.signing = ring.keys[signingKID]
+	if ring.signing == nil || ring.signing.private == nil {
+		return fmt.Errorf("JWT 签名密钥 %q 不存在或没有私钥", signingKID)
+	}
+
+	ring.acceptHMAC = config.GetBool("POLYCHAT_JWT_ACCEPT_HMAC", false)
+	if ring.acceptHMAC && len(ring.hmacSecret) == 0 {
+		return errors.New("POLYCHAT_JWT_ACCEPT_HMAC=true 时必须设置 POLYCHAT_JWT_SECRET")
+	}
+	currentKeys.Store(ring)
+
+	kids := make([]string, 0, len(ring.keys))
+	for kid := range ring.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+	slog.Info("JWT 密钥已加载", "signing_kid", signingKID, "alg", ring.signing.method.Alg(), "kids", kids)
+	if ring.acceptHMAC {
+		slog.Warn("仍接受 HS256 签名的 token，迁移超过 24 小时后请设置 POLYCHAT_JWT_ACCEPT_HMAC=false")
+	}
+	return nil
+}
+
+// loadJWTKey 读取一个 PEM 格式的密钥文件，文件名（去掉 .pem）作为 kid
+func loadJWTKey(path string) (*jwtKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("不是 PEM 格式")
+	}
+
+	key := &jwtKey{kid: strings.TrimSuffix(filepath.Base(path), ".pem")}
+	var parsed any
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("不支持的 PEM 类型 %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.private, key.public = k, &k.PublicKey
+	case ed25519.PrivateKey:
+		key.private, key.public = k, k.Public()
+	case *rsa.PublicKey, ed25519.PublicKey:
+		key.public = k
+	default:
+		return nil, fmt.Errorf("不支持的密钥类型 %T，只支持 RSA 和 Ed25519", parsed)
+	}
+
+	switch pub := key.public.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA 密钥长度 %d 位，至少需要 %d 位", pub.N.BitLen(), minRSABits)
+		}
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.method = jwt.SigningMethodEdDSA
+	}
+	return key, nil
+}
+
+// signingMaterial 返回签发新 token 使用的签名方法、kid（HS256 时为空）和密钥
+func signingMaterial() (jwt.SigningMethod, string, any, error) {
+	ring := currentKeys.Load()
+	if ring.signing != nil {
+		return ring.signing.method, ring.signing.kid, ring.signing.private, nil
+	}
+	if len(ring.hmacSecret) == 0 {
+		return nil, "", nil, errNoSigningKey
+	}
+	return jwt.SigningMethodHS256, "", ring.hmacSecret, nil
+}
+
+// verificationKey 根据 token 头部的 alg 和 kid 选择验证签名的密钥，用作 jwt.Keyfunc
+func verificationKey(token *jwt.Token) (any, error) {
+	ring := currentKeys.Load()
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if !ring.acceptHMAC || len(ring.hmacSecret) == 0 || token.Method != jwt.SigningMethodHS256 {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return ring.hmacSecret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key := ring.keys[kid]
+	// 签名方法必须与密钥类型一致，防止用 RSA 公钥冒充其他算法的密钥
+	if key == nil || token.Method != key.method {
+		return nil, jwt.ErrTokenUnverifiable
+	}
+	return key.public, nil
+}
+
+// JWK 一个 JSON Web Key（RFC 7517），只包含公钥
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA 公钥
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519 公钥（RFC 8037）
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSet JSON Web Key Set，/.well-known/jwks.json 的响应
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS 返回当前全部非对称密钥的公钥（包括只用于验证的密钥），按 kid 排序。
+// 使用 HS256 签名时返回空集合，HS256 密钥不能公开。
+func JWKS() JWKSet {
+	ring := currentKeys.Load()
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range ring.keys {
+		jwk := JWK{Kid: key.kid, Use: "sig", Alg: key.method.Alg()}
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
@@ -0,0 +1,401 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testSecret 测试使用的 HS256 密钥
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+var (
+	rsaKeyOnce sync.Once
+	rsaKey     *rsa.PrivateKey
+)
+
+// testRSAKey 返回测试共用的 2048 位 RSA 私钥，生成 RSA 密钥较慢
+func testRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	rsaKeyOnce.Do(func() {
+		var err error
+		if rsaKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return rsaKey
+}
+
+func testEd25519Key(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv
+}
+
+// writePEM 把 der 以 PEM 格式写入 dir/<kid>.pem
+func writePEM(t *testing.T, dir, kid, typ string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, kid+".pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writePKCS8 把私钥以 PKCS#8 格式写入 dir/<kid>.pem
+func writePKCS8(t *testing.T, dir, kid string, key any) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, dir, kid, "PRIVATE KEY", der)
+}
+
+// useKeys 按给定的配置重新加载密钥，测试结束后恢复之前的密钥。
+// env 中未列出的 JWT 配置项都视为未设置。
+func useKeys(t *testing.T, env map[string]string) error {
+	t.Helper()
+	prev := currentKeys.Load()
+	t.Cleanup(func() { currentKeys.Store(prev) })
+	for _, key := range []string{"POLYCHAT_JWT_KEYS_DIR", "POLYCHAT_JWT_SIGNING_KEY", "POLYCHAT_JWT_SECRET", "POLYCHAT_JWT_ACCEPT_HMAC"} {
+		t.Setenv(key, env[key])
+		if _, ok := env[key]; !ok {
+			os.Unsetenv(key)
+		}
+	}
+	return InitKeys()
+}
+
+func mustUseKeys(t *testing.T, env map[string]string) {
+	t.Helper()
+	if err := useKeys(t, env); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// signRaw 用指定的方法、kid 和密钥对任意载荷签名，用于构造不是由 signToken 签发的 token
+func signRaw(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// sessionClaims 与 GenerateToken 签发的会话 token 相同的载荷
+func sessionClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"user_id": 1,
+		"iss":     tokenIssuer,
+		"aud":     SessionAudience,
+		"iat":     time.Now().Unix(),
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestLoadJWTKey(t *testing.T) {
+	dir := t.TempDir()
+	rsaPriv := testRSAKey(t)
+	edPriv := testEd25519Key(t)
+	rsaPub, _ := x509.MarshalPKIXPublicKey(&rsaPriv.PublicKey)
+	edPub, _ := x509.MarshalPKIXPublicKey(edPriv.Public())
+	smallRSA, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	smallRSAPub, _ := x509.MarshalPKIXPublicKey(&smallRSA.PublicKey)
+	ecPriv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	tests := []struct {
+		name        string
+		path        string
+		wantMethod  jwt.SigningMethod
+		wantPrivate bool
+		wantErr     bool
+	}{
+		{"RSA PKCS#8", writePKCS8(t, dir, "rsa-pkcs8", rsaPriv), jwt.SigningMethodRS256, true, false},
+		{"RSA PKCS#1", writePEM(t, dir, "rsa-pkcs1", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaPriv)), jwt.SigningMethodRS256, true, false},
+		{"Ed25519", writePKCS8(t, dir, "ed", edPriv), jwt.SigningMethodEdDSA, true, false},
+		{"RSA 公钥只用于验证", writePEM(t, dir, "rsa-pub", "PUBLIC KEY", rsaPub), jwt.SigningMethodRS256, false, false},
+		{"Ed25519 公钥只用于验证", writePEM(t, dir, "ed-pub", "PUBLIC KEY", edPub), jwt.SigningMethodEdDSA, false, false},
+		{"RSA 不足 2048 位", writePKCS8(t, dir, "rsa-1024", smallRSA), nil, false, true},
+		{"RSA PKCS#1 不足 2048 位", writePEM(t, dir, "rsa-1024-pkcs1", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(smallRSA)), nil, false, true},
+		{"RSA 公钥不足 2048 位", writePEM(t, dir, "rsa-1024-pub", "PUBLIC KEY", smallRSAPub), nil, false, true},
+		{"不支持的 ECDSA 密钥", writePKCS8(t, dir, "ec", ecPriv), nil, false, true},
+		{"不支持的 PEM 类型", writePEM(t, dir, "cert", "CERTIFICATE", []byte("x")), nil, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := loadJWTKey(tt.path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("期望返回错误")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key.kid != filepath.Base(tt.path[:len(tt.path)-len(".pem")]) {
+				t.Errorf("kid = %q", key.kid)
+			}
+			if key.method != tt.wantMethod {
+				t.Errorf("method = %v，期望 %v", key.method.Alg(), tt.wantMethod.Alg())
+			}
+			if (key.private != nil) != tt.wantPrivate {
+				t.Errorf("private = %v，期望有私钥: %t", key.private != nil, tt.wantPrivate)
+			}
+		})
+	}
+
+	notPEM := filepath.Join(dir, "plain.pem")
+	os.WriteFile(notPEM, []byte("not a key"), 0o600)
+	if _, err := loadJWTKey(notPEM); err == nil {
+		t.Error("非 PEM 文件应返回错误")
+	}
+}
+
+func TestInitKeysRequiresKey(t *testing.T) {
+	if err := useKeys(t, nil); !errors.Is(err, errNoSigningKey) {
+		t.Fatalf("未配置密钥: err = %v，期望 errNoSigningKey", err)
+	}
+
+	// 目录中只有公钥时不能签名
+	dir := t.TempDir()
+	pub, _ := x509.MarshalPKIXPublicKey(testEd25519Key(t).Public())
+	writePEM(t, dir, "pub", "PUBLIC KEY", pub)
+	if err := useKeys(t, map[string]string{"POLYCHAT_JWT_KEYS_DIR": dir}); err == nil {
+		t.Fatal("只有公钥时应返回错误")
+	}
+
+	// 接受 HS256 时必须设置密钥
+	writePKCS8(t, dir, "k1", testEd25519Key(t))
+	if err := useKeys(t, map[string]string{"POLYCHAT_JWT_KEYS_DIR": dir, "POLYCHAT_JWT_ACCEPT_HMAC": "true"}); err == nil {
+		t.Fatal("POLYCHAT_JWT_ACCEPT_HMAC=true 且未设置密钥时应返回错误")
+	}
+
+	// 指定的签名密钥必须存在且有私钥
+	if err := useKeys(t, map[string]string{"POLYCHAT_JWT_KEYS_DIR": dir, "POLYCHAT_JWT_SIGNING_KEY": "pub"}); err == nil {
+		t.Fatal("签名密钥只有公钥时应返回错误")
+	}
+}
+
+func TestHMACOnly(t *testing.T) {
+	mustUseKeys(t, map[string]string{"POLYCHAT_JWT_SECRET": testSecret})
+
+	token, err := GenerateToken(1, 2, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ParseToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID != 1 || claims.TokenVersion != 2 || claims.Role != "admin" {
+		t.Fatalf("claims = %+v", claims)
+	}
+	// HS256 密钥不能公开
+	if keys := JWKS().Keys; len(keys) != 0 {
+		t.Fatalf("使用 HS256 时 JWKS 应为空，实际 %d 个", len(keys))
+	}
+}
+
+func TestVerificationKey(t *testing.T) {
+	dir := t.TempDir()
+	rsaPriv := testRSAKey(t)
+	edPriv := testEd25519Key(t)
+	writePKCS8(t, dir, "rsa", rsaPriv)
+	writePKCS8(t, dir, "ed", edPriv)
+	keysOnly := map[string]string{"POLYCHAT_JWT_KEYS_DIR": dir, "POLYCHAT_JWT_SECRET": testSecret}
+	acceptHMAC := map[string]string{"POLYCHAT_JWT_KEYS_DIR": dir, "POLYCHAT_JWT_SECRET": testSecret, "POLYCHAT_JWT_ACCEPT_HMAC": "true"}
+
+	hs256 := signRaw(t, jwt.SigningMethodHS256, "", []byte(testSecret), sessionClaims())
+	tests := []struct {
+		name   string
+		env    map[string]string
+		token  string
+		wantOK bool
+	}{
+		{"RS256", keysOnly, signRaw(t, jwt.SigningMethodRS256, "rsa", rsaPriv, sessionClaims()), true},
+		{"EdDSA", keysOnly, signRaw(t, jwt.SigningMethodEdDSA, "ed", edPriv, sessionClaims()), true},
+		{"未知的 kid", keysOnly, signRaw(t, jwt.SigningMethodEdDSA, "other", edPriv, sessionClaims()), false},
+		{"缺少 kid", keysOnly, signRaw(t, jwt.SigningMethodEdDSA, "", edPriv, sessionClaims()), false},
+		// alg 与 kid 对应的密钥类型不一致
+		{"RSA 密钥使用 PS256", keysOnly, signRaw(t, jwt.SigningMethodPS256, "rsa", rsaPriv, sessionClaims()), false},
+		{"Ed25519 的 kid 使用 RS256", keysOnly, signRaw(t, jwt.SigningMethodRS256, "ed", rsaPriv, sessionClaims()), false},
+		// 用公开的 RSA 公钥作为 HMAC 密钥伪造 token
+		{"RSA 公钥作为 HMAC 密钥", acceptHMAC, signRaw(t, jwt.SigningMethodHS256, "rsa", x509.MarshalPKCS1PublicKey(&rsaPriv.PublicKey), sessionClaims()), false},
+		{"未开启 POLYCHAT_JWT_ACCEPT_HMAC 时的 HS256", keysOnly, hs256, false},
+		{"开启 POLYCHAT_JWT_ACCEPT_HMAC 后的 HS256", acceptHMAC, hs256, true},
+		{"HS384", acceptHMAC, signRaw(t, jwt.SigningMethodHS384, "", []byte(testSecret), sessionClaims()), false},
+		{"未签名", acceptHMAC, signRaw(t, jwt.SigningMethodNone, "", jwt.UnsafeAllowNoneSignatureType, sessionClaims()), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mustUseKeys(t, tt.env)
+			_, err := ParseToken(tt.token)
+			if (err == nil) != tt.wantOK {
+				t.Fatalf("ParseToken: err = %v，期望通过: %t", err, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseTokenAudience(t *testing.T) {
+	dir := t.TempDir()
+	edPriv := testEd25519Key(t)
+	writePKCS8(t, dir, "ed", edPriv)
+	mustUseKeys(t, map[string]string{"POLYCHAT_JWT_KEYS_DIR": dir})
+
+	session, _ := GenerateToken(1, 0, "user")
+	challenge, _ := GenerateChallengeToken(1, 0)
+	reset, _ := GeneratePasswordResetToken(1, 0)
+	verify, _ := GenerateEmailVerifyToken(1, "a@example.com")
+	state, _ := GenerateOIDCStateToken(0, 0, "s", "n", "v")
+
+	// 各用途的 token 只能被对应的 Parse 函数接受
+	parsers := map[string]func(string) (*Claims, error){
+		"session":   ParseToken,
+		"challenge": ParseChallengeToken,
+		"reset":     ParsePasswordResetToken,
+		"verify":    ParseEmailVerifyToken,
+		"state":     ParseOIDCStateToken,
+	}
+	tokens := map[string]string{"session": session, "challenge": challenge, "reset": reset, "verify": verify, "state": state}
+	for tokenName, token := range tokens {
+		for parserName, parse := range parsers {
+			_, err := parse(token)
+			if want := tokenName == parserName; (err == nil) != want {
+				t.Errorf("%s token 交给 %s 解析: err = %v，期望通过: %t", tokenName, parserName, err, want)
+			}
+		}
+	}
+
+	// 外部验证方只校验 aud 时，非会话 token 的 aud 与会话 token 不同
+	claims, err := ParseChallengeToken(challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aud := []string(claims.Audience); len(aud) != 1 || aud[0] == SessionAudience {
+		t.Fatalf("challenge token 的 aud = %v", aud)
+	}
+
+	// 缺少 aud、aud 或签发人不正确的 token 被拒绝，即使用途字段为空
+	noAud := sessionClaims()
+	delete(noAud, "aud")
+	otherAud := sessionClaims()
+	otherAud["aud"] = "other-service"
+	otherIss := sessionClaims()
+	otherIss["iss"] = "other-issuer"
+	for name, claims := range map[string]jwt.MapClaims{"缺少 aud": noAud, "其他 aud": otherAud, "其他签发人": otherIss} {
+		if _, err := ParseToken(signRaw(t, jwt.SigningMethodEdDSA, "ed", edPriv, claims)); err == nil {
+			t.Errorf("%s的 token 被接受", name)
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	rsaPriv := testRSAKey(t)
+	edPriv := testEd25519Key(t)
+	writePKCS8(t, dir, "2026-01-01", rsaPriv)
+	env := map[string]string{"POLYCHAT_JWT_KEYS_DIR": dir}
+	mustUseKeys(t, env)
+
+	oldToken, err := GenerateToken(1, 0, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertKID(t, oldToken, "2026-01-01")
+
+	// 加入新密钥：kid 最大的私钥成为签名密钥，旧 token 仍然有效
+	writePKCS8(t, dir, "2026-06-01", edPriv)
+	mustUseKeys(t, env)
+	newToken, err := GenerateToken(1, 0, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertKID(t, newToken, "2026-06-01")
+	for _, token := range []string{oldToken, newToken} {
+		if _, err := ParseToken(token); err != nil {
+			t.Fatalf("轮换期间验证 token 失败: %v", err)
+		}
+	}
+
+	// JWKS 包含全部公钥，按 kid 排序
+	keys := JWKS().Keys
+	if len(keys) != 2 || keys[0].Kid != "2026-01-01" || keys[1].Kid != "2026-06-01" {
+		t.Fatalf("JWKS = %+v", keys)
+	}
+	rsaJWK, edJWK := keys[0], keys[1]
+	if rsaJWK.Kty != "RSA" || rsaJWK.Alg != "RS256" || rsaJWK.Use != "sig" {
+		t.Errorf("RSA JWK = %+v", rsaJWK)
+	}
+	n, _ := base64.RawURLEncoding.DecodeString(rsaJWK.N)
+	e, _ := base64.RawURLEncoding.DecodeString(rsaJWK.E)
+	if new(big.Int).SetBytes(n).Cmp(rsaPriv.N) != 0 || new(big.Int).SetBytes(e).Int64() != int64(rsaPriv.E) {
+		t.Error("RSA JWK 的 n 或 e 与公钥不一致")
+	}
+	x, _ := base64.RawURLEncoding.DecodeString(edJWK.X)
+	if edJWK.Kty != "OKP" || edJWK.Crv != "Ed25519" || edJWK.Alg != "EdDSA" || string(x) != string(edPriv.Public().(ed25519.PublicKey)) {
+		t.Errorf("Ed25519 JWK = %+v", edJWK)
+	}
+
+	// 显式指定签名密钥
+	mustUseKeys(t, map[string]string{"POLYCHAT_JWT_KEYS_DIR": dir, "POLYCHAT_JWT_SIGNING_KEY": "2026-01-01"})
+	pinned, _ := GenerateToken(1, 0, "user")
+	assertKID(t, pinned, "2026-01-01")
+
+	// 加载失败时之前的密钥保持不变
+	if err := useKeys(t, map[string]string{"POLYCHAT_JWT_KEYS_DIR": dir, "POLYCHAT_JWT_SIGNING_KEY": "missing"}); err == nil {
+		t.Fatal("签名密钥不存在时应返回错误")
+	}
+	if _, err := ParseToken(pinned); err != nil {
+		t.Fatalf("加载失败后之前的密钥失效: %v", err)
+	}
+
+	// 删除旧密钥后，旧密钥签发的 token 失效
+	os.Remove(filepath.Join(dir, "2026-01-01.pem"))
+	mustUseKeys(t, env)
+	if _, err := ParseToken(oldToken); err == nil {
+		t.Fatal("删除旧密钥后旧 token 仍然有效")
+	}
+	if _, err := ParseToken(newToken); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// assertKID 检查 token 头部的 kid
+func assertKID(t *testing.T, token, want string) {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid, _ := parsed.Header["kid"].(string); kid != want {
+		t.Fatalf("kid = %q，期望 %q", kid, want)
+	}
+}
@@ -0,0 +1,71 @@
+// Package version 提供构建信息（版本号、提交哈希、构建时间）。
+//
+// 发布构建时通过 -ldflags 注入版本号和提交哈希，例如：
+//
+//	go build -ldflags "-X polychat/pkg/version.Version=v1.2.0 -X polychat/pkg/version.Commit=$(git rev-parse HEAD)"
+//
+// 未注入时从 Go 工具链写入二进制的 VCS 信息（runtime/debug.ReadBuildInfo）中读取。
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// 通过 -ldflags "-X" 注入的构建信息
+var (
+	// Version 版本号，未注入时为 "dev"
+	Version = "dev"
+	// Commit 构建时的 git 提交哈希
+	Commit = ""
+	// BuildTime 构建时间（RFC 3339）
+	BuildTime = ""
+)
+
+// Info 构建信息
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	CommitAt  string `json:"commit_time,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	Modified  bool   `json:"modified"` // 构建时工作区是否有未提交的修改
+	GoVersion string `json:"go_version"`
+}
+
+var (
+	infoOnce sync.Once
+	info     Info
+)
+
+// Get 返回当前二进制的构建信息
+func Get() Info {
+	infoOnce.Do(func() {
+		info = Info{
+			Version:   Version,
+			Commit:    Commit,
+			BuildTime: BuildTime,
+			GoVersion: runtime.Version(),
+		}
+		bi, ok := debug.ReadBuildInfo()
+		if !ok {
+			return
+		}
+		if info.Version == "dev" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				info.CommitAt = s.Value
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	})
+	return info
+}
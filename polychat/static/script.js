@@ -8,11 +8,34 @@ let contextMenuTargetId = null;
 
 // 检查是否已登录
 window.onload = function () {
-    const token = localStorage.getItem('token');
-    const username = localStorage.getItem('username');
-    const userId = localStorage.getItem('user_id');
-    if (token) {
-        showChat(username, userId);
+    // 从重置密码邮件中的链接打开：设置新密码
+    const resetToken = new URLSearchParams(location.search).get('reset_token');
+    if (resetToken) {
+        history.replaceState(null, '', location.pathname);
+        resetPassword(resetToken);
+    }
+
+    // 从验证邮件中的链接打开：完成邮箱验证
+    const verifyToken = new URLSearchParams(location.search).get('verify_token');
+    if (verifyToken) {
+        history.replaceState(null, '', location.pathname);
+        handleAuth('/api/v1/email/verify', { token: verifyToken })
+            .then(result => alert(result.msg))
+            .catch(e => console.error(e));
+    }
+
+    // 从单点登录回调跳转回来：结果在 fragment 中，由 handleSSOResult 决定是否进入聊天界面
+    if (location.hash.startsWith('#sso_')) {
+        const sso = new URLSearchParams(location.hash.slice(1));
+        history.replaceState(null, '', location.pathname);
+        handleSSOResult(sso);
+    } else {
+        const token = localStorage.getItem('token');
+        const username = localStorage.getItem('username');
+        const userId = localStorage.getItem('user_id');
+        if (token) {
+            showChat(username, userId);
+        }
     }
 
     // 全局点击关闭右键菜单
@@ -160,6 +183,7 @@ function selectFriend(targetId, note, domItem) {
     if (domItem) domItem.classList.add('active');
 
     // 清空消息区域并加载历史消息
+    document.getElementById('typing-indicator').classList.add('hidden');
     const msgList = document.getElementById('message-list');
     msgList.innerHTML = '';
     fetchHistory(targetId);
@@ -193,12 +217,21 @@ async function fetchHistory(targetId) {
             // 历史消息按时间正序显示（API可能返回倒序）
             const messages = result.data.messages.slice().reverse();
             messages.forEach(msg => {
+                if (msg.type === 'system') {
+                    appendSystemNotice(msg.content, msg.id);
+                    return;
+                }
                 const isSelf = msg.sender_id === myId;
                 appendMessage(
                     isSelf ? 'Me' : `User ${msg.sender_id}`,
                     msg.content,
-                    isSelf ? 'self' : 'other'
+                    isSelf ? 'self' : 'other',
+                    msg.id
                 );
+                // 阅后即焚消息：对方发来的未读消息显示后上报已读
+                if (!isSelf && msg.expires_in && !msg.read_at) {
+                    reportRead(msg.id);
+                }
             });
         } else {
             console.error("获取历史消息失败:", result.msg);
@@ -366,6 +399,17 @@ async function deleteFriend(targetId) {
     }
 }
 
+async function resetPassword(token) {
+    const newPassword = prompt('请输入新密码');
+    if (!newPassword) return;
+    try {
+        const result = await handleAuth('/api/v1/password/reset', { token, new_password: newPassword });
+        alert(result.msg);
+    } catch (e) {
+        console.error(e);
+    }
+}
+
 function logout() {
     localStorage.removeItem('token');
     localStorage.removeItem('username');
@@ -387,6 +431,9 @@ async function handleAuth(url, data) {
 
         if (response.ok && result.code === 200) {
             return result;
+        } else if (result.errors) {
+            // 参数校验失败：逐条列出每个字段的错误原因
+            throw new Error(Object.values(result.errors).join('\n'));
         } else {
             throw new Error(result.msg || '操作失败');
         }
@@ -429,17 +476,65 @@ function connectWS() {
             return;
         }
 
+        // 好友上线/下线，刷新好友列表中的在线状态
+        if (msg.type === 'presence') {
+            fetchFriends();
+            return;
+        }
+
+        // 对方正在输入 / 停止输入
+        if (msg.type === 'typing_start' || msg.type === 'typing_stop') {
+            if (currentChatTarget && msg.sender_id == currentChatTarget) {
+                document.getElementById('typing-indicator').classList.toggle('hidden', msg.type === 'typing_stop');
+            }
+            return;
+        }
+
+        // 服务器确认：为刚发送的消息记录服务器分配的ID
+        if (msg.type === 'ack') {
+            const pending = document.querySelector('#message-list .message.self:not([data-id])');
+            if (pending) pending.dataset.id = msg.id;
+            return;
+        }
+
+        // 消息被删除（阅后即焚到期）
+        if (msg.type === 'recall') {
+            const el = document.querySelector(`#message-list [data-id="${msg.id}"]`);
+            if (el) el.remove();
+            return;
+        }
+
+        // 会话内的系统通知
+        if (msg.type === 'system') {
+            const peer = msg.sender_id == myUserId() ? msg.receiver_id : msg.sender_id;
+            if (currentChatTarget && peer == currentChatTarget) {
+                appendSystemNotice(msg.content, msg.id);
+            }
+            return;
+        }
+
         // 普通聊天消息
-        if (msg.sender_id) {
+        if (msg.type === 'chat' && msg.sender_id) {
+            if (msg.sender_id == currentChatTarget) {
+                document.getElementById('typing-indicator').classList.add('hidden');
+            }
             // 仅当消息来自当前聊天对象时才显示
             if (currentChatTarget && msg.sender_id == currentChatTarget) {
-                appendMessage(`User ${msg.sender_id}`, msg.content, 'other');
+                appendMessage(`User ${msg.sender_id}`, msg.content, 'other', msg.id);
+                if (msg.expires_in) {
+                    reportRead(msg.id);
+                }
             }
         }
     };
 
-    ws.onclose = () => {
+    ws.onclose = (event) => {
         console.log("WebSocket 连接断开");
+        // 4001: 密码已修改或重置，登录凭证失效
+        if (event.code === 4001) {
+            alert('登录已失效，请重新登录');
+            logout();
+        }
     };
 
     ws.onerror = (err) => {
@@ -464,6 +559,7 @@ function sendMessage() {
 
     if (ws && ws.readyState === WebSocket.OPEN) {
         ws.send(JSON.stringify(msg));
+        stopTyping();
         appendMessage("Me", content, "self");
         document.getElementById('msg-content').value = '';
     } else {
@@ -472,10 +568,33 @@ function sendMessage() {
     }
 }
 
-function appendMessage(sender, text, type) {
+function myUserId() {
+    return parseInt(localStorage.getItem('user_id'));
+}
+
+// 上报已读，阅后即焚消息从此刻开始计时
+function reportRead(id) {
+    if (id && ws && ws.readyState === WebSocket.OPEN) {
+        ws.send(JSON.stringify({ type: 'read', id: id }));
+    }
+}
+
+function appendSystemNotice(text, id) {
+    const list = document.getElementById('message-list');
+    const div = document.createElement('div');
+    div.className = 'message-meta';
+    div.style.textAlign = 'center';
+    div.innerText = text;
+    if (id) div.dataset.id = id;
+    list.appendChild(div);
+    list.scrollTop = list.scrollHeight;
+}
+
+function appendMessage(sender, text, type, id) {
     const list = document.getElementById('message-list');
     const div = document.createElement('div');
     div.className = `message ${type}`;
+    if (id) div.dataset.id = id;
 
     // 如果是对方发的消息，不显示名字在气泡里，而是显示在气泡上方 (meta)
     // 这里为了简单，直接把内容放进去
@@ -497,13 +616,52 @@ function appendMessage(sender, text, type) {
     list.scrollTop = list.scrollHeight;
 }
 
+// handleSSOResult 处理单点登录回调的结果
+async function handleSSOResult(sso) {
+    if (sso.get('sso_error') || sso.get('sso_linked')) {
+        alert(sso.get('sso_linked') ? '外部账号绑定成功' : '单点登录失败：' + sso.get('sso_error'));
+        // 绑定外部账号时用户本来就已登录
+        if (localStorage.getItem('token')) {
+            showChat(localStorage.getItem('username'), localStorage.getItem('user_id'));
+        }
+        return;
+    }
+    let result = {
+        token: sso.get('sso_token'),
+        user_id: sso.get('user_id'),
+        username: sso.get('username'),
+    };
+    // 开启了两步验证：与密码登录相同，提交验证码换取登录凭证
+    if (sso.get('sso_challenge')) {
+        const code = prompt('请输入两步验证码（或恢复码）');
+        if (!code) return;
+        try {
+            result = await handleAuth('/api/v1/login/2fa', { challenge_token: sso.get('sso_challenge'), code });
+        } catch (e) {
+            console.error(e);
+            return;
+        }
+    }
+    if (!result.token) return;
+    localStorage.setItem('token', result.token);
+    localStorage.setItem('username', result.username);
+    localStorage.setItem('user_id', result.user_id);
+    showChat(result.username, result.user_id);
+}
+
 document.getElementById('login-form').addEventListener('submit', async (e) => {
     e.preventDefault();
     const username = document.getElementById('login-username').value;
     const password = document.getElementById('login-password').value;
 
     try {
-        const result = await handleAuth('/api/v1/login', { username, password });
+        let result = await handleAuth('/api/v1/login', { username, password });
+        // 开启了两步验证：提交验证器应用中的验证码（或恢复码）换取登录凭证
+        if (result.two_factor_required) {
+            const code = prompt('请输入两步验证码（或恢复码）');
+            if (!code) return;
+            result = await handleAuth('/api/v1/login/2fa', { challenge_token: result.challenge_token, code });
+        }
         localStorage.setItem('token', result.token);
         localStorage.setItem('username', result.username);
         localStorage.setItem('user_id', result.user_id);
@@ -517,10 +675,11 @@ document.getElementById('register-form').addEventListener('submit', async (e) =>
     e.preventDefault();
     const username = document.getElementById('reg-username').value;
     const password = document.getElementById('reg-password').value;
+    const email = document.getElementById('reg-email').value;
 
     try {
-        await handleAuth('/api/v1/register', { username, password });
-        alert('注册成功，请登录');
+        await handleAuth('/api/v1/register', { username, password, email });
+        alert(email ? '注册成功，验证邮件已发送，请登录' : '注册成功，请登录');
         toggleView();
     } catch (e) {
         console.error(e);
@@ -670,3 +829,40 @@ document.getElementById('msg-content').addEventListener('keypress', function (e)
         sendMessage();
     }
 });
+
+// --- 正在输入提示 ---
+
+let typingTarget = null;     // 正在向谁输入
+let lastTypingSent = 0;      // 上次发送 typing_start 的时间
+let typingIdleTimer = null;  // 停止输入的计时器
+
+function sendTypingFrame(type, target) {
+    if (target && ws && ws.readyState === WebSocket.OPEN) {
+        ws.send(JSON.stringify({ type: type, receiver_id: parseInt(target) }));
+    }
+}
+
+function stopTyping() {
+    clearTimeout(typingIdleTimer);
+    if (typingTarget) {
+        sendTypingFrame('typing_stop', typingTarget);
+        typingTarget = null;
+    }
+}
+
+document.getElementById('msg-content').addEventListener('input', function () {
+    const target = document.getElementById('receiver-id').value;
+    if (!target) return;
+    if (typingTarget && typingTarget !== target) {
+        stopTyping();
+    }
+    // 每 3 秒最多发送一次 typing_start，服务器会在超时后自动结束输入状态
+    const now = Date.now();
+    if (typingTarget !== target || now - lastTypingSent > 3000) {
+        sendTypingFrame('typing_start', target);
+        typingTarget = target;
+        lastTypingSent = now;
+    }
+    clearTimeout(typingIdleTimer);
+    typingIdleTimer = setTimeout(stopTyping, 4000);
+});